@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/nbutton23/zxcvbn-go"
+	"github.com/nbutton23/zxcvbn-go/match"
+)
+
+// PasswordFeedback explains what makes a password guessable
+type PasswordFeedback struct {
+	Warning     string   `json:"warning"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// PasswordStrength is the zxcvbn estimate of how hard a password is to guess
+type PasswordStrength struct {
+	// Score goes from 0, too guessable, to 4, very unguessable
+	Score     int              `json:"score"`
+	Feedback  PasswordFeedback `json:"feedback"`
+	CrackTime string           `json:"crack_time"`
+}
+
+// ScorePassword estimates the strength of password. Passwords built from
+// userInputs, such as the user's name or email, score lower.
+func ScorePassword(password string, userInputs ...string) PasswordStrength {
+	result := zxcvbn.PasswordStrength(password, userInputs)
+	return PasswordStrength{
+		Score:     result.Score,
+		Feedback:  passwordFeedback(password, result.Score, result.MatchSequence),
+		CrackTime: result.CrackTimeDisplay,
+	}
+}
+
+// passwordFeedback describes the longest guessable part of the password,
+// following the feedback of the original zxcvbn. Strong passwords get none.
+func passwordFeedback(password string, score int, sequence []match.Match) PasswordFeedback {
+	feedback := PasswordFeedback{Suggestions: []string{}}
+	if password == "" {
+		feedback.Suggestions = append(feedback.Suggestions, "Use a few words, avoid common phrases", "No need for symbols, digits, or uppercase letters")
+		return feedback
+	}
+	if score > 2 {
+		return feedback
+	}
+
+	feedback.Suggestions = append(feedback.Suggestions, "Add another word or two. Uncommon words are better.")
+	var longest *match.Match
+	for i := range sequence {
+		if sequence[i].Pattern == "bruteforce" {
+			continue
+		}
+		if longest == nil || len(sequence[i].Token) > len(longest.Token) {
+			longest = &sequence[i]
+		}
+	}
+	if longest == nil {
+		return feedback
+	}
+
+	switch longest.Pattern {
+	case "dictionary":
+		dictionary, leet := strings.CutSuffix(longest.DictionaryName, "_3117")
+		switch {
+		case dictionary == "Passwords" && len(sequence) == 1:
+			feedback.Warning = "This is a very common password"
+		case dictionary == "Passwords":
+			feedback.Warning = "This is similar to a commonly used password"
+		case dictionary == "English" && len(sequence) == 1:
+			feedback.Warning = "A word by itself is easy to guess"
+		case dictionary == "MaleNames" || dictionary == "FemaleNames" || dictionary == "Surname":
+			feedback.Warning = "Names and surnames by themselves are easy to guess"
+		case dictionary == "user_inputs":
+			feedback.Warning = "Passwords containing your personal details are easy to guess"
+		}
+		if strings.ToLower(longest.Token) != longest.Token {
+			feedback.Suggestions = append(feedback.Suggestions, "Capitalization doesn't help very much")
+		}
+		if leet {
+			feedback.Suggestions = append(feedback.Suggestions, "Predictable substitutions like '@' instead of 'a' don't help very much")
+		}
+	case "spatial":
+		feedback.Warning = "Short keyboard patterns are easy to guess"
+		feedback.Suggestions = append(feedback.Suggestions, "Use a longer keyboard pattern with more turns")
+	case "repeat":
+		feedback.Warning = `Repeats like "aaa" are easy to guess`
+		feedback.Suggestions = append(feedback.Suggestions, "Avoid repeated words and characters")
+	case "sequence":
+		feedback.Warning = "Sequences like abc or 6543 are easy to guess"
+		feedback.Suggestions = append(feedback.Suggestions, "Avoid sequences")
+	case "date":
+		feedback.Warning = "Dates are often easy to guess"
+		feedback.Suggestions = append(feedback.Suggestions, "Avoid dates and years that are associated with you")
+	}
+	return feedback
+}
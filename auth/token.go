@@ -0,0 +1,77 @@
+// Package auth issues and verifies the JWTs used to authenticate API requests
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"go-api/models"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Claims are the JWT claims identifying a user. The subject is the user ID.
+type Claims struct {
+	jwt.RegisteredClaims
+	Role string `json:"role"`
+	// ImpersonatedBy is the ID of the admin acting as the user, if any
+	ImpersonatedBy *uint `json:"impersonated_by,omitempty"`
+}
+
+// Issuer signs and verifies HS256 tokens with a shared secret
+type Issuer struct {
+	secret []byte
+}
+
+func NewIssuer(secret []byte) *Issuer {
+	return &Issuer{secret: secret}
+}
+
+// Issue returns a token for user valid for ttl. A non-nil impersonatedBy marks
+// the token as issued to that admin acting as user.
+func (i *Issuer) Issue(user models.User, ttl time.Duration, impersonatedBy *uint) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Role:           user.Role,
+		ImpersonatedBy: impersonatedBy,
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(i.secret)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("sign token: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+// Parse verifies token and returns its claims. The claims of an expired
+// token are returned along with an error wrapping jwt.ErrTokenExpired, its
+// signature has been checked by then.
+func (i *Issuer) Parse(token string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return i.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return &claims, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// UserID returns the user ID held in the subject claim
+func (c *Claims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 0)
+	if err != nil || id == 0 {
+		return 0, errors.New("invalid subject claim")
+	}
+	return uint(id), nil
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type entry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// Cache is an in-process store for serialized responses with a fixed TTL
+type Cache struct {
+	TTL    time.Duration
+	items  sync.Map
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// Stats holds cache hit and miss counters
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+func New(ttl time.Duration) *Cache {
+	return &Cache{TTL: ttl}
+}
+
+// Get returns the cached value for key if present and not expired
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if v, ok := c.items.Load(key); ok {
+		e := v.(entry)
+		if time.Now().Before(e.expiresAt) {
+			c.hits.Add(1)
+			return e.value, true
+		}
+		c.items.CompareAndDelete(key, v)
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+func (c *Cache) Set(key string, value []byte) {
+	c.items.Store(key, entry{value: value, expiresAt: time.Now().Add(c.TTL)})
+}
+
+// InvalidatePattern removes all keys matching pattern. A trailing "*" matches
+// any suffix, otherwise the key must match exactly.
+func (c *Cache) InvalidatePattern(pattern string) {
+	prefix, wildcard := strings.CutSuffix(pattern, "*")
+	c.items.Range(func(k, _ any) bool {
+		key := k.(string)
+		if key == pattern || (wildcard && strings.HasPrefix(key, prefix)) {
+			c.items.Delete(key)
+		}
+		return true
+	})
+}
+
+func (c *Cache) Stats() Stats {
+	return Stats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"gorm.io/gorm"
+)
+
+// AuditPlugin is a GORM plugin that fills CreatedBy and UpdatedBy columns
+// from the user ID stored in the statement context
+type AuditPlugin struct{}
+
+func (AuditPlugin) Name() string {
+	return "audit"
+}
+
+func (AuditPlugin) Initialize(db *gorm.DB) error {
+	if err := db.Callback().Create().Before("gorm:create").Register("audit:before_create", setAuditColumns("CreatedBy", "UpdatedBy")); err != nil {
+		return err
+	}
+	return db.Callback().Update().Before("gorm:update").Register("audit:before_update", setAuditColumns("UpdatedBy"))
+}
+
+func setAuditColumns(columns ...string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Error != nil || db.Statement.Schema == nil {
+			return
+		}
+
+		userID, ok := UserIDFromContext(db.Statement.Context)
+		if !ok {
+			return
+		}
+
+		for _, column := range columns {
+			if db.Statement.Schema.LookUpField(column) != nil {
+				db.Statement.SetColumn(column, &userID, true)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package config
+
+import "context"
+
+type userIDKey struct{}
+
+type roleKey struct{}
+
+type tenantIDKey struct{}
+
+type impersonatedByKey struct{}
+
+type deviceTrustedKey struct{}
+
+type apiKeyIDKey struct{}
+
+// ContextWithUserID returns a copy of ctx carrying the authenticated user ID.
+// Authentication middleware should call this so that AuditPlugin can attribute writes.
+func ContextWithUserID(ctx context.Context, userID uint) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+// UserIDFromContext returns the authenticated user ID stored in ctx, if any
+func UserIDFromContext(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	userID, ok := ctx.Value(userIDKey{}).(uint)
+	return userID, ok
+}
+
+// ContextWithRole returns a copy of ctx carrying the authenticated user's role
+func ContextWithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFromContext returns the authenticated user's role stored in ctx, if any
+func RoleFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	role, ok := ctx.Value(roleKey{}).(string)
+	return role, ok
+}
+
+// ContextWithTenantID returns a copy of ctx carrying the request's tenant.
+// TenantPlugin scopes database operations to it.
+func ContextWithTenantID(ctx context.Context, tenantID uint) context.Context {
+	return context.WithValue(ctx, tenantIDKey{}, tenantID)
+}
+
+// TenantIDFromContext returns the tenant ID stored in ctx, if any
+func TenantIDFromContext(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	tenantID, ok := ctx.Value(tenantIDKey{}).(uint)
+	return tenantID, ok
+}
+
+// ContextWithImpersonatedBy returns a copy of ctx recording that the
+// authenticated user is being impersonated by the admin adminID
+func ContextWithImpersonatedBy(ctx context.Context, adminID uint) context.Context {
+	return context.WithValue(ctx, impersonatedByKey{}, adminID)
+}
+
+// ImpersonatedByFromContext returns the ID of the admin impersonating the
+// authenticated user stored in ctx, if any
+func ImpersonatedByFromContext(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	adminID, ok := ctx.Value(impersonatedByKey{}).(uint)
+	return adminID, ok
+}
+
+// ContextWithDeviceTrusted returns a copy of ctx recording whether the known
+// device the request came from is trusted
+func ContextWithDeviceTrusted(ctx context.Context, trusted bool) context.Context {
+	return context.WithValue(ctx, deviceTrustedKey{}, trusted)
+}
+
+// DeviceTrustedFromContext returns whether the request's device is trusted,
+// ok is false when the request did not identify a known device
+func DeviceTrustedFromContext(ctx context.Context) (trusted, ok bool) {
+	if ctx == nil {
+		return false, false
+	}
+	trusted, ok = ctx.Value(deviceTrustedKey{}).(bool)
+	return trusted, ok
+}
+
+// ContextWithAPIKeyID returns a copy of ctx recording that the user was
+// authenticated with the API key keyID
+func ContextWithAPIKeyID(ctx context.Context, keyID uint) context.Context {
+	return context.WithValue(ctx, apiKeyIDKey{}, keyID)
+}
+
+// APIKeyIDFromContext returns the ID of the API key the user authenticated
+// with stored in ctx, if any
+func APIKeyIDFromContext(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	keyID, ok := ctx.Value(apiKeyIDKey{}).(uint)
+	return keyID, ok
+}
@@ -1,25 +1,220 @@
 package config
 
 import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/glebarez/sqlite" // slower but portable sqlite driver, that does not need CGO. In case of high traffic, consider using non portable CGO one
+	"github.com/prometheus/client_golang/prometheus"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
-func InitDB(dbPath string, log *slog.Logger) *gorm.DB {
+// DBConfig holds database connection options
+type DBConfig struct {
+	// Path is a database file name or a full SQLite URI, see SQLiteDSN
+	Path string
+	// AutoVacuum enables incremental auto vacuum and reclaims free pages on startup
+	AutoVacuum bool
+	// CheckpointOnClose writes the WAL back to the database file and truncates it in CloseDB
+	CheckpointOnClose bool
+	// MaxQueryDepth rejects raw SQL nesting SELECTs deeper than this or using
+	// WITH RECURSIVE, see QueryDepthPlugin. Zero disables the check.
+	MaxQueryDepth int
+	// DefaultIsolation is the level transactions begin at unless they ask for
+	// another one, see WithIsolation
+	DefaultIsolation sql.IsolationLevel
+	// DriverName is the database/sql driver opening Path, the bundled SQLite
+	// driver when empty. It allows wrapping the driver, e.g. for tracing.
+	// With EncryptionKey it must wrap a SQLCipher driver.
+	DriverName string
+	// EncryptionKey is the hex encoded 256-bit key of a SQLCipher encrypted
+	// database, the database is not encrypted when empty. Path must then be a
+	// file name. Encryption needs a binary built with CGO_ENABLED=1 and
+	// -tags sqlcipher, otherwise opening fails with ErrEncryptionUnavailable.
+	EncryptionKey string
+	// RetryAttempts limits how often WaitInitDB and MustInitDB try to open the
+	// database, MustInitDB tries once when it is below 2
+	RetryAttempts int
+	// RetryDelay is the wait between attempts, one second when zero
+	RetryDelay time.Duration
+	// Metrics receives the query latency histogram of GORMPrometheus, query
+	// latency is not recorded when nil
+	Metrics prometheus.Registerer
+}
+
+// incrementalVacuumPages is how many free pages are reclaimed per startup
+const incrementalVacuumPages = 100
+
+// TryInitDB opens the database described by cfg, returning an error instead
+// of panicking so embedding applications can recover
+func TryInitDB(cfg DBConfig, log *slog.Logger) (*gorm.DB, error) {
 	// Configure GORM logger to use slog
 	gormLogger := logger.Default.LogMode(logger.Info)
 
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+	// Plain file names get foreign keys enabled on every pooled connection
+	dsn := cfg.Path
+	driverName := cfg.DriverName
+	if cfg.EncryptionKey != "" {
+		if driverName == "" && sqlcipherDriver == "" {
+			log.Error("Failed to connect to database", "error", ErrEncryptionUnavailable, "path", cfg.Path)
+			return nil, ErrEncryptionUnavailable
+		}
+		var err error
+		if dsn, err = sqlcipherDSN(cfg.Path, cfg.EncryptionKey); err != nil {
+			log.Error("Failed to connect to database", "error", err, "path", cfg.Path)
+			return nil, err
+		}
+		driverName = cmp.Or(driverName, sqlcipherDriver)
+	} else if !isDSN(dsn) {
+		dsn = SQLiteDSN{Path: cfg.Path, ForeignKeys: true}.Build()
+	}
+
+	db, err := gorm.Open(sqlite.Dialector{DriverName: driverName, DSN: dsn}, &gorm.Config{
 		Logger: gormLogger,
 	})
 	if err != nil {
-		log.Error("Failed to connect to database", "error", err, "path", dbPath)
-		panic(err)
+		log.Error("Failed to connect to database", "error", err, "path", cfg.Path)
+		return nil, err
+	}
+
+	if cfg.EncryptionKey != "" {
+		if err := verifyEncryptionKey(db); err != nil {
+			log.Error("Failed to decrypt database", "error", err, "path", cfg.Path)
+			closeQuietly(db)
+			return nil, err
+		}
+	}
+
+	if db.Dialector.Name() == "sqlite" {
+		// SQLite ships with foreign key constraints disabled, enforce them
+		// even when a custom DSN leaves them out
+		if err := db.Exec("PRAGMA foreign_keys = ON").Error; err != nil {
+			log.Error("Failed to enable foreign keys", "error", err, "path", cfg.Path)
+			closeQuietly(db)
+			return nil, err
+		}
+
+		// auto_vacuum only takes effect on new databases or after a full VACUUM
+		if cfg.AutoVacuum {
+			if err := db.Exec("PRAGMA auto_vacuum = INCREMENTAL").Error; err != nil {
+				log.Error("Failed to enable auto vacuum", "error", err, "path", cfg.Path)
+				closeQuietly(db)
+				return nil, err
+			}
+			if err := db.Exec("PRAGMA incremental_vacuum(?)", incrementalVacuumPages).Error; err != nil {
+				log.Warn("Failed to run incremental vacuum", "error", err, "path", cfg.Path)
+			}
+		}
 	}
 
-	log.Info("Database connected successfully", "path", dbPath)
+	if cfg.MaxQueryDepth > 0 {
+		if err := db.Use(QueryDepthPlugin{MaxDepth: cfg.MaxQueryDepth}); err != nil {
+			log.Error("Failed to register query depth plugin", "error", err, "path", cfg.Path)
+			closeQuietly(db)
+			return nil, err
+		}
+	}
+
+	if cfg.Metrics != nil {
+		if err := db.Use(GORMPrometheus{Registerer: cfg.Metrics}); err != nil {
+			log.Error("Failed to register Prometheus plugin", "error", err, "path", cfg.Path)
+			closeQuietly(db)
+			return nil, err
+		}
+	}
+
+	if cfg.DefaultIsolation != sql.LevelDefault {
+		if err := useDefaultIsolation(db, cfg.DefaultIsolation); err != nil {
+			log.Error("Failed to set default isolation level", "error", err, "path", cfg.Path)
+			closeQuietly(db)
+			return nil, err
+		}
+	}
+
+	log.Info("Database connected successfully", "path", cfg.Path, "auto_vacuum", cfg.AutoVacuum, "encrypted", cfg.EncryptionKey != "")
+	return db, nil
+}
+
+// dbStartupRetryInterval is the default wait between connection attempts
+const dbStartupRetryInterval = time.Second
+
+// WaitInitDB is like TryInitDB but retries, for databases that are locked or
+// not yet reachable at startup, such as a database container still booting.
+// It waits cfg.RetryDelay between attempts and gives up after
+// cfg.RetryAttempts attempts, once timeout has passed or when ctx is done.
+// A zero RetryAttempts or timeout leaves that limit out.
+func WaitInitDB(ctx context.Context, cfg DBConfig, log *slog.Logger, timeout time.Duration) (*gorm.DB, error) {
+	delay := cmp.Or(cfg.RetryDelay, dbStartupRetryInterval)
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		db, err := TryInitDB(cfg, log)
+		if err == nil {
+			return db, nil
+		}
+		if attempt == cfg.RetryAttempts || (timeout > 0 && time.Now().Add(delay).After(deadline)) {
+			return nil, fmt.Errorf("database unavailable after %d attempts: %w", attempt, err)
+		}
+
+		log.Info("Database unavailable, retrying", "attempt", attempt, "retry_in", delay, "path", cfg.Path)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// MustInitDB is like TryInitDB but panics on error, for use during startup.
+// It retries like WaitInitDB when cfg.RetryAttempts is 2 or more.
+func MustInitDB(cfg DBConfig, log *slog.Logger) *gorm.DB {
+	if cfg.RetryAttempts < 2 {
+		db, err := TryInitDB(cfg, log)
+		if err != nil {
+			panic(err)
+		}
+		return db
+	}
+
+	db, err := WaitInitDB(context.Background(), cfg, log, 0)
+	if err != nil {
+		panic(err)
+	}
 	return db
 }
+
+// Checkpoint writes all WAL pages back to the database file and truncates the
+// WAL. It is a no-op for databases not in WAL mode.
+func Checkpoint(db *gorm.DB) error {
+	if err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error; err != nil {
+		return fmt.Errorf("checkpoint WAL: %w", err)
+	}
+	return nil
+}
+
+// CloseDB closes the database connections, checkpointing the WAL first when
+// cfg.CheckpointOnClose is set
+func CloseDB(db *gorm.DB, cfg DBConfig) error {
+	if cfg.CheckpointOnClose {
+		if err := Checkpoint(db); err != nil {
+			return err
+		}
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// closeQuietly releases the connections of a database that failed to initialize
+func closeQuietly(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dsnPrefix marks a database path as an SQLite URI rather than a file name
+const dsnPrefix = "file:"
+
+// SQLiteDSN builds an SQLite URI applying connection pragmas. Unlike a PRAGMA
+// statement run after opening, these apply to every connection in the pool.
+// Zero values leave the SQLite default in place.
+type SQLiteDSN struct {
+	Path string
+	// BusyTimeout is how long in milliseconds to wait on a locked database
+	BusyTimeout int
+	// CacheSize is the page cache size, in pages or in KiB when negative
+	CacheSize   int
+	ForeignKeys bool
+	// JournalMode is e.g. WAL, DELETE or MEMORY
+	JournalMode string
+}
+
+// Build returns the URI, e.g. file:app.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL).
+// Pragmas use the _pragma parameter understood by the pure Go SQLite driver.
+func (d SQLiteDSN) Build() string {
+	var pragmas []string
+	if d.BusyTimeout != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("busy_timeout(%d)", d.BusyTimeout))
+	}
+	if d.CacheSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("cache_size(%d)", d.CacheSize))
+	}
+	if d.ForeignKeys {
+		pragmas = append(pragmas, "foreign_keys(1)")
+	}
+	if d.JournalMode != "" {
+		pragmas = append(pragmas, "journal_mode("+d.JournalMode+")")
+	}
+
+	dsn := dsnPrefix + d.Path
+	for i, pragma := range pragmas {
+		sep := "&"
+		if i == 0 {
+			sep = "?"
+		}
+		dsn += sep + "_pragma=" + pragma
+	}
+	return dsn
+}
+
+// isDSN reports whether path is a full SQLite URI instead of a file name
+func isDSN(path string) bool {
+	return strings.HasPrefix(path, dsnPrefix)
+}
+
+// dsnFile returns the database file name from a path or SQLite URI
+func dsnFile(path string) string {
+	if !isDSN(path) {
+		return path
+	}
+	file, _, _ := strings.Cut(strings.TrimPrefix(path, dsnPrefix), "?")
+	return file
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ErrEncryptionUnavailable is returned when DBConfig.EncryptionKey is set in a
+// binary built without SQLCipher support, see sqlcipherDriver
+var ErrEncryptionUnavailable = errors.New("database encryption requires a build with CGO_ENABLED=1 and -tags sqlcipher")
+
+// encryptionKeySize is the length of a raw SQLCipher key in bytes
+const encryptionKeySize = 32
+
+// sqlcipherDSN returns the go-sqlcipher DSN opening path with the hex encoded
+// 256-bit key. The driver takes mattn/go-sqlite3 style parameters rather than
+// the _pragma ones of SQLiteDSN, so path must be a plain file name.
+func sqlcipherDSN(path, key string) (string, error) {
+	if isDSN(path) {
+		return "", fmt.Errorf("database encryption needs a file name, not the URI %q", path)
+	}
+	if raw, err := hex.DecodeString(key); err != nil || len(raw) != encryptionKeySize {
+		return "", fmt.Errorf("encryption key must be %d hex encoded bytes", encryptionKeySize)
+	}
+	return fmt.Sprintf("%s?_pragma_key=x'%s'&_foreign_keys=1", path, key), nil
+}
+
+// verifyEncryptionKey reads the schema, SQLCipher only checks the key once
+// the database is read and fails with "file is not a database" otherwise
+func verifyEncryptionKey(db *gorm.DB) error {
+	var tables int64
+	if err := db.Raw("SELECT COUNT(*) FROM sqlite_master").Scan(&tables).Error; err != nil {
+		return fmt.Errorf("wrong encryption key or unencrypted database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// HealthChecker reports whether a dependency of the server is usable
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
+// DBHealthChecker checks that the database answers a ping
+type DBHealthChecker struct {
+	db *gorm.DB
+}
+
+func NewDBHealthChecker(db *gorm.DB) *DBHealthChecker {
+	return &DBHealthChecker{db: db}
+}
+
+// Check pings the database, returning why it is unreachable
+func (h *DBHealthChecker) Check(ctx context.Context) error {
+	sqlDB, err := h.db.DB()
+	if err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	return nil
+}
+
+// CompositeHealthChecker runs every checker in order and fails when any of
+// them does, joining their errors
+type CompositeHealthChecker []HealthChecker
+
+func (checkers CompositeHealthChecker) Check(ctx context.Context) error {
+	var errs []error
+	for _, checker := range checkers {
+		if err := checker.Check(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,10 @@
+package config
+
+import "gorm.io/gorm"
+
+// EnsureIndexes creates the indexes on users that AutoMigrate does not derive
+// from the models by applying the pending migrations, which hold them. It is
+// safe to call on every startup.
+func EnsureIndexes(db *gorm.DB) error {
+	return RunMigrations(db)
+}
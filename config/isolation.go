@@ -0,0 +1,129 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// isolationLevels maps the names accepted by ParseIsolationLevel to levels
+var isolationLevels = map[string]sql.IsolationLevel{
+	"default":          sql.LevelDefault,
+	"read-uncommitted": sql.LevelReadUncommitted,
+	"read-committed":   sql.LevelReadCommitted,
+	"repeatable-read":  sql.LevelRepeatableRead,
+	"serializable":     sql.LevelSerializable,
+}
+
+// ParseIsolationLevel parses an isolation level name such as "read-committed"
+// or "SERIALIZABLE", ignoring case and treating spaces and underscores as dashes
+func ParseIsolationLevel(name string) (sql.IsolationLevel, error) {
+	key := strings.NewReplacer(" ", "-", "_", "-").Replace(strings.ToLower(strings.TrimSpace(name)))
+	level, ok := isolationLevels[key]
+	if !ok {
+		return sql.LevelDefault, fmt.Errorf("unknown isolation level %q", name)
+	}
+	return level, nil
+}
+
+// WithIsolation begins a transaction at the given isolation level. As with
+// db.Begin, a failure to begin is recorded in the returned Error.
+//
+// SQLite transactions are always serializable, the level only changes
+// behavior on drivers that support other levels.
+func WithIsolation(db *gorm.DB, level sql.IsolationLevel) *gorm.DB {
+	return db.Begin(&sql.TxOptions{Isolation: level})
+}
+
+// TxConfig configures transactions run with Run
+type TxConfig struct {
+	// Isolation is the level transactions begin at
+	Isolation sql.IsolationLevel
+	// MaxAttempts is how often a transaction failing with a transient error,
+	// such as a serialization failure, is run; values below 2 never retry
+	MaxAttempts int
+	// Backoff is the base wait between attempts, doubled after each one
+	Backoff time.Duration
+}
+
+// Run calls fn in a transaction, committing when it returns nil and rolling
+// back otherwise. The whole transaction is run again when it fails with a
+// transient error, since retrying a single statement would keep its stale
+// snapshot.
+func (cfg TxConfig) Run(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	for attempt := 0; ; attempt++ {
+		err := runTx(db, cfg.Isolation, fn)
+		if attempt+1 >= cfg.MaxAttempts || !IsTransientError(err) {
+			return err
+		}
+
+		select {
+		case <-db.Statement.Context.Done():
+			return err
+		case <-time.After(backoff(cfg.Backoff, attempt)):
+		}
+	}
+}
+
+func runTx(db *gorm.DB, level sql.IsolationLevel, fn func(tx *gorm.DB) error) (err error) {
+	tx := WithIsolation(db, level)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	err = fn(tx)
+	panicked = false
+	if err != nil {
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// isolationPool begins transactions that don't ask for an isolation level at
+// a configured default one
+type isolationPool struct {
+	*sql.DB
+	level sql.IsolationLevel
+}
+
+// BeginTx implements gorm.TxBeginner
+func (p isolationPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if opts == nil {
+		opts = &sql.TxOptions{}
+	}
+	if opts.Isolation == sql.LevelDefault {
+		withLevel := *opts
+		withLevel.Isolation = p.level
+		opts = &withLevel
+	}
+	return p.DB.BeginTx(ctx, opts)
+}
+
+// GetDBConn implements gorm.GetDBConnector so db.DB() keeps working
+func (p isolationPool) GetDBConn() (*sql.DB, error) {
+	return p.DB, nil
+}
+
+// useDefaultIsolation makes transactions on db begin at level unless they
+// ask for another one
+func useDefaultIsolation(db *gorm.DB, level sql.IsolationLevel) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	pool := isolationPool{DB: sqlDB, level: level}
+	db.ConnPool = pool
+	db.Statement.ConnPool = pool
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"io"
+	"log/slog"
+)
+
+// SetupLogger configures slog with the specified level and format.
+// addSource includes the caller's file and line, which is costly at high QPS.
+func SetupLogger(w io.Writer, level, format string, addSource bool) *slog.Logger {
+	var logLevel slog.Level
+	switch level {
+	case "debug":
+		logLevel = slog.LevelDebug
+	case "info":
+		logLevel = slog.LevelInfo
+	case "warn":
+		logLevel = slog.LevelWarn
+	case "error":
+		logLevel = slog.LevelError
+	default:
+		logLevel = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:     logLevel,
+		AddSource: addSource,
+	}
+
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		handler = slog.NewTextHandler(w, opts)
+	}
+
+	return slog.New(handler)
+}
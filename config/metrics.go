@@ -0,0 +1,65 @@
+package config
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+// DBMetricsCollector exposes the connection pool statistics of a GORM
+// database, read from sql.DBStats on every scrape
+type DBMetricsCollector struct {
+	db *gorm.DB
+
+	openConnections   *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+func NewDBMetricsCollector(db *gorm.DB) *DBMetricsCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("db", "", name), help, nil, nil)
+	}
+	return &DBMetricsCollector{
+		db:                db,
+		openConnections:   desc("open_connections", "Number of established connections, both in use and idle."),
+		inUse:             desc("in_use_connections", "Number of connections currently in use."),
+		idle:              desc("idle_connections", "Number of idle connections."),
+		waitCount:         desc("wait_count_total", "Total number of connections waited for."),
+		waitDuration:      desc("wait_duration_seconds_total", "Total time blocked waiting for a new connection."),
+		maxIdleClosed:     desc("max_idle_closed_total", "Total number of connections closed due to the idle connection limit."),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "Total number of connections closed due to the connection lifetime limit."),
+	}
+}
+
+func (m *DBMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.openConnections
+	ch <- m.inUse
+	ch <- m.idle
+	ch <- m.waitCount
+	ch <- m.waitDuration
+	ch <- m.maxIdleClosed
+	ch <- m.maxLifetimeClosed
+}
+
+// Collect reports the current pool statistics. Cumulative statistics are
+// reported as counters, the rest as gauges.
+func (m *DBMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(m.openConnections, err)
+		return
+	}
+
+	stats := sqlDB.Stats()
+	ch <- prometheus.MustNewConstMetric(m.openConnections, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(m.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(m.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(m.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(m.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(m.maxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(m.maxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
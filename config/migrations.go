@@ -0,0 +1,208 @@
+package config
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync/atomic"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"gorm.io/gorm"
+)
+
+// migrationsTable records the schema version and whether the last migration failed
+const migrationsTable = "schema_migrations"
+
+// migrationFiles are the versioned schema changes applied on top of what
+// AutoMigrate derives from the models, as golang-migrate
+// <version>_<name>.up.sql and .down.sql files
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrMigrateDownTooFar is returned when rolling back more migrations than are applied
+var ErrMigrateDownTooFar = errors.New("cannot roll back past the initial schema")
+
+// SchemaVersion returns the version of the newest applied migration, 0 when
+// none was applied yet. It fails when the last migration did not complete.
+func SchemaVersion(db *gorm.DB) (uint, error) {
+	if !db.Migrator().HasTable(migrationsTable) {
+		return 0, nil
+	}
+	version, dirty, err := (&migrateDriver{db: db}).Version()
+	if err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	if dirty {
+		return 0, fmt.Errorf("schema version %d is dirty, its migration failed part way", version)
+	}
+	if version == database.NilVersion {
+		return 0, nil
+	}
+	return uint(version), nil
+}
+
+// RunMigrations applies the migrations newer than the schema version, each in
+// its own transaction. It is safe to call on every startup.
+func RunMigrations(db *gorm.DB) error {
+	m, _, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("run migrations: %w", err)
+	}
+	return nil
+}
+
+// MigrateDown rolls back the newest steps applied migrations, newest first and
+// each in its own transaction. Asking for more steps than are applied rolls
+// back nothing and returns ErrMigrateDownTooFar.
+func MigrateDown(db *gorm.DB, steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("migrate down: steps must be at least 1, got %d", steps)
+	}
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	m, files, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	applied, err := countMigrations(files, version)
+	if err != nil {
+		return fmt.Errorf("list applied migrations: %w", err)
+	}
+	if steps > applied {
+		return fmt.Errorf("%w: %d steps requested, %d migrations applied", ErrMigrateDownTooFar, steps, applied)
+	}
+	if err := m.Steps(-steps); err != nil {
+		return fmt.Errorf("migrate down: %w", err)
+	}
+	return nil
+}
+
+// newMigrator returns a golang-migrate instance applying migrationFiles to db,
+// creating the migrations table when it is missing
+func newMigrator(db *gorm.DB) (*migrate.Migrate, source.Driver, error) {
+	driver := &migrateDriver{db: db}
+	if err := driver.ensureVersionTable(); err != nil {
+		return nil, nil, fmt.Errorf("create %s: %w", migrationsTable, err)
+	}
+	files, err := iofs.New(migrationFiles, "migrations")
+	if err != nil {
+		return nil, nil, fmt.Errorf("read migrations: %w", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", files, "sqlite", driver)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load migrations: %w", err)
+	}
+	return m, files, nil
+}
+
+// countMigrations returns how many migrations of files have a version up to version
+func countMigrations(files source.Driver, version uint) (int, error) {
+	count := 0
+	v, err := files.First()
+	for err == nil && uint(v) <= version {
+		count++
+		v, err = files.Next(v)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	return count, nil
+}
+
+// migrateDriver runs golang-migrate migrations through GORM. golang-migrate's
+// own SQLite driver can't be linked in, it registers the "sqlite" database/sql
+// driver the glebarez driver already registers.
+type migrateDriver struct {
+	db     *gorm.DB
+	locked atomic.Bool
+}
+
+func (d *migrateDriver) ensureVersionTable() error {
+	if err := d.db.Exec("CREATE TABLE IF NOT EXISTS " + migrationsTable + " (version uint64, dirty bool)").Error; err != nil {
+		return err
+	}
+	return d.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS version_unique ON " + migrationsTable + " (version)").Error
+}
+
+func (d *migrateDriver) Open(string) (database.Driver, error) {
+	return nil, errors.New("migrate: the GORM driver is created from an open connection")
+}
+
+// Close leaves the connection open, it belongs to the caller
+func (d *migrateDriver) Close() error {
+	return nil
+}
+
+func (d *migrateDriver) Lock() error {
+	if !d.locked.CompareAndSwap(false, true) {
+		return database.ErrLocked
+	}
+	return nil
+}
+
+func (d *migrateDriver) Unlock() error {
+	if !d.locked.CompareAndSwap(true, false) {
+		return database.ErrNotLocked
+	}
+	return nil
+}
+
+func (d *migrateDriver) Run(migration io.Reader) error {
+	query, err := io.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(string(query)).Error; err != nil {
+			return &database.Error{OrigErr: err, Query: query}
+		}
+		return nil
+	})
+}
+
+func (d *migrateDriver) SetVersion(version int, dirty bool) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM " + migrationsTable).Error; err != nil {
+			return err
+		}
+		// A failed rollback of the first migration is recorded as a dirty nil version
+		if version >= 0 || version == database.NilVersion && dirty {
+			return tx.Exec("INSERT INTO "+migrationsTable+" (version, dirty) VALUES (?, ?)", version, dirty).Error
+		}
+		return nil
+	})
+}
+
+func (d *migrateDriver) Version() (int, bool, error) {
+	var rows []struct {
+		Version int
+		Dirty   bool
+	}
+	if err := d.db.Raw("SELECT version, dirty FROM " + migrationsTable + " LIMIT 1").Scan(&rows).Error; err != nil {
+		return database.NilVersion, false, err
+	}
+	if len(rows) == 0 {
+		return database.NilVersion, false, nil
+	}
+	return rows[0].Version, rows[0].Dirty, nil
+}
+
+func (d *migrateDriver) Drop() error {
+	return errors.New("migrate: dropping the database is not supported")
+}
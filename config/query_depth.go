@@ -0,0 +1,121 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Errors returned for raw SQL rejected by QueryDepthPlugin
+var (
+	ErrRecursiveQuery = errors.New("recursive queries are not allowed")
+	ErrQueryTooDeep   = errors.New("query is nested too deeply")
+)
+
+// QueryDepthPlugin is a GORM plugin rejecting raw SQL, passed to Raw or Exec,
+// that uses WITH RECURSIVE or nests SELECTs more than MaxDepth levels deep, to
+// bound the work a single statement can cause. Statements built by GORM from
+// models are not checked, their structure does not depend on input.
+type QueryDepthPlugin struct {
+	MaxDepth int
+}
+
+func (QueryDepthPlugin) Name() string {
+	return "query_depth"
+}
+
+func (p QueryDepthPlugin) Initialize(db *gorm.DB) error {
+	check := func(db *gorm.DB) {
+		if db.Error != nil || db.Statement.SQL.Len() == 0 {
+			return
+		}
+		if err := CheckQueryDepth(db.Statement.SQL.String(), p.MaxDepth); err != nil {
+			_ = db.AddError(err)
+		}
+	}
+
+	cb := db.Callback()
+	if err := cb.Query().Before("gorm:query").Register("query_depth:before_query", check); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("query_depth:before_row", check); err != nil {
+		return err
+	}
+	return cb.Raw().Before("gorm:raw").Register("query_depth:before_raw", check)
+}
+
+// CheckQueryDepth returns ErrRecursiveQuery when sql contains a recursive
+// common table expression and ErrQueryTooDeep when it nests SELECTs more than
+// maxDepth levels deep, a top-level SELECT being 1. Parentheses only add a
+// level when they hold a SELECT. String literals, quoted identifiers and
+// comments are skipped, an unterminated one runs to the end of sql.
+func CheckQueryDepth(sql string, maxDepth int) error {
+	// levels has an entry for the statement and each open parenthesis, outer is
+	// the depth of the SELECT enclosing it and current that of the SELECT in it
+	type level struct{ outer, current int }
+	levels := []level{{}}
+	selectDepth := 0
+	previous := ""
+scan:
+	for i := 0; i < len(sql); {
+		ch := sql[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			end := strings.IndexByte(sql[i+1:], ch)
+			if end < 0 {
+				break scan
+			}
+			i += end + 2
+			continue
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				break scan
+			}
+			i += end
+			continue
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				break scan
+			}
+			i += end + 4
+			continue
+		case ch == '(':
+			current := levels[len(levels)-1].current
+			levels = append(levels, level{outer: current, current: current})
+		case ch == ')':
+			if len(levels) > 1 {
+				levels = levels[:len(levels)-1]
+			}
+		case isWordByte(ch):
+			start := i
+			for i < len(sql) && isWordByte(sql[i]) {
+				i++
+			}
+			word := strings.ToUpper(sql[start:i])
+			if word == "RECURSIVE" && previous == "WITH" {
+				return ErrRecursiveQuery
+			}
+			if word == "SELECT" {
+				top := &levels[len(levels)-1]
+				top.current = top.outer + 1
+				selectDepth = max(selectDepth, top.current)
+			}
+			previous = word
+			continue
+		}
+		i++
+	}
+
+	if selectDepth > maxDepth {
+		return fmt.Errorf("%w: %d levels of SELECT, at most %d allowed", ErrQueryTooDeep, selectDepth, maxDepth)
+	}
+	return nil
+}
+
+func isWordByte(ch byte) bool {
+	return ch == '_' || 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9'
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+// queryStartKey stores when a statement started on its GORM instance
+const queryStartKey = "prometheus:query_start"
+
+// GORMPrometheus is a GORM plugin recording how long every statement takes in
+// the db_query_duration_seconds histogram, labeled by table and operation
+// (select, insert, update or delete). Raw SQL has no table and is labeled
+// unknown.
+type GORMPrometheus struct {
+	// Registerer receives the histogram, prometheus.DefaultRegisterer when nil
+	Registerer prometheus.Registerer
+}
+
+func (GORMPrometheus) Name() string {
+	return "prometheus"
+}
+
+func (p GORMPrometheus) Initialize(db *gorm.DB) error {
+	histogram := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Duration of database statements by table and operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"table", "operation"})
+
+	registerer := p.Registerer
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	if err := registerer.Register(histogram); err != nil {
+		// Databases opened again in the same process share the histogram
+		var registered prometheus.AlreadyRegisteredError
+		if !errors.As(err, &registered) {
+			return err
+		}
+		histogram = registered.ExistingCollector.(*prometheus.HistogramVec)
+	}
+
+	start := func(db *gorm.DB) {
+		db.InstanceSet(queryStartKey, time.Now())
+	}
+	observe := func(operation string) func(*gorm.DB) {
+		return func(db *gorm.DB) {
+			started, ok := db.InstanceGet(queryStartKey)
+			if !ok {
+				return
+			}
+			table := db.Statement.Table
+			if table == "" {
+				table = "unknown"
+			}
+			histogram.WithLabelValues(table, operation).Observe(time.Since(started.(time.Time)).Seconds())
+		}
+	}
+
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("prometheus:before_create", start),
+		cb.Create().After("gorm:create").Register("prometheus:after_create", observe("insert")),
+		cb.Query().Before("gorm:query").Register("prometheus:before_query", start),
+		cb.Query().After("gorm:query").Register("prometheus:after_query", observe("select")),
+		cb.Row().Before("gorm:row").Register("prometheus:before_row", start),
+		cb.Row().After("gorm:row").Register("prometheus:after_row", observe("select")),
+		cb.Update().Before("gorm:update").Register("prometheus:before_update", start),
+		cb.Update().After("gorm:update").Register("prometheus:after_update", observe("update")),
+		cb.Delete().Before("gorm:delete").Register("prometheus:before_delete", start),
+		cb.Delete().After("gorm:delete").Register("prometheus:after_delete", observe("delete")),
+	)
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// longUnits are the units ParseRetention accepts on top of time.ParseDuration
+var longUnits = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"y": 365 * 24 * time.Hour,
+}
+
+// ParseRetention parses an age such as "30d", "90d" or "1y". Units smaller
+// than a day use the time.ParseDuration syntax, e.g. "12h".
+func ParseRetention(s string) (time.Duration, error) {
+	for suffix, unit := range longUnits {
+		if count, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid retention %q: must be a non-negative integer followed by %s", s, suffix)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention %q: use days like 30d, years like 1y or a duration like 12h", s)
+	}
+	return d, nil
+}
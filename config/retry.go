@@ -0,0 +1,104 @@
+package config
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// sqliteTransientErrors are substrings of SQLite errors worth retrying
+var sqliteTransientErrors = []string{
+	"database is locked",
+	"database table is locked",
+	"SQLITE_BUSY",
+	"SQLITE_LOCKED",
+}
+
+// postgresTransientCodes are SQLSTATE codes worth retrying
+var postgresTransientCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+}
+
+// WithRetry wraps the GORM create, query, update, delete, row and raw callbacks
+// so that operations failing with a transient error are retried up to
+// maxAttempts times, waiting base * 2^attempt plus jitter between attempts.
+func WithRetry(db *gorm.DB, maxAttempts int, base time.Duration) *gorm.DB {
+	if maxAttempts < 2 {
+		return db
+	}
+
+	cb := db.Callback()
+	wrap := func(name string, get func(string) func(*gorm.DB), replace func(string, func(*gorm.DB)) error) {
+		fn := get(name)
+		if fn == nil {
+			return
+		}
+		if err := replace(name, retryCallback(fn, maxAttempts, base)); err != nil {
+			_ = db.AddError(err)
+		}
+	}
+
+	wrap("gorm:create", cb.Create().Get, cb.Create().Replace)
+	wrap("gorm:query", cb.Query().Get, cb.Query().Replace)
+	wrap("gorm:update", cb.Update().Get, cb.Update().Replace)
+	wrap("gorm:delete", cb.Delete().Get, cb.Delete().Replace)
+	wrap("gorm:row", cb.Row().Get, cb.Row().Replace)
+	wrap("gorm:raw", cb.Raw().Get, cb.Raw().Replace)
+
+	return db
+}
+
+func retryCallback(fn func(*gorm.DB), maxAttempts int, base time.Duration) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		for attempt := 0; ; attempt++ {
+			fn(db)
+
+			if attempt+1 >= maxAttempts || !IsTransientError(db.Error) {
+				return
+			}
+
+			select {
+			case <-db.Statement.Context.Done():
+				return
+			case <-time.After(backoff(base, attempt)):
+			}
+
+			db.Error = nil
+		}
+	}
+}
+
+// backoff returns base * 2^attempt plus up to base of random jitter
+func backoff(base time.Duration, attempt int) time.Duration {
+	delay := base << attempt
+	if base > 0 {
+		delay += rand.N(base)
+	}
+	return delay
+}
+
+// IsTransientError reports whether err is a lock or serialization error that
+// may succeed when retried
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		return postgresTransientCodes[pgErr.SQLState()]
+	}
+
+	msg := err.Error()
+	for _, s := range sqliteTransientErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,8 @@
+//go:build sqlcipher && cgo
+
+package config
+
+import _ "github.com/mutecomm/go-sqlcipher/v4" // registers the SQLCipher driver as sqlite3
+
+// sqlcipherDriver is the database/sql driver opening encrypted databases
+const sqlcipherDriver = "sqlite3"
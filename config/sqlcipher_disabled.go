@@ -0,0 +1,7 @@
+//go:build !(sqlcipher && cgo)
+
+package config
+
+// sqlcipherDriver is empty as SQLCipher is a C library, encrypted databases
+// need a binary built with CGO_ENABLED=1 and -tags sqlcipher
+const sqlcipherDriver = ""
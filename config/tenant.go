@@ -0,0 +1,71 @@
+package config
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tenantField is the field marking a model as belonging to a tenant
+const tenantField = "TenantID"
+
+// TenantPlugin is a GORM plugin that scopes models with a TenantID field to
+// the tenant stored in the statement context. Queries, including Row and
+// Rows, updates and deletes only match the tenant's rows, and creates and
+// updates set its ID. Raw SQL is not rewritten and must filter on tenant_id
+// itself.
+type TenantPlugin struct{}
+
+func (TenantPlugin) Name() string {
+	return "tenant"
+}
+
+func (TenantPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("tenant:before_create", setTenantColumn); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("tenant:before_query", scopeToTenant); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("tenant:before_row", scopeToTenant); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("tenant:before_update", func(db *gorm.DB) {
+		scopeToTenant(db)
+		setTenantColumn(db)
+	}); err != nil {
+		return err
+	}
+	return cb.Delete().Before("gorm:delete").Register("tenant:before_delete", scopeToTenant)
+}
+
+func tenantFromStatement(db *gorm.DB) (uint, string, bool) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return 0, "", false
+	}
+	field := db.Statement.Schema.LookUpField(tenantField)
+	if field == nil {
+		return 0, "", false
+	}
+	tenantID, ok := TenantIDFromContext(db.Statement.Context)
+	return tenantID, field.DBName, ok
+}
+
+func scopeToTenant(db *gorm.DB) {
+	tenantID, column, ok := tenantFromStatement(db)
+	if !ok {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Value: tenantID},
+	}})
+}
+
+// setTenantColumn also keeps clients from moving users to another tenant
+func setTenantColumn(db *gorm.DB) {
+	tenantID, _, ok := tenantFromStatement(db)
+	if !ok {
+		return
+	}
+	db.Statement.SetColumn(tenantField, &tenantID, true)
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gorm.io/gorm"
+)
+
+// Vacuum rebuilds the SQLite database at path, a file name or SQLite URI, to
+// reclaim free pages and returns the file size in bytes before and after
+func Vacuum(db *gorm.DB, path string) (before, after int64, err error) {
+	if before, err = fileSize(path); err != nil {
+		return 0, 0, err
+	}
+	if err = db.Exec("VACUUM").Error; err != nil {
+		return before, 0, fmt.Errorf("vacuum %s: %w", path, err)
+	}
+	if after, err = fileSize(path); err != nil {
+		return before, 0, err
+	}
+	return before, after, nil
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(dsnFile(path))
+	if err != nil {
+		return 0, fmt.Errorf("stat database file: %w", err)
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"go-api/cache"
+	"go-api/config"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// BaseController holds the dependencies and response helpers shared by controllers
+type BaseController struct {
+	DB     *gorm.DB
+	Logger *slog.Logger
+	Cache  *cache.Cache
+}
+
+// RespondError logs err with the request's method, route and path parameters
+// and responds with status and the error message. Server errors are logged as
+// errors, client errors as warnings.
+func (bc *BaseController) RespondError(c *gin.Context, status int, err error) {
+	attrs := []any{"error", err, "status", status, "method", c.Request.Method, "route", c.FullPath()}
+	for _, param := range c.Params {
+		attrs = append(attrs, param.Key, param.Value)
+	}
+
+	if status >= http.StatusInternalServerError {
+		bc.Logger.Error("Request failed", attrs...)
+	} else {
+		bc.Logger.Warn("Request rejected", attrs...)
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// ParseID parses the named path parameter as an ID. On failure it responds
+// with 400 and returns false, so handlers can simply return.
+func (bc *BaseController) ParseID(c *gin.Context, param string) (uint, bool) {
+	value := c.Param(param)
+	id, err := strconv.ParseUint(value, 10, 0)
+	if err != nil || id == 0 {
+		bc.Logger.Warn("Invalid ID provided", "param", param, "value", value, "route", c.FullPath())
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// requestTenant returns the request's tenant ID, or nil when it is not scoped
+// to a tenant. Raw SQL, which config.TenantPlugin leaves alone, filters with
+// "? IS NULL OR tenant_id = ?".
+func requestTenant(c *gin.Context) *uint {
+	if tenantID, ok := config.TenantIDFromContext(c.Request.Context()); ok {
+		return &tenantID
+	}
+	return nil
+}
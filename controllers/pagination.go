@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// PageQuery holds the page query parameters shared by the paginated user lists
+type PageQuery struct {
+	Page int `form:"page" binding:"omitempty,min=1"`
+	// PerPage is the page size, page_size is still accepted for it
+	PerPage  int `form:"per_page" binding:"omitempty,min=1,max=100"`
+	PageSize int `form:"page_size" binding:"omitempty,min=1,max=100"`
+}
+
+// paginated reports whether any page parameter was given
+func (q PageQuery) paginated() bool {
+	return q.Page != 0 || q.PerPage != 0 || q.PageSize != 0
+}
+
+// pageScope applies the offset and limit of the requested page
+func (q PageQuery) pageScope(db *gorm.DB) *gorm.DB {
+	perPage := cmp.Or(q.PerPage, q.PageSize, defaultPageSize)
+	return db.Offset((max(q.Page, 1) - 1) * perPage).Limit(perPage)
+}
+
+// paginate returns a scope applying the page, per_page and page_size query
+// parameters, or the invalid parameters with the reason for each. Results are
+// left unpaginated when no page parameter is given.
+func paginate(c *gin.Context) (func(*gorm.DB) *gorm.DB, map[string]string) {
+	var q PageQuery
+	if fields := bindQuery(c.Request.URL.Query(), &q); fields != nil {
+		return nil, fields
+	}
+	if !q.paginated() {
+		return func(db *gorm.DB) *gorm.DB { return db }, nil
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return q.pageScope(db.Order("id"))
+	}, nil
+}
+
+// respondInvalidQuery rejects the request with the invalid query parameters
+func (uc *UserController) respondInvalidQuery(c *gin.Context, fields map[string]string) {
+	uc.Logger.Warn("Invalid query parameters", "path", c.FullPath(), "fields", fields)
+	c.JSON(http.StatusBadRequest, QueryError{Error: "Invalid query parameters", Fields: fields})
+}
+
+// keyset returns a scope applying the after_id and limit query parameters,
+// which seeks past after_id on the primary key instead of counting skipped rows
+func keyset(c *gin.Context) (func(*gorm.DB) *gorm.DB, int, error) {
+	afterID, limit := 0, defaultPageSize
+	var err error
+	if afterIDParam := c.Query("after_id"); afterIDParam != "" {
+		if afterID, err = strconv.Atoi(afterIDParam); err != nil || afterID < 0 {
+			return nil, 0, errors.New("after_id must be a non-negative integer")
+		}
+	}
+	if limitParam := c.Query("limit"); limitParam != "" {
+		if limit, err = strconv.Atoi(limitParam); err != nil || limit < 1 || limit > maxPageSize {
+			return nil, 0, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id > ?", afterID).Order("id").Limit(limit)
+	}, limit, nil
+}
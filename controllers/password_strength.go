@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"go-api/auth"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PasswordStrengthRequest is the password to score, read from the query
+// string on GET and from the body on POST
+type PasswordStrengthRequest struct {
+	Password string `json:"password" form:"password" binding:"required,max=256"`
+}
+
+// GetUserPasswordStrength godoc
+// @Summary Check password strength
+// @Description Score how hard a password is to guess, from 0 to 4, with hints to improve it. The password is also accepted as a JSON body on POST, to keep it out of URLs.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param password query string true "Password, up to 256 characters"
+// @Success 200 {object} auth.PasswordStrength
+// @Failure 400 {object} map[string]string
+// @Router /password-strength [get]
+func (uc *UserController) GetUserPasswordStrength(c *gin.Context) {
+	var request PasswordStrengthRequest
+	if err := c.ShouldBind(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	strength := auth.ScorePassword(request.Password)
+	uc.Logger.Debug("Scored password strength", "score", strength.Score)
+	c.JSON(http.StatusOK, strength)
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"errors"
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// apiKeyRotationWindow is how long a rotated key keeps working next to its replacement
+const apiKeyRotationWindow = time.Hour
+
+// errKeyAlreadyRotating is returned when rotating a key that is already being replaced
+var errKeyAlreadyRotating = errors.New("API key is already being rotated")
+
+// CreateAPIKeyRequest is the payload for creating an API key
+type CreateAPIKeyRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// APIKeyResponse is a newly generated API key, the plaintext key is only returned once
+type APIKeyResponse struct {
+	models.UserAPIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey godoc
+// @Summary Create API key
+// @Description Generate an API key the user can authenticate with in the X-API-Key header. The key is only returned in this response. Only the user themselves or an admin can.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.CreateAPIKeyRequest true "API key"
+// @Success 201 {object} controllers.APIKeyResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/api-keys [post]
+func (uc *UserController) CreateAPIKey(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	key, plaintext, err := models.NewUserAPIKey(id, request.Name)
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := uc.DB.WithContext(c.Request.Context()).Create(&key).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("API key created successfully", "id", id, "key_id", key.ID, "prefix", key.Prefix)
+	c.JSON(http.StatusCreated, APIKeyResponse{UserAPIKey: key, Key: plaintext})
+}
+
+// RotateAPIKey godoc
+// @Summary Rotate API key
+// @Description Generate a replacement for an API key. The old key keeps working for an hour so clients can switch over without downtime, then it is rejected. Only the user themselves or an admin can.
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param key_id path int true "API key ID"
+// @Success 201 {object} controllers.APIKeyResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/api-keys/{key_id}/rotate [post]
+func (uc *UserController) RotateAPIKey(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+	keyID, ok := uc.ParseID(c, "key_id")
+	if !ok {
+		return
+	}
+
+	var replacement models.UserAPIKey
+	var plaintext string
+	err := uc.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		var old models.UserAPIKey
+		if err := tx.Where("id = ? AND user_id = ?", keyID, id).First(&old).Error; err != nil {
+			return err
+		}
+
+		until := time.Now().Add(apiKeyRotationWindow)
+		// Only the first of concurrent rotations wins
+		result := tx.Model(&models.UserAPIKey{}).Where("id = ? AND rotating_until IS NULL", old.ID).Update("rotating_until", until)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errKeyAlreadyRotating
+		}
+
+		var err error
+		if replacement, plaintext, err = models.NewUserAPIKey(id, old.Name); err != nil {
+			return err
+		}
+		return tx.Create(&replacement).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		uc.Logger.Info("API key not found for rotation", "id", id, "key_id", keyID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	case errors.Is(err, errKeyAlreadyRotating):
+		uc.RespondError(c, http.StatusConflict, err)
+		return
+	case err != nil:
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("API key rotated successfully", "id", id, "key_id", keyID, "new_key_id", replacement.ID)
+	c.JSON(http.StatusCreated, APIKeyResponse{UserAPIKey: replacement, Key: plaintext})
+}
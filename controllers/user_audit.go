@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-api/config"
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// auditCacheTTL is how long a cached audit summary stays valid
+const auditCacheTTL = 5 * time.Minute
+
+// AuditSummary is a quick overview of a user's change history
+type AuditSummary struct {
+	CreatedAt      *time.Time `json:"created_at"`
+	LastModifiedAt *time.Time `json:"last_modified_at"`
+	UpdateCount    int64      `json:"update_count"`
+	DeleteCount    int64      `json:"delete_count"`
+}
+
+// auditCacheKey includes the tenant so summaries are never served across tenants
+func auditCacheKey(c *gin.Context, userID uint) string {
+	tenantID, _ := config.TenantIDFromContext(c.Request.Context())
+	return fmt.Sprintf("audit:%d:%d", userID, tenantID)
+}
+
+// recordAudit stores an audit log entry for a change to the given user, with
+// the user's history snapshots from before and after the change. Creates and
+// updates also store the resulting state as a new history version. Failures
+// are logged, use writeAudit inside the change's transaction instead when the
+// change must not happen without its entry.
+func (uc *UserController) recordAudit(c *gin.Context, action string, userID uint) {
+	if err := writeAudit(uc.DB.WithContext(c.Request.Context()), c, action, userID); err != nil {
+		uc.Logger.Error("Failed to record audit log", "error", err, "action", action, "id", userID)
+	}
+	uc.AuditCache.InvalidatePattern(fmt.Sprintf("audit:%d:*", userID))
+}
+
+// writeAudit stores the audit log entry and history version recordAudit
+// describes with db, which can be a transaction
+func writeAudit(db *gorm.DB, c *gin.Context, action string, userID uint) error {
+	entry := models.AuditLog{EntityType: "user", EntityID: userID, Action: action}
+	if actorID, ok := config.UserIDFromContext(c.Request.Context()); ok {
+		entry.ActorID = &actorID
+	}
+
+	var err error
+	if action != models.AuditActionCreate {
+		if entry.BeforeSnapshot, err = latestSnapshot(db, userID); err != nil {
+			return err
+		}
+	}
+	switch action {
+	case models.AuditActionCreate, models.AuditActionUpdate:
+		if entry.AfterSnapshot, err = recordHistory(db, userID); err != nil {
+			return err
+		}
+	case models.AuditActionImpersonate:
+		entry.AfterSnapshot = entry.BeforeSnapshot
+	}
+	return db.Create(&entry).Error
+}
+
+// auditSummary computes the audit summary of the user with the given ID
+func auditSummary(db *gorm.DB, id uint) (AuditSummary, error) {
+	var summary AuditSummary
+	counts, err := auditActionCounts(db, id)
+	if err != nil {
+		return summary, err
+	}
+	for _, count := range counts {
+		switch count.Action {
+		case models.AuditActionUpdate:
+			summary.UpdateCount = count.Count
+		case models.AuditActionDelete:
+			summary.DeleteCount = count.Count
+		}
+	}
+
+	// Session makes the scoped query safe to reuse for the queries below
+	history := db.Model(&models.AuditLog{}).Where("entity_id = ? AND entity_type = ?", id, "user").Session(&gorm.Session{})
+	var first, last models.AuditLog
+	if err := history.Where("action = ?", models.AuditActionCreate).Order("id").Limit(1).Find(&first).Error; err != nil {
+		return summary, err
+	}
+	changes := []string{models.AuditActionCreate, models.AuditActionUpdate, models.AuditActionDelete}
+	if err := history.Where("action IN ?", changes).Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+		return summary, err
+	}
+	if first.ID != 0 {
+		summary.CreatedAt = &first.CreatedAt
+	}
+	if last.ID != 0 {
+		summary.LastModifiedAt = &last.CreatedAt
+	}
+	return summary, nil
+}
+
+// actionCount is the number of entries with an action
+type actionCount struct {
+	Action string
+	Count  int64
+}
+
+// auditActionCounts counts the user's audit log entries by action
+func auditActionCounts(db *gorm.DB, id uint) ([]actionCount, error) {
+	var counts []actionCount
+	err := db.Model(&models.AuditLog{}).Where("entity_id = ? AND entity_type = ?", id, "user").
+		Select("action, COUNT(*) AS count").Group("action").Order("action").Scan(&counts).Error
+	return counts, err
+}
+
+// GetAuditSummary godoc
+// @Summary Get user audit summary
+// @Description Get change counters and dates from the user's audit log
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} controllers.AuditSummary
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/audit-summary [get]
+func (uc *UserController) GetAuditSummary(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	key := auditCacheKey(c, id)
+	if body, ok := uc.AuditCache.Get(key); ok {
+		c.Header("X-Cache", "HIT")
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+		return
+	}
+
+	// Deleted users keep their audit history
+	db := uc.DB.WithContext(c.Request.Context())
+	var user models.User
+	result := db.Unscoped().First(&user, id)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("User not found for audit summary", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	summary, err := auditSummary(db, id)
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	body, err := json.Marshal(summary)
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	uc.AuditCache.Set(key, body)
+
+	uc.Logger.Debug("Successfully built audit summary", "id", id)
+	c.Header("X-Cache", "MISS")
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"go-api/models"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// bulkField validates a JSON value for a column and converts it for GORM
+type bulkField func(value any) (any, error)
+
+func roleField(value any) (any, error) {
+	role, ok := value.(string)
+	if !ok || !slices.Contains(models.ValidRoles, role) {
+		return nil, fmt.Errorf("must be one of %v", models.ValidRoles)
+	}
+	return role, nil
+}
+
+func timezoneField(value any) (any, error) {
+	timezone, ok := value.(string)
+	if !ok {
+		return nil, errors.New("must be an IANA time zone")
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return nil, errors.New("must be an IANA time zone")
+	}
+	return timezone, nil
+}
+
+func lockedUntilField(value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return nil, errors.New("must be an RFC 3339 time or null")
+	}
+	lockedUntil, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, errors.New("must be an RFC 3339 time or null")
+	}
+	return lockedUntil, nil
+}
+
+// bulkFilterFields and bulkUpdateFields are the columns bulk updates may match and set
+var (
+	bulkFilterFields = map[string]bulkField{
+		"role":     roleField,
+		"timezone": timezoneField,
+	}
+	bulkUpdateFields = map[string]bulkField{
+		"role":         roleField,
+		"timezone":     timezoneField,
+		"locked_until": lockedUntilField,
+	}
+)
+
+// BulkUpdateRequest matches users whose columns equal every filter value and
+// sets the update values on them
+type BulkUpdateRequest struct {
+	Filter map[string]any `json:"filter" binding:"required"`
+	Update map[string]any `json:"update" binding:"required"`
+}
+
+// BulkUpdateResponse is the number of users changed by a bulk update
+type BulkUpdateResponse struct {
+	Updated int64 `json:"updated"`
+}
+
+// bulkColumns checks fields against allowed, returning the converted values
+func bulkColumns(fields map[string]any, allowed map[string]bulkField) (map[string]any, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("at least one field is required")
+	}
+
+	columns := make(map[string]any, len(fields))
+	for name, value := range fields {
+		convert, ok := allowed[name]
+		if !ok {
+			names := make([]string, 0, len(allowed))
+			for allowedName := range allowed {
+				names = append(names, allowedName)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("field %q is not allowed, use one of %v", name, names)
+		}
+
+		converted, err := convert(value)
+		if err != nil {
+			return nil, fmt.Errorf("field %q %w", name, err)
+		}
+		columns[name] = converted
+	}
+	return columns, nil
+}
+
+// BulkUpdate godoc
+// @Summary Bulk update users
+// @Description Set fields on every user matching the filter. Filters can use role and timezone, updates can set role, timezone and locked_until.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body controllers.BulkUpdateRequest true "Filter and update"
+// @Success 200 {object} controllers.BulkUpdateResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/bulk-update [patch]
+func (uc *UserController) BulkUpdate(c *gin.Context) {
+	var request BulkUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	filter, err := bulkColumns(request.Filter, bulkFilterFields)
+	if err != nil {
+		uc.RespondError(c, http.StatusBadRequest, fmt.Errorf("invalid filter: %w", err))
+		return
+	}
+	update, err := bulkColumns(request.Update, bulkUpdateFields)
+	if err != nil {
+		uc.RespondError(c, http.StatusBadRequest, fmt.Errorf("invalid update: %w", err))
+		return
+	}
+
+	// Collect the matching IDs first so every changed user gets an audit entry,
+	// the update reuses the filter as an ID list could exceed SQLite's
+	// variable limit. The entries are written with the update, or not at all.
+	var ids []uint
+	err = uc.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where(filter).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := tx.Model(&models.User{}).Where(filter).Updates(update).Error; err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := writeAudit(tx, c, models.AuditActionUpdate, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(ids) > 0 {
+		uc.invalidateUsersCache()
+		uc.AuditCache.InvalidatePattern("audit:*")
+	}
+
+	uc.Logger.Info("Users updated in bulk", "updated", len(ids), "filter", request.Filter, "update", request.Update)
+	c.JSON(http.StatusOK, BulkUpdateResponse{Updated: int64(len(ids))})
+}
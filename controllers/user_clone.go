@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CloneUserRequest is the payload for cloning a user
+type CloneUserRequest struct {
+	NewEmail string `json:"new_email" binding:"required,email"`
+}
+
+// CloneUser godoc
+// @Summary Clone user
+// @Description Create a user with the source user's name, role, lock and tags under a new email
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "Source user ID"
+// @Param request body controllers.CloneUserRequest true "Email of the new user"
+// @Success 201 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/clone [post]
+func (uc *UserController) CloneUser(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request CloneUserRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var source models.User
+	result := db.First(&source, id)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("User not found for clone", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	if err := uc.UserPolicy.CheckEmail(request.NewEmail); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Soft-deleted users still hold their email in the unique index
+	var taken int64
+	if err := db.Unscoped().Model(&models.User{}).Where("email = ?", request.NewEmail).Count(&taken).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if taken > 0 {
+		uc.Logger.Info("Clone email already in use", "id", id)
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return
+	}
+
+	// Pending email changes belong to the source user and are not copied
+	clone := models.User{
+		Name:        source.Name,
+		Role:        source.Role,
+		Email:       request.NewEmail,
+		LockedUntil: source.LockedUntil,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&clone).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`INSERT INTO user_tags (user_id, tag) SELECT ?, tag FROM user_tags WHERE user_id = ?`, clone.ID, source.ID).Error
+	})
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.invalidateUsersCache()
+	uc.recordAudit(c, models.AuditActionCreate, clone.ID)
+	uc.Logger.Info("User cloned successfully", "user", clone, "source_id", source.ID)
+
+	if err := uc.Mailer.SendWelcome(clone); err != nil {
+		uc.Logger.Warn("Failed to send welcome email", "error", err, "user", clone)
+	}
+	c.JSON(http.StatusCreated, clone)
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"errors"
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+// UserCompliance summarizes the consent and data retention status of a user
+type UserCompliance struct {
+	EmailVerified bool `json:"email_verified"`
+	// ConsentGiven is true when the user's latest decision on every consent
+	// type they were asked about is a grant
+	ConsentGiven bool `json:"consent_given"`
+	// DataRetentionExpires is the date a deleted user is purged on, null while
+	// the user is active or deleted users are only purged by hand
+	DataRetentionExpires *string              `json:"data_retention_expires"`
+	GDPRRequests         []models.GDPRRequest `json:"gdpr_requests"`
+	// Anonymized is true once an erasure request has been completed
+	Anonymized bool `json:"anonymized"`
+}
+
+// GDPRRequestRequest is the payload for logging a GDPR request
+type GDPRRequestRequest struct {
+	Type        string     `json:"type" binding:"required,oneof=access rectification erasure portability"`
+	RequestedAt *time.Time `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// GetUserCompliance godoc
+// @Summary Get user compliance
+// @Description Get whether a user verified their email and gave consent, when their data is purged, and the GDPR requests they made. Deleted users are included.
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} controllers.UserCompliance
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/compliance [get]
+func (uc *UserController) GetUserCompliance(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var user models.User
+	var consents []models.ConsentRecord
+	requests := []models.GDPRRequest{}
+
+	group, ctx := errgroup.WithContext(c.Request.Context())
+	db := uc.DB.WithContext(ctx)
+	group.Go(func() error {
+		return db.Unscoped().Select("id", "email_verified_at", "deleted_at").First(&user, id).Error
+	})
+	group.Go(func() error {
+		// Records are only ever appended, so the highest ID of a type is its latest
+		latest := db.Model(&models.ConsentRecord{}).Select("MAX(id)").Where("user_id = ?", id).Group("consent_type")
+		return db.Where("id IN (?)", latest).Find(&consents).Error
+	})
+	group.Go(func() error {
+		return db.Where("user_id = ?", id).Order("requested_at, id").Find(&requests).Error
+	})
+	if err := group.Wait(); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			uc.Logger.Info("User not found for compliance", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	compliance := UserCompliance{
+		EmailVerified: user.EmailVerifiedAt != nil,
+		ConsentGiven:  len(consents) > 0,
+		GDPRRequests:  requests,
+	}
+	for _, consent := range consents {
+		compliance.ConsentGiven = compliance.ConsentGiven && consent.Granted
+	}
+	if user.DeletedAt.Valid && uc.autoPurgeAge > 0 {
+		expires := user.DeletedAt.Time.Add(uc.autoPurgeAge).Format(time.DateOnly)
+		compliance.DataRetentionExpires = &expires
+	}
+	for _, request := range requests {
+		if request.Type == models.GDPRRequestErasure && request.CompletedAt != nil {
+			compliance.Anonymized = true
+		}
+	}
+
+	uc.Logger.Debug("Successfully fetched user compliance", "id", id)
+	c.JSON(http.StatusOK, compliance)
+}
+
+// RecordGDPRRequest godoc
+// @Summary Log GDPR request
+// @Description Log a data subject request a user made, requested now unless requested_at is given. Set completed_at once it is fulfilled.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.GDPRRequestRequest true "GDPR request"
+// @Success 201 {object} models.GDPRRequest
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/gdpr-requests [post]
+func (uc *UserController) RecordGDPRRequest(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request GDPRRequestRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Erasure is often requested right before or after deleting the account
+	var count int64
+	db := uc.DB.WithContext(c.Request.Context())
+	if err := db.Unscoped().Model(&models.User{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if count == 0 {
+		uc.Logger.Info("User not found for GDPR request", "id", id)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	record := models.GDPRRequest{UserID: id, Type: request.Type, RequestedAt: time.Now(), CompletedAt: request.CompletedAt}
+	if request.RequestedAt != nil {
+		record.RequestedAt = *request.RequestedAt
+	}
+	if err := db.Create(&record).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("GDPR request logged", "id", id, "request_id", record.ID, "type", record.Type)
+	c.JSON(http.StatusCreated, record)
+}
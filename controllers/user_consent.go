@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConsentRequest is a user granting or withdrawing a type of consent
+type ConsentRequest struct {
+	ConsentType string `json:"consent_type" binding:"required,max=64"`
+	Granted     *bool  `json:"granted" binding:"required"`
+}
+
+// RecordConsent godoc
+// @Summary Record consent
+// @Description Record the user granting or withdrawing a type of consent, such as marketing_emails, with the client IP and user agent
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.ConsentRequest true "Consent"
+// @Success 201 {object} models.ConsentRecord
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/consent [post]
+func (uc *UserController) RecordConsent(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request ConsentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	record := models.ConsentRecord{
+		UserID:      id,
+		ConsentType: request.ConsentType,
+		Granted:     *request.Granted,
+		IPAddress:   c.ClientIP(),
+		UserAgent:   c.Request.UserAgent(),
+		Timestamp:   time.Now(),
+	}
+	if err := uc.DB.WithContext(c.Request.Context()).Create(&record).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("Consent recorded", "id", id, "consent_type", record.ConsentType, "granted", record.Granted)
+	c.JSON(http.StatusCreated, record)
+}
+
+// GetUserConsentHistory godoc
+// @Summary Get consent history
+// @Description Get every consent the user granted or withdrew, oldest first
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} models.ConsentRecord
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/consent [get]
+func (uc *UserController) GetUserConsentHistory(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	records := []models.ConsentRecord{}
+	if err := uc.DB.WithContext(c.Request.Context()).Where("user_id = ?", id).Order("timestamp, id").Find(&records).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Debug("Successfully fetched consent history", "id", id, "count", len(records))
+	c.JSON(http.StatusOK, records)
+}
+
+// GetUserCurrentConsent godoc
+// @Summary Get current consent
+// @Description Get the latest consent record of each type for the user, ordered by type
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} models.ConsentRecord
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/consent/current [get]
+func (uc *UserController) GetUserCurrentConsent(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	// Records are only ever appended, so the highest ID of a type is its latest
+	db := uc.DB.WithContext(c.Request.Context())
+	latest := db.Model(&models.ConsentRecord{}).Select("MAX(id)").Where("user_id = ?", id).Group("consent_type")
+	records := []models.ConsentRecord{}
+	if err := db.Where("id IN (?)", latest).Order("consent_type").Find(&records).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Debug("Successfully fetched current consent", "id", id, "count", len(records))
+	c.JSON(http.StatusOK, records)
+}
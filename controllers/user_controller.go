@@ -1,25 +1,95 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-api/auth"
+	"go-api/cache"
+	"go-api/config"
+	"go-api/email"
+	"go-api/geoip"
 	"go-api/models"
+	"go-api/webhook"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"gorm.io/gorm"
 )
 
+// usersCacheTTL is how long a cached user list stays valid
+const usersCacheTTL = 30 * time.Second
+
+// statsCacheTTL is how long cached aggregate results, like dashboard statistics, stay valid
+const statsCacheTTL = 5 * time.Minute
+
 type UserController struct {
-	DB     *gorm.DB
-	Logger *slog.Logger
+	BaseController
+	AuditCache *cache.Cache
+	StatsCache *cache.Cache
+	Mailer     email.Mailer
+	// UserPolicy holds the deployment-specific rules users must follow
+	UserPolicy models.UserPolicy
+	// GeoIP resolves registration countries, lookups are skipped when nil
+	GeoIP geoip.Resolver
+	// Tokens signs impersonation tokens, impersonation is unavailable when nil
+	Tokens *auth.Issuer
+	// Webhooks delivers signed webhook payloads
+	Webhooks *webhook.Sender
+	// PasswordMaxAge is how long a password stays valid, passwords never expire when 0
+	PasswordMaxAge time.Duration
+
+	exports *exportJobs
+	// recentLogins holds the login events recorded within loginEventInterval
+	recentLogins *cache.Cache
+	// autoPurgeAge is how long deleted users are kept, zero when they are kept until purged by hand
+	autoPurgeAge time.Duration
 }
 
-func NewUserController(db *gorm.DB, logger *slog.Logger) *UserController {
+func NewUserController(db *gorm.DB, logger *slog.Logger, mailer email.Mailer) *UserController {
 	return &UserController{
-		DB:     db,
-		Logger: logger,
+		BaseController: BaseController{DB: db, Logger: logger, Cache: cache.New(usersCacheTTL)},
+		AuditCache:     cache.New(auditCacheTTL),
+		StatsCache:     cache.New(statsCacheTTL),
+		Mailer:         mailer,
+		Webhooks:       webhook.NewSender(),
+		exports:        newExportJobs(),
+		recentLogins:   cache.New(loginEventInterval),
+	}
+}
+
+// activeFilter returns a scope applying the active query parameter
+func activeFilter(c *gin.Context) (func(*gorm.DB) *gorm.DB, error) {
+	active := c.Query("active")
+	if active == "" {
+		return func(db *gorm.DB) *gorm.DB { return db }, nil
 	}
+
+	isActive, err := strconv.ParseBool(active)
+	if err != nil {
+		return nil, err
+	}
+	// Soft-deleted users are already excluded, so only the lock decides
+	if isActive {
+		return func(db *gorm.DB) *gorm.DB { return db.Where("locked_until IS NULL OR locked_until < ?", time.Now()) }, nil
+	}
+	return func(db *gorm.DB) *gorm.DB { return db.Where("locked_until >= ?", time.Now()) }, nil
+}
+
+// usersCacheKey builds a cache key from the normalized query string, the tenant and the authenticated user
+func usersCacheKey(c *gin.Context) string {
+	tenantID, _ := config.TenantIDFromContext(c.Request.Context())
+	userID, _ := config.UserIDFromContext(c.Request.Context())
+	return fmt.Sprintf("users:%d:%d:%s", tenantID, userID, c.Request.URL.Query().Encode())
+}
+
+// invalidateUsersCache drops all cached user lists after a write
+func (uc *UserController) invalidateUsersCache() {
+	uc.Cache.InvalidatePattern("users:*")
 }
 
 // GetUsers godoc
@@ -28,20 +98,154 @@ func NewUserController(db *gorm.DB, logger *slog.Logger) *UserController {
 // @Tags users
 // @Accept json
 // @Produce json
-// @Success 200 {array} models.User
+// @Param active query bool false "Filter by active status"
+// @Param page query int false "Page number, starting at 1"
+// @Param per_page query int false "Page size, up to 100"
+// @Param page_size query int false "Page size, an alias of per_page"
+// @Param sort query string false "Sort column" Enums(id, name, email, created_at)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param name query string false "Only users whose name contains this"
+// @Param email query string false "Only the user with this email"
+// @Param created_after query string false "Only users created after this RFC3339 time"
+// @Param created_before query string false "Only users created before this RFC3339 time"
+// @Param meta query string false "Only users with these metadata values, passed as meta[key]=value for up to 10 keys, e.g. meta[plan]=enterprise"
+// @Param after_id query int false "Keyset pagination, return users with a greater ID"
+// @Param limit query int false "Keyset page size, up to 100"
+// @Param include query string false "Comma-separated counts to attach to each user" Enums(sessions_count, audit_count)
+// @Success 200 {array} controllers.UserWithStats
+// @Header 200 {integer} X-Next-After-ID "after_id of the next keyset page, absent on the last page"
+// @Failure 400 {object} controllers.QueryError
 // @Router /users [get]
 func (uc *UserController) GetUsers(c *gin.Context) {
+	var list UserListQuery
+	fields := bindQuery(c.Request.URL.Query(), &list)
+	if fields == nil {
+		fields = list.validate()
+	}
+	if fields != nil {
+		uc.respondInvalidQuery(c, fields)
+		return
+	}
+
+	active, err := activeFilter(c)
+	if err != nil {
+		uc.Logger.Warn("Invalid active filter provided", "active", c.Query("active"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid active filter"})
+		return
+	}
+
+	if c.Query("include") != "" {
+		uc.GetUsersWithStats(c, list, active)
+		return
+	}
+
+	// The next keyset page is cached beside the body it belongs to
+	key := usersCacheKey(c)
+	if body, ok := uc.Cache.Get(key); ok {
+		uc.Logger.Debug("Serving users from cache", "key", key)
+		if list.keyset() {
+			if next, ok := uc.Cache.Get(key + ":next"); ok && len(next) > 0 {
+				c.Header("X-Next-After-ID", string(next))
+			}
+		}
+		c.Header("X-Cache", "HIT")
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+		return
+	}
+
 	var users []models.User
-	result := uc.DB.Find(&users)
+	result := uc.DB.WithContext(c.Request.Context()).Scopes(list.scope(), active).Find(&users)
 
 	if result.Error != nil {
-		uc.Logger.Error("Failed to fetch users", "error", result.Error)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	body, err := json.Marshal(users)
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
 		return
 	}
+	if list.keyset() {
+		var next string
+		if len(users) > 0 {
+			next, _ = list.nextAfterID(len(users), users[len(users)-1].ID)
+		}
+		if next != "" {
+			c.Header("X-Next-After-ID", next)
+		}
+		// Cached even when empty, so a hit never reuses a stale next page
+		uc.Cache.Set(key+":next", []byte(next))
+	}
+	uc.Cache.Set(key, body)
 
 	uc.Logger.Debug("Successfully fetched users", "count", len(users))
-	c.JSON(http.StatusOK, users)
+	c.Header("X-Cache", "MISS")
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// SyncUser is a user entry in a sync response, flagged when it has been deleted
+type SyncUser struct {
+	models.User
+	SyncState
+}
+
+// SyncState tells sync clients whether to keep or remove a user
+type SyncState struct {
+	Deleted bool `json:"deleted"`
+}
+
+func (u SyncUser) MarshalJSON() ([]byte, error) {
+	return marshalUserWith(u.User, u.SyncState)
+}
+
+// SyncResponse holds users changed since the requested time and the token for the next sync
+type SyncResponse struct {
+	Users     []SyncUser `json:"users"`
+	SyncToken time.Time  `json:"sync_token"`
+}
+
+// GetUsersModifiedSince godoc
+// @Summary Sync users
+// @Description Get users created, updated or deleted after the given time
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param since query string true "RFC3339 timestamp, usually the previous sync_token"
+// @Success 200 {object} controllers.SyncResponse
+// @Failure 400 {object} map[string]string
+// @Router /users/sync [get]
+func (uc *UserController) GetUsersModifiedSince(c *gin.Context) {
+	since, err := time.Parse(time.RFC3339, c.Query("since"))
+	if err != nil {
+		uc.Logger.Warn("Invalid since parameter provided", "since", c.Query("since"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since parameter, expected RFC3339 timestamp"})
+		return
+	}
+
+	// Take the token before querying so changes made during the query are picked up next time
+	syncToken := time.Now().UTC()
+
+	// Timestamps are stored in local time, compare in the same zone
+	since = since.Local()
+
+	var users []models.User
+	result := uc.DB.WithContext(c.Request.Context()).Unscoped().
+		Where("updated_at > ? OR deleted_at > ?", since, since).
+		Find(&users)
+
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	response := SyncResponse{Users: make([]SyncUser, 0, len(users)), SyncToken: syncToken}
+	for _, user := range users {
+		response.Users = append(response.Users, SyncUser{User: user, SyncState: SyncState{Deleted: user.DeletedAt.Valid}})
+	}
+
+	uc.Logger.Debug("Successfully fetched modified users", "count", len(users), "since", since)
+	c.JSON(http.StatusOK, response)
 }
 
 // GetUser godoc
@@ -55,15 +259,13 @@ func (uc *UserController) GetUsers(c *gin.Context) {
 // @Failure 404 {object} map[string]string
 // @Router /users/{id} [get]
 func (uc *UserController) GetUser(c *gin.Context) {
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		uc.Logger.Warn("Invalid user ID provided", "id", c.Param("id"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
 		return
 	}
 
 	var user models.User
-	result := uc.DB.First(&user, id)
+	result := uc.DB.WithContext(c.Request.Context()).First(&user, id)
 
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
@@ -71,45 +273,93 @@ func (uc *UserController) GetUser(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-		uc.Logger.Error("Database error while fetching user", "error", result.Error, "id", id)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
 		return
 	}
 
-	uc.Logger.Debug("Successfully fetched user", "id", id, "email", user.Email)
+	uc.Logger.Debug("Successfully fetched user", "user", user)
 	c.JSON(http.StatusOK, user)
 }
 
+// UserRequest holds the user fields clients can set when creating a user.
+// Roles and server-managed state, like locks and email verification, are set
+// through dedicated endpoints.
+type UserRequest struct {
+	Name        string                 `json:"name"`
+	Email       string                 `json:"email"`
+	Timezone    string                 `json:"timezone,omitempty" binding:"omitempty,timezone"`
+	Preferences models.UserPreferences `json:"preferences"`
+}
+
+// User returns a user holding the request's fields
+func (r UserRequest) User() models.User {
+	return models.User{Name: r.Name, Email: r.Email, Timezone: r.Timezone, Preferences: r.Preferences}
+}
+
+// UpdateUserRequest holds the user fields clients can update. The email is
+// changed through ChangeEmail, which confirms the new address first.
+type UpdateUserRequest struct {
+	Name        string                 `json:"name"`
+	Timezone    string                 `json:"timezone,omitempty" binding:"omitempty,timezone"`
+	Preferences models.UserPreferences `json:"preferences"`
+}
+
+// User returns a user holding the request's fields
+func (r UpdateUserRequest) User() models.User {
+	return models.User{Name: r.Name, Timezone: r.Timezone, Preferences: r.Preferences}
+}
+
 // CreateUser godoc
 // @Summary Create a new user
-// @Description Create a new user with the given data
+// @Description Create a new user with the given data, sent as JSON or as a multipart form with name and email fields
 // @Tags users
-// @Accept json
+// @Accept json,mpfd
 // @Produce json
-// @Param user body models.User true "User data"
+// @Param user body controllers.UserRequest true "User data"
 // @Success 201 {object} models.User
 // @Failure 400 {object} map[string]string
 // @Router /users [post]
 func (uc *UserController) CreateUser(c *gin.Context) {
-	var user models.User
-
-	if err := c.ShouldBindJSON(&user); err != nil {
-		uc.Logger.Warn("Invalid JSON data provided", "error", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var request UserRequest
+	if err := bindNewUser(c, &request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+	user := request.User()
+	if err := uc.UserPolicy.Validate(&user); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	result := uc.DB.Create(&user)
+	result := uc.DB.WithContext(c.Request.Context()).Create(&user)
 	if result.Error != nil {
-		uc.Logger.Error("Failed to create user", "error", result.Error, "email", user.Email)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
 		return
 	}
 
-	uc.Logger.Info("User created successfully", "id", user.ID, "email", user.Email, "name", user.Name)
+	uc.invalidateUsersCache()
+	uc.recordAudit(c, models.AuditActionCreate, user.ID)
+	uc.recordRegistration(c, user.ID)
+	uc.Logger.Info("User created successfully", "user", user)
+
+	// The user exists at this point, a failed notification should not fail the request
+	if err := uc.Mailer.SendWelcome(user); err != nil {
+		uc.Logger.Warn("Failed to send welcome email", "error", err, "user", user)
+	}
 	c.JSON(http.StatusCreated, user)
 }
 
+// bindNewUser fills request from a JSON body or, for HTML form submissions,
+// from the name and email form fields, validating both the same way
+func bindNewUser(c *gin.Context, request *UserRequest) error {
+	if c.ContentType() != gin.MIMEMultipartPOSTForm {
+		return c.ShouldBindJSON(request)
+	}
+	request.Name = c.PostForm("name")
+	request.Email = c.PostForm("email")
+	return binding.Validator.ValidateStruct(request)
+}
+
 // UpdateUser godoc
 // @Summary Update user
 // @Description Update user data by ID
@@ -117,21 +367,19 @@ func (uc *UserController) CreateUser(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param id path int true "User ID"
-// @Param user body models.User true "User data"
+// @Param user body controllers.UpdateUserRequest true "User data, the email is changed through change-email"
 // @Success 200 {object} models.User
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Router /users/{id} [put]
 func (uc *UserController) UpdateUser(c *gin.Context) {
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		uc.Logger.Warn("Invalid user ID provided for update", "id", c.Param("id"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
 		return
 	}
 
 	var user models.User
-	result := uc.DB.First(&user, id)
+	result := uc.DB.WithContext(c.Request.Context()).First(&user, id)
 
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
@@ -139,26 +387,36 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-		uc.Logger.Error("Database error while finding user for update", "error", result.Error, "id", id)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
 		return
 	}
 
-	var updateData models.User
-	if err := c.ShouldBindJSON(&updateData); err != nil {
-		uc.Logger.Warn("Invalid JSON data provided for update", "error", err, "id", id)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var request UpdateUserRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
 		return
 	}
+	updateData := request.User()
 
-	result = uc.DB.Model(&user).Updates(updateData)
-	if result.Error != nil {
-		uc.Logger.Error("Failed to update user", "error", result.Error, "id", id)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+	// Updates copies the changes into user, which is validated before committing
+	err := uc.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(updateData).Error; err != nil {
+			return err
+		}
+		return uc.UserPolicy.Validate(&user)
+	})
+	if errors.Is(err, models.ErrInvalidUser) {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	uc.Logger.Info("User updated successfully", "id", user.ID, "email", user.Email)
+	uc.invalidateUsersCache()
+	uc.recordAudit(c, models.AuditActionUpdate, user.ID)
+	uc.Logger.Info("User updated successfully", "user", user)
 	c.JSON(http.StatusOK, user)
 }
 
@@ -174,15 +432,13 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 // @Failure 404 {object} map[string]string
 // @Router /users/{id} [delete]
 func (uc *UserController) DeleteUser(c *gin.Context) {
-	id, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		uc.Logger.Warn("Invalid user ID provided for deletion", "id", c.Param("id"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
 		return
 	}
 
 	var user models.User
-	result := uc.DB.First(&user, id)
+	result := uc.DB.WithContext(c.Request.Context()).First(&user, id)
 
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
@@ -190,18 +446,33 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-		uc.Logger.Error("Database error while finding user for deletion", "error", result.Error, "id", id)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
 		return
 	}
 
-	result = uc.DB.Delete(&user)
+	result = uc.DB.WithContext(c.Request.Context()).Delete(&user)
 	if result.Error != nil {
-		uc.Logger.Error("Failed to delete user", "error", result.Error, "id", id)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
 		return
 	}
 
-	uc.Logger.Info("User deleted successfully", "id", id, "email", user.Email)
+	uc.invalidateUsersCache()
+	uc.recordAudit(c, models.AuditActionDelete, id)
+	uc.Logger.Info("User deleted successfully", "user", user)
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
+
+// GetStats godoc
+// @Summary Get service statistics
+// @Description Get response cache hit and miss counts
+// @Tags admin
+// @Produce json
+// @Success 200 {object} map[string]cache.Stats
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/stats [get]
+func (uc *UserController) GetStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"cache": uc.Cache.Stats()})
+}
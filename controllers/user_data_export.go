@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"go-api/config"
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+// UserSessions are the logins and devices of a user in their data export
+type UserSessions struct {
+	Logins  []models.LoginEvent `json:"logins"`
+	Devices []models.UserDevice `json:"devices"`
+}
+
+// GetUserDataExport godoc
+// @Summary Export user data
+// @Description Download everything stored about a user as a ZIP of JSON files: profile.json, audit_log.json, sessions.json (logins and devices), consent_history.json and notes.json. Only the user themselves or an admin can.
+// @Tags users
+// @Produce application/zip
+// @Param id path int true "User ID"
+// @Success 200 {file} file "ZIP archive"
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/data-export [get]
+func (uc *UserController) GetUserDataExport(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	user, ok := uc.findUser(c, id)
+	if !ok {
+		return
+	}
+
+	// Everything is loaded before the status is sent, so a failure is still an error response
+	audit := []models.AuditLog{}
+	sessions := UserSessions{Logins: []models.LoginEvent{}, Devices: []models.UserDevice{}}
+	consents := []models.ConsentRecord{}
+	notes := []models.UserNote{}
+	group, ctx := errgroup.WithContext(c.Request.Context())
+	db := uc.DB.WithContext(ctx)
+	group.Go(func() error {
+		return db.Where("entity_type = ? AND entity_id = ?", "user", id).Order("id").Find(&audit).Error
+	})
+	group.Go(func() error {
+		return db.Where("user_id = ?", id).Order("id").Find(&sessions.Logins).Error
+	})
+	group.Go(func() error {
+		return db.Where("user_id = ?", id).Order("id").Find(&sessions.Devices).Error
+	})
+	group.Go(func() error {
+		return db.Where("user_id = ?", id).Order("id").Find(&consents).Error
+	})
+	group.Go(func() error {
+		return db.Where("user_id = ?", id).Order("id").Find(&notes).Error
+	})
+	if err := group.Wait(); err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-data.zip"`, id))
+	c.Status(http.StatusOK)
+
+	// The status is sent, failures past this point can only be logged
+	archive := zip.NewWriter(c.Writer)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", user},
+		{"audit_log.json", audit},
+		{"sessions.json", sessions},
+		{"consent_history.json", consents},
+		{"notes.json", notes},
+	}
+	modified := time.Now()
+	for _, file := range files {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			uc.Logger.Warn("Failed to write data export", "error", err, "id", id)
+			return
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			uc.Logger.Warn("Failed to write data export", "error", err, "id", id, "file", file.name)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		uc.Logger.Warn("Failed to write data export", "error", err, "id", id)
+		return
+	}
+
+	callerID, _ := config.UserIDFromContext(c.Request.Context())
+	uc.Logger.Info("User data exported", "id", id, "caller_id", callerID)
+}
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeletedUser is a soft-deleted user with the time it was deleted
+type DeletedUser struct {
+	models.User
+	Deletion
+}
+
+// Deletion records when a user was deleted
+type Deletion struct {
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+func (u DeletedUser) MarshalJSON() ([]byte, error) {
+	return marshalUserWith(u.User, u.Deletion)
+}
+
+// GetUsersDeleted godoc
+// @Summary List deleted users
+// @Description Get soft-deleted users, optionally only those deleted within a time range, for restoring accounts deleted by mistake
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param after query string false "RFC3339 timestamp, only users deleted after it"
+// @Param before query string false "RFC3339 timestamp, only users deleted before it"
+// @Param page query int false "Page number, starting at 1"
+// @Param per_page query int false "Page size, up to 100"
+// @Param page_size query int false "Page size, an alias of per_page"
+// @Success 200 {array} controllers.DeletedUser
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/deleted [get]
+func (uc *UserController) GetUsersDeleted(c *gin.Context) {
+	pagination, fields := paginate(c)
+	if fields != nil {
+		uc.respondInvalidQuery(c, fields)
+		return
+	}
+
+	query := uc.DB.WithContext(c.Request.Context()).Unscoped().Where("deleted_at IS NOT NULL").Scopes(pagination)
+
+	// Timestamps are stored in local time, compare in the same zone
+	for _, bound := range []struct{ param, condition string }{
+		{"after", "deleted_at > ?"},
+		{"before", "deleted_at < ?"},
+	} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			uc.Logger.Warn("Invalid deletion time bound provided", bound.param, value)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + bound.param + " parameter, expected RFC3339 timestamp"})
+			return
+		}
+		query = query.Where(bound.condition, t.Local())
+	}
+
+	var users []models.User
+	if err := query.Find(&users).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	deleted := make([]DeletedUser, len(users))
+	for i, user := range users {
+		deleted[i] = DeletedUser{User: user, Deletion: Deletion{DeletedAt: user.DeletedAt.Time}}
+	}
+
+	uc.Logger.Debug("Successfully fetched deleted users", "count", len(deleted))
+	c.JSON(http.StatusOK, deleted)
+}
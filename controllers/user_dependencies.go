@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserDependencies is everything a user owns or has access to. Webhooks are
+// not listed, they belong to the deployment rather than to a user.
+type UserDependencies struct {
+	User    models.User         `json:"user"`
+	APIKeys []models.UserAPIKey `json:"api_keys"`
+	// Sessions are the user's most recent successful logins, newest first
+	Sessions []models.LoginEvent `json:"sessions"`
+	SSHKeys  []models.UserSSHKey `json:"ssh_keys"`
+	Devices  []models.UserDevice `json:"devices"`
+	Tags     []string            `json:"tags"`
+	// FeatureFlags names the feature flags that are on for the user
+	FeatureFlags []string `json:"feature_flags"`
+}
+
+// GetUserDependencyGraph godoc
+// @Summary Get user dependencies
+// @Description Get the user with the API keys, SSH keys and devices they own, their recent sessions, their tags and the feature flags that are on for them, in one view for access reviews
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} controllers.UserDependencies
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/dependencies [get]
+func (uc *UserController) GetUserDependencyGraph(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	user, ok := uc.findUser(c, id)
+	if !ok {
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	deps := UserDependencies{
+		User:         user,
+		APIKeys:      []models.UserAPIKey{},
+		Sessions:     []models.LoginEvent{},
+		SSHKeys:      []models.UserSSHKey{},
+		Devices:      []models.UserDevice{},
+		Tags:         []string{},
+		FeatureFlags: []string{},
+	}
+	if err := db.Where("user_id = ?", id).Order("id").Find(&deps.APIKeys).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	err := db.Where("user_id = ? AND success", id).Order("timestamp DESC, id DESC").Limit(defaultIPHistoryLimit).Find(&deps.Sessions).Error
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := db.Where("user_id = ?", id).Order("id").Find(&deps.SSHKeys).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := db.Where("user_id = ?", id).Order("last_seen DESC").Find(&deps.Devices).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := db.Model(&models.UserTag{}).Where("user_id = ?", id).Order("tag").Pluck("tag", &deps.Tags).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	var flags []models.FeatureFlag
+	if err := db.Order("name").Find(&flags).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	for _, flag := range flags {
+		if flag.EnabledFor(user) {
+			deps.FeatureFlags = append(deps.FeatureFlags, flag.Name)
+		}
+	}
+
+	uc.Logger.Debug("Successfully fetched user dependencies", "id", id)
+	c.JSON(http.StatusOK, deps)
+}
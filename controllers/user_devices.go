@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpdateDeviceRequest is the payload for trusting or distrusting a device
+type UpdateDeviceRequest struct {
+	Trusted *bool `json:"trusted" binding:"required"`
+}
+
+// GetUserDevices godoc
+// @Summary List devices
+// @Description Get the devices the user has made authenticated requests from, most recently seen first
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} models.UserDevice
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/devices [get]
+func (uc *UserController) GetUserDevices(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	devices := []models.UserDevice{}
+	if err := uc.DB.WithContext(c.Request.Context()).Where("user_id = ? AND revoked_at IS NULL", id).Order("last_seen DESC").Find(&devices).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Debug("Successfully fetched devices", "id", id, "count", len(devices))
+	c.JSON(http.StatusOK, devices)
+}
+
+// UpdateUserDevice godoc
+// @Summary Update device
+// @Description Mark one of the user's devices as trusted or not. Only trusted devices can change credentials or the trust of devices, a user's first device is trusted.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param device_id path string true "Device ID, as sent in X-Device-ID"
+// @Param request body controllers.UpdateDeviceRequest true "Trust"
+// @Success 200 {object} models.UserDevice
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/devices/{device_id} [patch]
+func (uc *UserController) UpdateUserDevice(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request UpdateDeviceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var device models.UserDevice
+	result := db.Where("user_id = ? AND device_id = ? AND revoked_at IS NULL", id, c.Param("device_id")).Limit(1).Find(&device)
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		uc.Logger.Info("Device not found for update", "id", id, "device_id", c.Param("device_id"))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	if err := db.Model(&device).Update("trusted", *request.Trusted).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("Device trust updated", "id", id, "device_id", device.DeviceID, "trusted", device.Trusted)
+	c.JSON(http.StatusOK, device)
+}
+
+// DeleteUserDevice godoc
+// @Summary Revoke device
+// @Description Revoke one of the user's devices, its requests are rejected from then on
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param device_id path string true "Device ID, as sent in X-Device-ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/devices/{device_id} [delete]
+func (uc *UserController) DeleteUserDevice(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	// The device is kept revoked, deleting it would let its next request register it again
+	result := uc.DB.WithContext(c.Request.Context()).Model(&models.UserDevice{}).
+		Where("user_id = ? AND device_id = ? AND revoked_at IS NULL", id, c.Param("device_id")).
+		Updates(map[string]any{"revoked_at": time.Now(), "trusted": false})
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		uc.Logger.Info("Device not found for revocation", "id", id, "device_id", c.Param("device_id"))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	uc.Logger.Info("Device revoked successfully", "id", id, "device_id", c.Param("device_id"))
+	c.JSON(http.StatusOK, gin.H{"message": "Device revoked successfully"})
+}
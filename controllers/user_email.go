@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// emailChangeTTL is how long an email change confirmation token stays valid
+const emailChangeTTL = 24 * time.Hour
+
+// ChangeEmailRequest is the payload for requesting an email change
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" binding:"required,email"`
+}
+
+// ChangeEmail godoc
+// @Summary Request email change
+// @Description Store a pending email and send a confirmation token to it. Only the user themselves or an admin can.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.ChangeEmailRequest true "New email"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/change-email [post]
+func (uc *UserController) ChangeEmail(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request ChangeEmailRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := uc.UserPolicy.CheckEmail(request.NewEmail); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var user models.User
+	result := db.First(&user, id)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("User not found for email change", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	var taken int64
+	if err := db.Model(&models.User{}).Where("email = ?", request.NewEmail).Count(&taken).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if taken > 0 {
+		uc.Logger.Info("Requested email already in use", "id", id)
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return
+	}
+
+	token := uuid.NewString()
+	expiresAt := time.Now().Add(emailChangeTTL)
+	result = db.Model(&user).Updates(models.User{
+		PendingEmail:         &request.NewEmail,
+		EmailChangeToken:     &token,
+		EmailChangeExpiresAt: &expiresAt,
+	})
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	if err := uc.Mailer.SendEmailChange(user, token); err != nil {
+		uc.Logger.Error("Failed to send email change confirmation", "error", err, "id", id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send confirmation email"})
+		return
+	}
+
+	uc.Logger.Info("Email change requested", "id", id, "pending_email", models.MaskEmail(request.NewEmail))
+	c.JSON(http.StatusAccepted, gin.H{"message": "Confirmation email sent"})
+}
+
+// ConfirmEmail godoc
+// @Summary Confirm email change
+// @Description Apply a pending email change using the emailed confirmation token
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param token query string true "Confirmation token"
+// @Success 200 {object} models.User
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Router /users/confirm-email [get]
+func (uc *UserController) ConfirmEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing token"})
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var user models.User
+	result := db.Where("email_change_token = ?", token).First(&user)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("Unknown or already used email confirmation token")
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid confirmation token"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	if user.PendingEmail == nil || user.EmailChangeExpiresAt == nil || time.Now().After(*user.EmailChangeExpiresAt) {
+		uc.Logger.Info("Expired email confirmation token", "id", user.ID)
+		c.JSON(http.StatusGone, gin.H{"error": "Confirmation token expired"})
+		return
+	}
+
+	var taken int64
+	if err := db.Model(&models.User{}).Where("email = ? AND id <> ?", *user.PendingEmail, user.ID).Count(&taken).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if taken > 0 {
+		uc.Logger.Info("Pending email taken before confirmation", "id", user.ID)
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return
+	}
+
+	result = db.Model(&user).Updates(map[string]any{
+		"email":                   *user.PendingEmail,
+		"pending_email":           nil,
+		"email_change_token":      nil,
+		"email_change_expires_at": nil,
+	})
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	uc.invalidateUsersCache()
+	uc.recordAudit(c, models.AuditActionUpdate, user.ID)
+	uc.Logger.Info("Email changed successfully", "user", user)
+	c.JSON(http.StatusOK, user)
+}
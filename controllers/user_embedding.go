@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"cmp"
+	"errors"
+	"go-api/models"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// defaultSimilarEmbeddings is how many users similar-by-embedding returns without k
+	defaultSimilarEmbeddings = 10
+	// embeddingBatchSize is how many vectors are scored at a time
+	embeddingBatchSize = 500
+)
+
+// SetEmbeddingRequest is the payload for storing a user embedding
+type SetEmbeddingRequest struct {
+	Vector []float32 `json:"vector" binding:"required,min=1,max=4096"`
+}
+
+// EmbeddingMatch is a user ranked by the cosine similarity of their embedding
+type EmbeddingMatch struct {
+	User       models.User `json:"user"`
+	Similarity float64     `json:"similarity"`
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, which
+// have the same length, or 0 when either is all zeros
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// SetUserEmbedding godoc
+// @Summary Set user embedding
+// @Description Store the feature vector of a user, replacing the previous one
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.SetEmbeddingRequest true "Embedding, up to 4096 dimensions"
+// @Success 200 {object} models.UserEmbedding
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/embedding [put]
+func (uc *UserController) SetUserEmbedding(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request SetEmbeddingRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if slices.ContainsFunc(request.Vector, func(v float32) bool { return math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) }) {
+		uc.RespondError(c, http.StatusBadRequest, errors.New("vector values must be finite"))
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	embedding := models.UserEmbedding{UserID: id, Vector: request.Vector}
+	result := uc.DB.WithContext(c.Request.Context()).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"vector", "updated_at"}),
+	}).Create(&embedding)
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	uc.Logger.Info("Embedding stored successfully", "id", id, "dimensions", len(embedding.Vector))
+	c.JSON(http.StatusOK, embedding)
+}
+
+// GetUserEmbedding godoc
+// @Summary Get user embedding
+// @Description Get the feature vector of a user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.UserEmbedding
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/embedding [get]
+func (uc *UserController) GetUserEmbedding(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	embedding, ok := uc.findEmbedding(c, id)
+	if !ok {
+		return
+	}
+
+	uc.Logger.Debug("Successfully fetched embedding", "id", id)
+	c.JSON(http.StatusOK, embedding)
+}
+
+// findEmbedding loads the embedding of the user, answering 404 when there is none
+func (uc *UserController) findEmbedding(c *gin.Context, id uint) (models.UserEmbedding, bool) {
+	var embedding models.UserEmbedding
+	err := uc.DB.WithContext(c.Request.Context()).Where("user_id = ?", id).First(&embedding).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		uc.Logger.Info("Embedding not found", "id", id)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Embedding not found"})
+		return embedding, false
+	}
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return embedding, false
+	}
+	return embedding, true
+}
+
+// GetUsersSimilarByEmbedding godoc
+// @Summary Get users with similar embeddings
+// @Description Get the k users whose embedding has the highest cosine similarity with the given user's, most similar first. Embeddings of another dimension are skipped.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param user_id query int true "User ID"
+// @Param k query int false "Number of users, up to 100" default(10)
+// @Success 200 {array} controllers.EmbeddingMatch
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/similar-by-embedding [get]
+func (uc *UserController) GetUsersSimilarByEmbedding(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Query("user_id"), 10, 64)
+	if err != nil || id == 0 {
+		uc.RespondError(c, http.StatusBadRequest, errors.New("user_id must be a positive integer"))
+		return
+	}
+	k := defaultSimilarEmbeddings
+	if kParam := c.Query("k"); kParam != "" {
+		if k, err = strconv.Atoi(kParam); err != nil || k < 1 || k > maxPageSize {
+			uc.RespondError(c, http.StatusBadRequest, errors.New("k must be between 1 and "+strconv.Itoa(maxPageSize)))
+			return
+		}
+	}
+
+	if _, ok := uc.findUser(c, uint(id)); !ok {
+		return
+	}
+	target, ok := uc.findEmbedding(c, uint(id))
+	if !ok {
+		return
+	}
+
+	// Every vector is scored in Go, keeping only the top k between batches.
+	// Embeddings of users that are deleted or outside the request's tenant
+	// are dropped before ranking, so they can't take the top k.
+	var best []EmbeddingMatch
+	var batch []models.UserEmbedding
+	db := uc.DB.WithContext(c.Request.Context())
+	result := db.Where("user_id <> ?", id).Order("user_id").FindInBatches(&batch, embeddingBatchSize, func(*gorm.DB, int) error {
+		ids := make([]uint, len(batch))
+		for i, embedding := range batch {
+			ids[i] = embedding.UserID
+		}
+		var users []models.User
+		if err := db.Find(&users, ids).Error; err != nil {
+			return err
+		}
+		byID := make(map[uint]models.User, len(users))
+		for _, user := range users {
+			byID[user.ID] = user
+		}
+
+		for _, embedding := range batch {
+			user, ok := byID[embedding.UserID]
+			if !ok || len(embedding.Vector) != len(target.Vector) {
+				continue
+			}
+			best = append(best, EmbeddingMatch{User: user, Similarity: cosineSimilarity(target.Vector, embedding.Vector)})
+		}
+		slices.SortStableFunc(best, func(a, b EmbeddingMatch) int { return cmp.Compare(b.Similarity, a.Similarity) })
+		best = best[:min(len(best), k)]
+		return nil
+	})
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+	matches := best
+	if matches == nil {
+		matches = []EmbeddingMatch{}
+	}
+
+	uc.Logger.Debug("Successfully ranked users by embedding", "id", id, "count", len(matches))
+	c.JSON(http.StatusOK, matches)
+}
@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"go-api/config"
+	"go-api/models"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// exportJobTTL is how long a finished export stays available for download
+const exportJobTTL = time.Hour
+
+// Export job statuses
+const (
+	ExportStatusPending = "pending"
+	ExportStatusRunning = "running"
+	ExportStatusDone    = "done"
+	ExportStatusFailed  = "failed"
+)
+
+// exportCSVHeader names the columns of a user export
+var exportCSVHeader = []string{"id", "name", "email", "role", "timezone", "created_at", "updated_at"}
+
+// ExportJob is the state of a background user export
+type ExportJob struct {
+	ID          string     `json:"job_id"`
+	Status      string     `json:"status"`
+	Rows        int        `json:"rows"`
+	Error       string     `json:"error,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	tenantID uint
+	path     string
+}
+
+// exportJobs keeps export jobs in memory, they don't survive a restart
+type exportJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*ExportJob
+}
+
+func newExportJobs() *exportJobs {
+	return &exportJobs{jobs: make(map[string]*ExportJob)}
+}
+
+// add stores job, dropping jobs and files that finished more than exportJobTTL ago
+func (e *exportJobs) add(job *ExportJob) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, old := range e.jobs {
+		if old.CompletedAt != nil && time.Since(*old.CompletedAt) > exportJobTTL {
+			os.Remove(old.path)
+			delete(e.jobs, id)
+		}
+	}
+	e.jobs[job.ID] = job
+}
+
+// get returns a copy of the job with the given ID, if the tenant may see it
+func (e *exportJobs) get(id string, tenantID uint) (ExportJob, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	job, ok := e.jobs[id]
+	if !ok || job.tenantID != tenantID {
+		return ExportJob{}, false
+	}
+	return *job, true
+}
+
+// update applies fn to the job with the given ID under the lock
+func (e *exportJobs) update(id string, fn func(job *ExportJob)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if job, ok := e.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// CreateExport godoc
+// @Summary Start a user export
+// @Description Start exporting all users as CSV in the background, poll the returned job until it is done, then download the file
+// @Tags admin
+// @Produce json
+// @Param format query string false "Export format, only csv is supported" default(csv)
+// @Success 202 {object} controllers.ExportJob
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/exports [post]
+func (uc *UserController) CreateExport(c *gin.Context) {
+	if format := c.DefaultQuery("format", "csv"); format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported export format, only csv is supported"})
+		return
+	}
+
+	tenantID, _ := config.TenantIDFromContext(c.Request.Context())
+	job := &ExportJob{ID: uuid.NewString(), Status: ExportStatusPending, CreatedAt: time.Now(), tenantID: tenantID}
+	uc.exports.add(job)
+	response := *job // the worker updates job from here on
+
+	// The export outlives the request but keeps its tenant and actor
+	go uc.runExport(context.WithoutCancel(c.Request.Context()), job.ID)
+
+	uc.Logger.Info("User export started", "job_id", job.ID)
+	c.JSON(http.StatusAccepted, response)
+}
+
+// runExport writes every user to a temp file and records the outcome on the job
+func (uc *UserController) runExport(ctx context.Context, id string) {
+	uc.exports.update(id, func(job *ExportJob) { job.Status = ExportStatusRunning })
+
+	path, rows, err := uc.writeUsersCSV(ctx)
+	now := time.Now()
+	uc.exports.update(id, func(job *ExportJob) {
+		job.CompletedAt = &now
+		job.Rows = rows
+		if err != nil {
+			job.Status = ExportStatusFailed
+			job.Error = "Export failed"
+			return
+		}
+		job.Status = ExportStatusDone
+		job.path = path
+	})
+
+	if err != nil {
+		uc.Logger.Error("User export failed", "error", err, "job_id", id)
+		return
+	}
+	uc.Logger.Info("User export finished", "job_id", id, "rows", rows)
+}
+
+// writeUsersCSV streams the users into a new temp file, returning its path
+func (uc *UserController) writeUsersCSV(ctx context.Context) (string, int, error) {
+	file, err := os.CreateTemp("", "users-export-*.csv")
+	if err != nil {
+		return "", 0, err
+	}
+	fail := func(err error) (string, int, error) {
+		file.Close()
+		os.Remove(file.Name())
+		return "", 0, err
+	}
+
+	db := uc.DB.WithContext(ctx)
+	rows, err := db.Model(&models.User{}).Order("id").Rows()
+	if err != nil {
+		return fail(err)
+	}
+	defer rows.Close()
+
+	file.WriteString(utf8BOM)
+	w := csv.NewWriter(file)
+	w.UseCRLF = true
+	w.Write(exportCSVHeader)
+
+	written := 0
+	for rows.Next() {
+		var user models.User
+		if err := db.ScanRows(rows, &user); err != nil {
+			return fail(err)
+		}
+		if err := w.Write([]string{
+			strconv.FormatUint(uint64(user.ID), 10),
+			user.Name,
+			user.Email,
+			user.Role,
+			user.Timezone,
+			user.CreatedAt.UTC().Format(time.RFC3339),
+			user.UpdatedAt.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return fail(err)
+		}
+		written++
+	}
+	if err := rows.Err(); err != nil {
+		return fail(err)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", 0, err
+	}
+	return file.Name(), written, nil
+}
+
+// exportJob loads the job named in the path, answering 404 for unknown jobs
+func (uc *UserController) exportJob(c *gin.Context) (ExportJob, bool) {
+	tenantID, _ := config.TenantIDFromContext(c.Request.Context())
+	job, ok := uc.exports.get(c.Param("job_id"), tenantID)
+	if !ok {
+		uc.Logger.Info("Export job not found", "job_id", c.Param("job_id"))
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export job not found"})
+		return ExportJob{}, false
+	}
+	return job, true
+}
+
+// GetExport godoc
+// @Summary Get a user export
+// @Description Get the status of a user export, with a download URL once it is done
+// @Tags admin
+// @Produce json
+// @Param job_id path string true "Export job ID"
+// @Success 200 {object} controllers.ExportJob
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/exports/{job_id} [get]
+func (uc *UserController) GetExport(c *gin.Context) {
+	job, ok := uc.exportJob(c)
+	if !ok {
+		return
+	}
+	if job.Status == ExportStatusDone {
+		job.DownloadURL = fmt.Sprintf("/api/v1/admin/exports/%s/download", job.ID)
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// DownloadExport godoc
+// @Summary Download a user export
+// @Description Download the CSV file of a finished user export
+// @Tags admin
+// @Produce text/csv
+// @Param job_id path string true "Export job ID"
+// @Success 200 {string} string "CSV"
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/exports/{job_id}/download [get]
+func (uc *UserController) DownloadExport(c *gin.Context) {
+	job, ok := uc.exportJob(c)
+	if !ok {
+		return
+	}
+	if job.Status != ExportStatusDone {
+		c.JSON(http.StatusConflict, gin.H{"error": "Export is not ready", "status": job.Status})
+		return
+	}
+	c.FileAttachment(job.path, fmt.Sprintf("users-export-%s.csv", job.ID))
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// FeatureFlagRequest defines a feature flag, replacing any flag with the same name
+type FeatureFlagRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Enabled       bool     `json:"enabled"`
+	TargetRoles   []string `json:"target_roles" binding:"omitempty,dive,oneof=admin user support"`
+	TargetUserIDs []uint   `json:"target_user_ids" binding:"omitempty,dive,min=1"`
+}
+
+// GetUserFeatureFlags godoc
+// @Summary Get user feature flags
+// @Description Evaluate every feature flag for the user, a flag is on when it is enabled and targets the user's role or ID, or targets nobody in particular
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]bool
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/feature-flags [get]
+func (uc *UserController) GetUserFeatureFlags(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	user, ok := uc.findUser(c, id)
+	if !ok {
+		return
+	}
+
+	var flags []models.FeatureFlag
+	if err := uc.DB.WithContext(c.Request.Context()).Find(&flags).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	evaluated := make(map[string]bool, len(flags))
+	for _, flag := range flags {
+		evaluated[flag.Name] = flag.EnabledFor(user)
+	}
+
+	uc.Logger.Debug("Successfully evaluated feature flags", "id", id, "count", len(evaluated))
+	c.JSON(http.StatusOK, evaluated)
+}
+
+// SetFeatureFlag godoc
+// @Summary Set feature flag
+// @Description Create a feature flag or replace the definition of the flag with the same name
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body controllers.FeatureFlagRequest true "Flag definition"
+// @Success 200 {object} models.FeatureFlag
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/feature-flags [post]
+func (uc *UserController) SetFeatureFlag(c *gin.Context) {
+	var request FeatureFlagRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	flag := models.FeatureFlag{
+		Name:          request.Name,
+		Enabled:       request.Enabled,
+		TargetRoles:   request.TargetRoles,
+		TargetUserIDs: request.TargetUserIDs,
+	}
+	result := uc.DB.WithContext(c.Request.Context()).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled", "target_roles", "target_user_ids", "updated_at"}),
+	}).Create(&flag)
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	// The upsert leaves the ID and creation time of a replaced flag unset
+	if err := uc.DB.WithContext(c.Request.Context()).Where("name = ?", flag.Name).First(&flag).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("Feature flag set", "name", flag.Name, "enabled", flag.Enabled)
+	c.JSON(http.StatusOK, flag)
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"go-api/models"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CountryCount is the number of users registered from a country
+type CountryCount struct {
+	Country string `json:"country"`
+	Count   int64  `json:"count"`
+}
+
+// recordRegistration stores the client IP of a new user and resolves its country in the background
+func (uc *UserController) recordRegistration(c *gin.Context, userID uint) {
+	activity := models.UserActivity{UserID: userID, Action: models.ActivityRegister, IP: c.ClientIP()}
+	if err := uc.DB.WithContext(c.Request.Context()).Create(&activity).Error; err != nil {
+		uc.Logger.Error("Failed to record registration activity", "error", err, "id", userID)
+		return
+	}
+
+	uc.resolveIPCountry(&activity, activity.IP, userID)
+}
+
+// resolveIPCountry looks up the country of ip in the background and stores it
+// in the ip_country column of the already saved record
+func (uc *UserController) resolveIPCountry(record any, ip string, userID uint) {
+	if uc.GeoIP == nil {
+		return
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return
+	}
+
+	// The request context ends with the response, resolve detached from it
+	go func() {
+		country, err := uc.GeoIP.Country(parsed)
+		if err != nil {
+			uc.Logger.Warn("Failed to resolve IP country", "error", err, "id", userID)
+			return
+		}
+		if country == "" {
+			return
+		}
+
+		result := uc.DB.WithContext(context.Background()).Model(record).Update("ip_country", country)
+		if result.Error != nil {
+			uc.Logger.Error("Failed to store IP country", "error", result.Error, "id", userID)
+		}
+	}()
+}
+
+// GetUsersGeolocated godoc
+// @Summary Get users by country
+// @Description Get the number of registered users per country, resolved from the registration IP
+// @Tags analytics
+// @Produce json
+// @Success 200 {array} controllers.CountryCount
+// @Router /analytics/users-by-country [get]
+func (uc *UserController) GetUsersGeolocated(c *gin.Context) {
+	var counts []CountryCount
+	// Counting from users scopes the activities to the request's tenant
+	result := uc.DB.WithContext(c.Request.Context()).Model(&models.User{}).
+		Joins("JOIN user_activities ON user_activities.user_id = users.id").
+		Select("user_activities.ip_country AS country, COUNT(DISTINCT users.id) AS count").
+		Where("user_activities.action = ? AND user_activities.ip_country <> ''", models.ActivityRegister).
+		Group("user_activities.ip_country").
+		Order("count DESC, country").
+		Scan(&counts)
+
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	if counts == nil {
+		counts = []CountryCount{}
+	}
+	uc.Logger.Debug("Successfully counted users by country", "countries", len(counts))
+	c.JSON(http.StatusOK, counts)
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"errors"
+	"go-api/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultGraphNodes = 100
+	maxGraphNodes     = 1000
+)
+
+// GraphEdgeSameDomain labels edges between users sharing an email domain
+const GraphEdgeSameDomain = "same_domain"
+
+// sameDomainEdgesQuery pairs the given users sharing an email domain, each
+// pair once with the lower ID first
+const sameDomainEdgesQuery = `
+SELECT a.id AS from_id, b.id AS to_id
+FROM users AS a
+JOIN users AS b ON b.id > a.id
+    AND lower(substr(b.email, instr(b.email, '@') + 1)) = lower(substr(a.email, instr(a.email, '@') + 1))
+WHERE a.id IN @ids AND b.id IN @ids
+ORDER BY a.id, b.id`
+
+// GraphNode is a user in a graph
+type GraphNode struct {
+	ID    uint   `json:"id"`
+	Label string `json:"label"`
+}
+
+// GraphEdge is an undirected relation between two users in a graph
+type GraphEdge struct {
+	From  uint   `json:"from"`
+	To    uint   `json:"to"`
+	Label string `json:"label"`
+}
+
+// UserGraph is a graph of users for force-directed visualizations
+type UserGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GetUserGraph godoc
+// @Summary Get user graph
+// @Description Get users as graph nodes, connected by edges when they share an email domain. Nodes are the users with the lowest IDs.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param max_nodes query int false "Maximum number of nodes, up to 1000" default(100)
+// @Success 200 {object} controllers.UserGraph
+// @Failure 400 {object} map[string]string
+// @Router /users/graph [get]
+func (uc *UserController) GetUserGraph(c *gin.Context) {
+	maxNodes := defaultGraphNodes
+	if param := c.Query("max_nodes"); param != "" {
+		var err error
+		if maxNodes, err = strconv.Atoi(param); err != nil || maxNodes < 1 || maxNodes > maxGraphNodes {
+			uc.RespondError(c, http.StatusBadRequest, errors.New("max_nodes must be between 1 and "+strconv.Itoa(maxGraphNodes)))
+			return
+		}
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var users []models.User
+	if err := db.Select("id", "name").Order("id").Limit(maxNodes).Find(&users).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	graph := UserGraph{Nodes: make([]GraphNode, len(users)), Edges: []GraphEdge{}}
+	ids := make([]uint, len(users))
+	for i, user := range users {
+		graph.Nodes[i] = GraphNode{ID: user.ID, Label: user.Name}
+		ids[i] = user.ID
+	}
+
+	if len(ids) > 0 {
+		var pairs []struct {
+			FromID uint
+			ToID   uint
+		}
+		if err := db.Raw(sameDomainEdgesQuery, map[string]any{"ids": ids}).Scan(&pairs).Error; err != nil {
+			uc.RespondError(c, http.StatusInternalServerError, err)
+			return
+		}
+		for _, pair := range pairs {
+			graph.Edges = append(graph.Edges, GraphEdge{From: pair.FromID, To: pair.ToID, Label: GraphEdgeSameDomain})
+		}
+	}
+
+	uc.Logger.Debug("Successfully built user graph", "nodes", len(graph.Nodes), "edges", len(graph.Edges))
+	c.JSON(http.StatusOK, graph)
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// heatmapDateLayout is the layout of dates in activity heatmaps, as returned by SQL DATE()
+const heatmapDateLayout = "2006-01-02"
+
+// ActivityDay is the number of audit log events recorded for a user on a day
+type ActivityDay struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+// GetUserActivityHeatmap godoc
+// @Summary Get user activity heatmap
+// @Description Get the number of audit log events for the user on every day of a year, days without events included
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param year query int false "Year, defaults to the current year"
+// @Success 200 {array} controllers.ActivityDay
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/activity-heatmap [get]
+func (uc *UserController) GetUserActivityHeatmap(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	year := time.Now().UTC().Year()
+	if yearParam := c.Query("year"); yearParam != "" {
+		var err error
+		if year, err = strconv.Atoi(yearParam); err != nil || year < 1 || year > 9999 {
+			uc.Logger.Warn("Invalid heatmap year provided", "year", yearParam, "id", id)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "year must be between 1 and 9999"})
+			return
+		}
+	}
+
+	// Deleted users keep their audit history
+	db := uc.DB.WithContext(c.Request.Context())
+	var user models.User
+	result := db.Unscoped().First(&user, id)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("User not found for activity heatmap", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, -1)
+
+	var counts []ActivityDay
+	err := db.Model(&models.AuditLog{}).
+		Select("DATE(created_at) AS date, COUNT(*) AS count").
+		Where("entity_type = ? AND entity_id = ?", "user", id).
+		Where("DATE(created_at) BETWEEN ? AND ?", start.Format(heatmapDateLayout), end.Format(heatmapDateLayout)).
+		Group("DATE(created_at)").
+		Scan(&counts).Error
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	byDate := make(map[string]int64, len(counts))
+	for _, day := range counts {
+		byDate[day.Date] = day.Count
+	}
+
+	heatmap := make([]ActivityDay, 0, end.YearDay())
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format(heatmapDateLayout)
+		heatmap = append(heatmap, ActivityDay{Date: date, Count: byDate[date]})
+	}
+
+	uc.Logger.Debug("Successfully built activity heatmap", "id", id, "year", year, "active_days", len(counts))
+	c.JSON(http.StatusOK, heatmap)
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-api/models"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// changedPlaceholder replaces the values of sensitive fields in diffs
+const changedPlaceholder = "[CHANGED]"
+
+// sensitiveUserFields are stored in snapshots as a hash, so diffs can tell
+// that they changed without revealing them
+var sensitiveUserFields = map[string]func(models.User) *string{
+	"email_change_token":        func(u models.User) *string { return u.EmailChangeToken },
+	"email_verification_secret": func(u models.User) *string { return u.EmailVerificationSecret },
+}
+
+// unversionedUserFields change on every write or are computed, they are left out of diffs
+var unversionedUserFields = []string{"updated_at", "updated_by", "is_active"}
+
+// FieldChange is a field whose value differs between two user versions
+type FieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// recordHistory stores the user's current state as its next version and
+// returns its snapshot
+func recordHistory(db *gorm.DB, userID uint) (*string, error) {
+	var user models.User
+	if err := db.Unscoped().First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("load user for history: %w", err)
+	}
+
+	snapshot, err := userSnapshot(user)
+	if err != nil {
+		return nil, fmt.Errorf("build user snapshot: %w", err)
+	}
+
+	var version int
+	if err := db.Model(&models.UserHistory{}).Where("user_id = ?", userID).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return nil, fmt.Errorf("find latest user version: %w", err)
+	}
+
+	entry := models.UserHistory{UserID: userID, Version: version + 1, Snapshot: snapshot}
+	if err := db.Create(&entry).Error; err != nil {
+		return nil, fmt.Errorf("record user history: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// latestSnapshot returns the snapshot of the user's latest history version,
+// or nil when there is none
+func latestSnapshot(db *gorm.DB, userID uint) (*string, error) {
+	var latest models.UserHistory
+	result := db.Where("user_id = ?", userID).Order("version DESC").Limit(1).Find(&latest)
+	if result.Error != nil {
+		return nil, fmt.Errorf("load latest user version: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &latest.Snapshot, nil
+}
+
+// userSnapshot encodes user with its JSON fields plus hashes of the sensitive ones
+func userSnapshot(user models.User) (string, error) {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", err
+	}
+
+	for name, value := range sensitiveUserFields {
+		fields[name] = nil
+		if v := value(user); v != nil {
+			sum := sha256.Sum256([]byte(*v))
+			fields[name] = hex.EncodeToString(sum[:])
+		}
+	}
+
+	data, err = json.Marshal(fields)
+	return string(data), err
+}
+
+// diffSnapshots lists the fields that differ between two snapshots, sorted by name
+func diffSnapshots(from, to string) ([]FieldChange, error) {
+	var before, after map[string]any
+	if err := json.Unmarshal([]byte(from), &before); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(to), &after); err != nil {
+		return nil, err
+	}
+
+	fields := maps.Clone(before)
+	maps.Copy(fields, after)
+
+	changes := []FieldChange{}
+	for _, field := range slices.Sorted(maps.Keys(fields)) {
+		if slices.Contains(unversionedUserFields, field) || reflect.DeepEqual(before[field], after[field]) {
+			continue
+		}
+		change := FieldChange{Field: field, From: before[field], To: after[field]}
+		if _, ok := sensitiveUserFields[field]; ok {
+			change.From, change.To = changedPlaceholder, changedPlaceholder
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// GetUserDiff godoc
+// @Summary Diff user versions
+// @Description Get the fields that changed between two versions of the user, sensitive values are masked
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param from_version query int true "Older version"
+// @Param to_version query int true "Newer version"
+// @Success 200 {array} controllers.FieldChange
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/diff [get]
+func (uc *UserController) GetUserDiff(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	fromVersion, fromErr := strconv.Atoi(c.Query("from_version"))
+	toVersion, toErr := strconv.Atoi(c.Query("to_version"))
+	if fromErr != nil || toErr != nil || fromVersion < 1 || toVersion < 1 {
+		uc.Logger.Warn("Invalid versions provided for diff", "id", id, "from_version", c.Query("from_version"), "to_version", c.Query("to_version"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from_version and to_version must be positive integers"})
+		return
+	}
+
+	// History rows carry no tenant, the user's own scoping decides access
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	var versions []models.UserHistory
+	result := uc.DB.WithContext(c.Request.Context()).
+		Where("user_id = ? AND version IN ?", id, []int{fromVersion, toVersion}).
+		Find(&versions)
+
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	snapshots := make(map[int]string, len(versions))
+	for _, version := range versions {
+		snapshots[version.Version] = version.Snapshot
+	}
+	from, hasFrom := snapshots[fromVersion]
+	to, hasTo := snapshots[toVersion]
+	if !hasFrom || !hasTo {
+		uc.Logger.Info("User version not found for diff", "id", id, "from_version", fromVersion, "to_version", toVersion)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+
+	changes, err := diffSnapshots(from, to)
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, fmt.Errorf("corrupt user snapshot: %w", err))
+		return
+	}
+
+	uc.Logger.Debug("Successfully diffed user versions", "id", id, "from_version", fromVersion, "to_version", toVersion, "changes", len(changes))
+	c.JSON(http.StatusOK, changes)
+}
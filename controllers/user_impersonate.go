@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"go-api/config"
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// impersonationTTL is how long an impersonation token stays valid
+const impersonationTTL = 15 * time.Minute
+
+// ImpersonationResponse holds a token for acting as another user
+type ImpersonationResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ImpersonateUser godoc
+// @Summary Impersonate user
+// @Description Issue a 15-minute token for acting as a non-admin user, the token carries an impersonated_by claim
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} controllers.ImpersonationResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/impersonate [get]
+func (uc *UserController) ImpersonateUser(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if uc.Tokens == nil {
+		uc.Logger.Warn("Impersonation requested but token signing is not configured")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Impersonation is not available"})
+		return
+	}
+
+	adminID, ok := config.UserIDFromContext(c.Request.Context())
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var user models.User
+	result := uc.DB.WithContext(c.Request.Context()).First(&user, id)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("User not found for impersonation", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	if user.Role == models.RoleAdmin {
+		uc.Logger.Warn("Refused to impersonate an admin", "id", id, "admin_id", adminID)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins cannot be impersonated"})
+		return
+	}
+
+	token, expiresAt, err := uc.Tokens.Issue(user, impersonationTTL, &adminID)
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.recordAudit(c, models.AuditActionImpersonate, user.ID)
+	uc.Logger.Info("Admin impersonating user", "id", user.ID, "admin_id", adminID, "expires_at", expiresAt)
+	c.JSON(http.StatusOK, ImpersonationResponse{Token: token, ExpiresAt: expiresAt})
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+)
+
+const (
+	// MIMENDJSON is the content type of newline-delimited JSON
+	MIMENDJSON = "application/x-ndjson"
+	// importBatchSize is how many users are inserted per transaction
+	importBatchSize = 500
+	// maxImportLineBytes is the longest line an import accepts
+	maxImportLineBytes = 64 * 1024
+	// maxImportErrors caps the errors reported, later ones are only counted as skipped
+	maxImportErrors = 100
+)
+
+// ImportError explains why a line of an import was skipped
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	Imported int           `json:"imported"`
+	Skipped  int           `json:"skipped"`
+	Errors   []ImportError `json:"errors"`
+}
+
+func (r *ImportResult) skip(line int, err string) {
+	r.Skipped++
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, ImportError{Line: line, Error: err})
+	}
+}
+
+// requiredImportFields are the user fields every imported line must set
+type requiredImportFields struct {
+	Name  string `binding:"required"`
+	Email string `binding:"required,email"`
+}
+
+// importedUser is a valid user waiting to be inserted, with its line number
+type importedUser struct {
+	line int
+	user models.User
+}
+
+// ImportUsersJSON godoc
+// @Summary Import users from NDJSON
+// @Description Create a user from every line of a newline-delimited JSON body, each line being a user object with at least name and email. The body is read line by line. Invalid lines and emails already in use are skipped, at most 100 errors are listed. Blank lines are ignored and lines may be up to 64 KiB long.
+// @Tags users
+// @Accept application/x-ndjson
+// @Produce json
+// @Param users body string true "One JSON user per line"
+// @Success 200 {object} controllers.ImportResult
+// @Failure 400 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Router /users/import/ndjson [post]
+func (uc *UserController) ImportUsersJSON(c *gin.Context) {
+	if c.ContentType() != MIMENDJSON {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + MIMENDJSON})
+		return
+	}
+
+	result := ImportResult{Errors: []ImportError{}}
+	batch := make([]importedUser, 0, importBatchSize)
+	flush := func() error {
+		created, err := uc.insertImported(c, batch, &result)
+		batch = batch[:0]
+		if err != nil {
+			return err
+		}
+		if len(created) > 0 {
+			uc.invalidateUsersCache()
+		}
+		for _, id := range created {
+			uc.recordAudit(c, models.AuditActionCreate, id)
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(c.Request.Body)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxImportLineBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		user, err := parseImportedUser(data, uc.UserPolicy)
+		if err != nil {
+			result.skip(line, err.Error())
+			continue
+		}
+
+		if batch = append(batch, importedUser{line: line, user: user}); len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				uc.RespondError(c, http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		uc.Logger.Warn("Failed to read import", "error", err, "line", line+1, "imported", result.Imported)
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to read line %d: %v", line+1, err), "imported": result.Imported})
+		return
+	}
+	if err := flush(); err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("Users imported", "imported", result.Imported, "skipped", result.Skipped)
+	c.JSON(http.StatusOK, result)
+}
+
+// parseImportedUser decodes and validates one line of an import
+func parseImportedUser(data []byte, policy models.UserPolicy) (models.User, error) {
+	var request UserRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return models.User{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := binding.Validator.ValidateStruct(&request); err != nil {
+		return models.User{}, err
+	}
+	if err := binding.Validator.ValidateStruct(requiredImportFields{Name: request.Name, Email: request.Email}); err != nil {
+		return models.User{}, err
+	}
+	user := request.User()
+	if err := policy.Validate(&user); err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// insertImported creates the users of batch in one transaction, skipping those
+// whose email is already in use, and returns the IDs of the created users
+func (uc *UserController) insertImported(c *gin.Context, batch []importedUser, result *ImportResult) ([]uint, error) {
+	if len(batch) == 0 {
+		return nil, nil
+	}
+
+	emails := make([]string, len(batch))
+	for i := range batch {
+		emails[i] = batch[i].user.Email
+	}
+
+	var created []uint
+	var skipped []int
+	err := uc.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		// Deleted users keep their email, so they are looked up too
+		var taken []string
+		if err := tx.Unscoped().Model(&models.User{}).Where("email IN ?", emails).Pluck("email", &taken).Error; err != nil {
+			return err
+		}
+		inUse := make(map[string]bool, len(taken))
+		for _, email := range taken {
+			inUse[email] = true
+		}
+
+		for i := range batch {
+			if inUse[batch[i].user.Email] {
+				skipped = append(skipped, batch[i].line)
+				continue
+			}
+			if err := tx.Create(&batch[i].user).Error; err != nil {
+				return err
+			}
+			inUse[batch[i].user.Email] = true
+			created = append(created, batch[i].user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Imported += len(created)
+	for _, line := range skipped {
+		result.skip(line, "email already in use")
+	}
+	return created, nil
+}
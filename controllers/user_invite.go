@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"go-api/config"
+	"go-api/models"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// defaultInviteTTL is how long an invite stays valid when expires_in is not given
+const defaultInviteTTL = "7d"
+
+// inviteTxConfig makes the single-use check of invites serializable, losing
+// a race with a concurrent acceptance retries and then finds the invite used
+var inviteTxConfig = config.TxConfig{Isolation: sql.LevelSerializable, MaxAttempts: 3, Backoff: 10 * time.Millisecond}
+
+// Errors explaining why an invite can't be accepted
+var (
+	errInviteNotFound = errors.New("invite not found")
+	errInviteUsed     = errors.New("invite already used")
+	errInviteExpired  = errors.New("invite expired")
+	errEmailTaken     = errors.New("email already in use")
+)
+
+// InviteRequest is the payload for inviting someone to register
+type InviteRequest struct {
+	Email     string `json:"email" binding:"required,email"`
+	Role      string `json:"role" binding:"omitempty,oneof=admin user support"`
+	ExpiresIn string `json:"expires_in"`
+}
+
+// InviteResponse is a created invite with the registration link to share
+type InviteResponse struct {
+	models.InviteToken
+	Link string `json:"link"`
+}
+
+// InviteDetails is what an invite link tells the invitee before registering
+type InviteDetails struct {
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AcceptInviteRequest is the payload for registering with an invite
+type AcceptInviteRequest struct {
+	Name     string `json:"name" binding:"required"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+// GenerateInviteLink godoc
+// @Summary Invite user
+// @Description Create a single-use invite to register with the given email and role, and return its registration link
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body controllers.InviteRequest true "Invite, expires_in is a duration like 48h or 7d, 7d by default"
+// @Success 201 {object} controllers.InviteResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/invite [post]
+func (uc *UserController) GenerateInviteLink(c *gin.Context) {
+	var request InviteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	ttl, err := config.ParseRetention(cmp.Or(request.ExpiresIn, defaultInviteTTL))
+	if err != nil || ttl <= 0 {
+		uc.Logger.Warn("Invalid invite expiry provided", "expires_in", request.ExpiresIn)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expires_in, expected a positive duration like 48h or 7d"})
+		return
+	}
+
+	if err := uc.UserPolicy.CheckEmail(request.Email); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var taken int64
+	if err := db.Model(&models.User{}).Where("email = ?", request.Email).Count(&taken).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if taken > 0 {
+		uc.Logger.Info("Invited email already in use")
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+		return
+	}
+
+	invite := models.InviteToken{
+		Token:     uuid.NewString(),
+		Email:     request.Email,
+		Role:      cmp.Or(request.Role, models.RoleUser),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := db.Create(&invite).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	link := url.URL{Scheme: "https", Host: c.Request.Host, Path: "/register", RawQuery: url.Values{"token": {invite.Token}}.Encode()}
+	uc.Logger.Info("Invite created", "invite_id", invite.ID, "email", models.MaskEmail(invite.Email), "role", invite.Role, "expires_at", invite.ExpiresAt)
+	c.JSON(http.StatusCreated, InviteResponse{InviteToken: invite, Link: link.String()})
+}
+
+// GetInvite godoc
+// @Summary Validate invite
+// @Description Check that an invite can still be used and get the email it was sent to
+// @Tags invites
+// @Accept json
+// @Produce json
+// @Param token path string true "Invite token"
+// @Success 200 {object} controllers.InviteDetails
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Router /invites/{token} [get]
+func (uc *UserController) GetInvite(c *gin.Context) {
+	var invite models.InviteToken
+	if err := usableInvite(uc.DB.WithContext(c.Request.Context()), c.Param("token"), &invite); err != nil {
+		uc.respondInviteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, InviteDetails{Email: invite.Email, ExpiresAt: invite.ExpiresAt})
+}
+
+// AcceptInvite godoc
+// @Summary Accept invite
+// @Description Register the invited email with the invite's role, using the invite up
+// @Tags invites
+// @Accept json
+// @Produce json
+// @Param token path string true "Invite token"
+// @Param request body controllers.AcceptInviteRequest true "Account details"
+// @Success 201 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Router /invites/{token}/accept [post]
+func (uc *UserController) AcceptInvite(c *gin.Context) {
+	var request AcceptInviteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	var user models.User
+	if err := uc.setPassword(&user, request.Password); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err := inviteTxConfig.Run(uc.DB.WithContext(c.Request.Context()), func(tx *gorm.DB) error {
+		var invite models.InviteToken
+		if err := usableInvite(tx, c.Param("token"), &invite); err != nil {
+			return err
+		}
+
+		// Claiming the invite first makes concurrent acceptances fail here
+		result := tx.Model(&models.InviteToken{}).Where("id = ? AND used_at IS NULL", invite.ID).Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInviteUsed
+		}
+
+		var taken int64
+		if err := tx.Model(&models.User{}).Where("email = ?", invite.Email).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return errEmailTaken
+		}
+
+		user.Name, user.Email, user.Role, user.TenantID = request.Name, invite.Email, invite.Role, invite.TenantID
+		return tx.Create(&user).Error
+	})
+	if err != nil {
+		uc.respondInviteError(c, err)
+		return
+	}
+
+	// The registration is recorded in the invite's tenant
+	if user.TenantID != nil {
+		c.Request = c.Request.WithContext(config.ContextWithTenantID(c.Request.Context(), *user.TenantID))
+	}
+	uc.invalidateUsersCache()
+	uc.recordAudit(c, models.AuditActionCreate, user.ID)
+	uc.recordRegistration(c, user.ID)
+	uc.Logger.Info("User registered with invite", "user", user)
+
+	if err := uc.Mailer.SendWelcome(user); err != nil {
+		uc.Logger.Warn("Failed to send welcome email", "error", err, "user", user)
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+// usableInvite loads the invite with token into invite, failing when it is
+// unknown, used or expired
+func usableInvite(db *gorm.DB, token string, invite *models.InviteToken) error {
+	if err := db.Where("token = ?", token).First(invite).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInviteNotFound
+		}
+		return err
+	}
+	if invite.UsedAt != nil {
+		return errInviteUsed
+	}
+	if time.Now().After(invite.ExpiresAt) {
+		return errInviteExpired
+	}
+	return nil
+}
+
+// respondInviteError answers with the status matching why an invite can't be used
+func (uc *UserController) respondInviteError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errInviteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+	case errors.Is(err, errInviteUsed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Invite already used"})
+	case errors.Is(err, errEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+	case errors.Is(err, errInviteExpired):
+		c.JSON(http.StatusGone, gin.H{"error": "Invite expired"})
+	default:
+		uc.RespondError(c, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"go-api/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultIPHistoryLimit is how many login events are returned without a limit
+const defaultIPHistoryLimit = 50
+
+// loginEventInterval is how long repeated authentications of a user from the
+// same client with the same outcome are recorded as a single login event
+const loginEventInterval = time.Hour
+
+// RecordLogin stores a login attempt for the user with the client IP and
+// user agent, resolving the IP country in the background. The authentication
+// middleware calls it for successful and failed attempts alike, on every
+// request, so an attempt repeating one recorded within loginEventInterval is
+// dropped to keep the history to sessions rather than requests.
+func (uc *UserController) RecordLogin(c *gin.Context, userID uint, success bool) {
+	event := models.LoginEvent{
+		UserID:    userID,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Timestamp: time.Now(),
+		Success:   success,
+	}
+	key := fmt.Sprintf("%d\x00%s\x00%s\x00%t", userID, event.IPAddress, event.UserAgent, success)
+	if _, recent := uc.recentLogins.Get(key); recent {
+		return
+	}
+
+	if err := uc.DB.WithContext(c.Request.Context()).Create(&event).Error; err != nil {
+		uc.Logger.Error("Failed to record login event", "error", err, "id", userID)
+		return
+	}
+	uc.recentLogins.Set(key, nil)
+
+	uc.resolveIPCountry(&event, event.IPAddress, userID)
+}
+
+// GetUserIPHistory godoc
+// @Summary Get user IP history
+// @Description Get the user's login attempts with their IP address and country, newest first
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Param limit query int false "Number of events, up to 100" default(50)
+// @Success 200 {array} models.LoginEvent
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/ip-history [get]
+func (uc *UserController) GetUserIPHistory(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	limit := defaultIPHistoryLimit
+	if limitParam := c.Query("limit"); limitParam != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitParam); err != nil || limit < 1 || limit > maxPageSize {
+			uc.RespondError(c, http.StatusBadRequest, errors.New("limit must be between 1 and "+strconv.Itoa(maxPageSize)))
+			return
+		}
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	events := []models.LoginEvent{}
+	result := uc.DB.WithContext(c.Request.Context()).Where("user_id = ?", id).
+		Order("timestamp DESC, id DESC").Limit(limit).Find(&events)
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	uc.Logger.Debug("Successfully fetched IP history", "id", id, "count", len(events))
+	c.JSON(http.StatusOK, events)
+}
+
+// GetUserIPHistoryAnomalies godoc
+// @Summary Get unusual user logins
+// @Description Get the user's login attempts from countries other than the one they log in from most, newest first. Attempts whose country is unknown are left out.
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} models.LoginEvent
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/ip-history/anomalies [get]
+func (uc *UserController) GetUserIPHistoryAnomalies(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var usual []string
+	result := db.Model(&models.LoginEvent{}).Where("user_id = ? AND ip_country <> ''", id).
+		Group("ip_country").Order("COUNT(*) DESC, ip_country").Limit(1).Pluck("ip_country", &usual)
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	events := []models.LoginEvent{}
+	if len(usual) > 0 {
+		result = db.Where("user_id = ? AND ip_country <> '' AND ip_country <> ?", id, usual[0]).
+			Order("timestamp DESC, id DESC").Find(&events)
+		if result.Error != nil {
+			uc.RespondError(c, http.StatusInternalServerError, result.Error)
+			return
+		}
+	}
+
+	uc.Logger.Debug("Successfully fetched IP history anomalies", "id", id, "count", len(events))
+	c.JSON(http.StatusOK, events)
+}
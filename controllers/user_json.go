@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"encoding/json"
+	"go-api/models"
+	"maps"
+)
+
+// marshalUserWith marshals user with the fields of extra, a struct, added to
+// it. Response types embedding models.User use it for their MarshalJSON, as
+// the promoted User.MarshalJSON only encodes the user.
+func marshalUserWith(user models.User, extra any) ([]byte, error) {
+	fields, err := jsonFields(user)
+	if err != nil {
+		return nil, err
+	}
+	extraFields, err := jsonFields(extra)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(fields, extraFields)
+	return json.Marshal(fields)
+}
+
+// jsonFields marshals v and splits the resulting object into its fields
+func jsonFields(v any) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/form/v4"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// UserListQuery holds the query parameters filtering, sorting and paginating GET /users
+type UserListQuery struct {
+	PageQuery
+	// AfterID and Limit select keyset pagination, which seeks past AfterID on
+	// the primary key instead of counting skipped rows
+	AfterID       *uint      `form:"after_id"`
+	Limit         int        `form:"limit" binding:"omitempty,min=1,max=100"`
+	Sort          string     `form:"sort" binding:"omitempty,oneof=id name email created_at"`
+	Order         string     `form:"order" binding:"omitempty,oneof=asc desc"`
+	Name          string     `form:"name"`
+	Email         string     `form:"email" binding:"omitempty,email"`
+	CreatedAfter  *time.Time `form:"created_after"`
+	CreatedBefore *time.Time `form:"created_before"`
+	// Meta holds meta[key]=value filters, users must have every value
+	Meta map[string]string `form:"meta" binding:"omitempty,max=10"`
+}
+
+// QueryError is a 400 response listing the invalid query parameters
+type QueryError struct {
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields"`
+}
+
+// queryDecoder decodes query strings into structs by their form tags
+var queryDecoder = func() *form.Decoder {
+	decoder := form.NewDecoder()
+	decoder.RegisterCustomTypeFunc(func(values []string) (any, error) {
+		t, err := time.Parse(time.RFC3339, values[0])
+		if err != nil {
+			return nil, errors.New("must be an RFC3339 timestamp")
+		}
+		return t, nil
+	}, time.Time{})
+	return decoder
+}()
+
+// bindQuery decodes and validates the query string into dst, which must point
+// to a struct. The returned map names each invalid parameter with the reason.
+func bindQuery(values url.Values, dst any) map[string]string {
+	fields := make(map[string]string)
+	var decodeErrs form.DecodeErrors
+	if err := queryDecoder.Decode(dst, values); errors.As(err, &decodeErrs) {
+		for name, err := range decodeErrs {
+			fields[name] = decodeMessage(dst, name, err)
+		}
+	} else if err != nil {
+		fields["query"] = err.Error()
+	}
+
+	var validationErrs validator.ValidationErrors
+	if err := binding.Validator.ValidateStruct(dst); errors.As(err, &validationErrs) {
+		for _, fe := range validationErrs {
+			name := formName(dst, fe.StructField())
+			if _, ok := fields[name]; !ok {
+				fields[name] = validationMessage(fe)
+			}
+		}
+	} else if err != nil {
+		fields["query"] = err.Error()
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// formField returns the struct field of dst tagged with the form name
+func formField(dst any, name string) (reflect.StructField, bool) {
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(dst).Elem()) {
+		if !field.Anonymous && field.Tag.Get("form") == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// formName returns the form name of the struct field of dst
+func formName(dst any, structField string) string {
+	if field, ok := reflect.TypeOf(dst).Elem().FieldByName(structField); ok {
+		if name := field.Tag.Get("form"); name != "" {
+			return name
+		}
+	}
+	return structField
+}
+
+// decodeMessage explains why the named parameter could not be decoded
+func decodeMessage(dst any, name string, err error) string {
+	field, ok := formField(dst, name)
+	if !ok {
+		return err.Error()
+	}
+	kind := field.Type.Kind()
+	if kind == reflect.Pointer {
+		kind = field.Type.Elem().Kind()
+	}
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "must be an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "must be a non-negative integer"
+	case reflect.Bool:
+		return "must be true or false"
+	}
+	return err.Error()
+}
+
+// validationMessage explains a failed validation rule
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "min":
+		return "must be at least " + fe.Param()
+	case "max":
+		return "must be at most " + fe.Param()
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "email":
+		return "must be an email address"
+	}
+	return fmt.Sprintf("failed the %s rule", fe.Tag())
+}
+
+// keyset reports whether after_id or limit selects keyset pagination
+func (q UserListQuery) keyset() bool {
+	return q.AfterID != nil || q.Limit != 0
+}
+
+// validate checks the parameters that are only invalid in combination
+func (q UserListQuery) validate() map[string]string {
+	if q.keyset() && (q.paginated() || q.Sort != "" || q.Order != "") {
+		return map[string]string{"after_id": "can't be combined with page, per_page, page_size, sort or order"}
+	}
+	return nil
+}
+
+// limit returns the keyset page size
+func (q UserListQuery) limit() int {
+	return cmp.Or(q.Limit, defaultPageSize)
+}
+
+// nextAfterID returns the after_id of the keyset page following one holding
+// count users up to lastID, false when that page was the last
+func (q UserListQuery) nextAfterID(count int, lastID uint) (string, bool) {
+	if !q.keyset() || count < q.limit() {
+		return "", false
+	}
+	return strconv.FormatUint(uint64(lastID), 10), true
+}
+
+// scope returns a scope applying the filters, sorting and pagination of q.
+// Results are left unpaginated when no page parameter is given.
+func (q UserListQuery) scope() func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = q.filter(db)
+		if q.keyset() {
+			var afterID uint
+			if q.AfterID != nil {
+				afterID = *q.AfterID
+			}
+			return db.Where("users.id > ?", afterID).Order("users.id").Limit(q.limit())
+		}
+
+		if q.Sort != "" || q.Order != "" || q.paginated() {
+			sort := cmp.Or(q.Sort, "id")
+			// Sort and Order are restricted to known values by validation,
+			// columns are qualified as metadata filters join other tables
+			db = db.Order("users." + sort + " " + strings.ToUpper(cmp.Or(q.Order, "asc")))
+			if sort != "id" {
+				db = db.Order("users.id")
+			}
+		}
+		if q.paginated() {
+			db = q.pageScope(db)
+		}
+		return db
+	}
+}
+
+// filter applies the filters of q
+func (q UserListQuery) filter(db *gorm.DB) *gorm.DB {
+	if q.Name != "" {
+		db = db.Where("users.name LIKE ?", "%"+q.Name+"%")
+	}
+	if q.Email != "" {
+		db = db.Where("LOWER(users.email) = LOWER(?)", q.Email)
+	}
+	// Timestamps are stored in local time, compare in the same zone
+	if q.CreatedAfter != nil {
+		db = db.Where("users.created_at > ?", q.CreatedAfter.Local())
+	}
+	if q.CreatedBefore != nil {
+		db = db.Where("users.created_at < ?", q.CreatedBefore.Local())
+	}
+	// Each filter joins its own copy of user_metadata, sorted for a stable
+	// query. Only the user columns are kept unless the caller selects its own.
+	if len(q.Meta) > 0 {
+		if len(db.Statement.Selects) == 0 {
+			db = db.Select("users.*")
+		}
+		for i, key := range slices.Sorted(maps.Keys(q.Meta)) {
+			alias := fmt.Sprintf("meta_%d", i)
+			db = db.Joins(fmt.Sprintf("JOIN user_metadata AS %[1]s ON %[1]s.user_id = users.id", alias)).
+				Where(alias+".key = ? AND "+alias+".value = ?", key, q.Meta[key])
+		}
+	}
+	return db
+}
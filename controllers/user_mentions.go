@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles a user can have in an audit log entry mentioning them
+const (
+	MentionRoleActor   = "actor"
+	MentionRoleSubject = "subject"
+)
+
+// UserMention is an audit log entry mentioning a user, with the role the user had in it
+type UserMention struct {
+	models.AuditLog
+	Role string `json:"role"`
+}
+
+// GetUserMentions godoc
+// @Summary Get user mentions
+// @Description Get the audit log entries where the user is the actor or the changed user, oldest first. Changes users made to themselves are listed once, as actor. When the page is full, the X-Next-After-ID header holds the after_id of the next page.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param after_id query int false "Return entries with a greater ID"
+// @Param limit query int false "Number of entries, up to 100" default(20)
+// @Success 200 {array} controllers.UserMention
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/mentions [get]
+func (uc *UserController) GetUserMentions(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	pagination, limit, err := keyset(c)
+	if err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Deleted users keep their audit log
+	db := uc.DB.WithContext(c.Request.Context())
+	var count int64
+	if err := db.Unscoped().Model(&models.User{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if count == 0 {
+		uc.Logger.Info("User not found for mentions", "id", id)
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var entries []models.AuditLog
+	result := db.Scopes(pagination).
+		Where("actor_id = ? OR (entity_type = ? AND entity_id = ?)", id, "user", id).
+		Find(&entries)
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	mentions := make([]UserMention, len(entries))
+	for i, entry := range entries {
+		role := MentionRoleSubject
+		if entry.ActorID != nil && *entry.ActorID == id {
+			role = MentionRoleActor
+		}
+		mentions[i] = UserMention{AuditLog: entry, Role: role}
+	}
+
+	if len(entries) == limit {
+		c.Header("X-Next-After-ID", strconv.FormatUint(uint64(entries[len(entries)-1].ID), 10))
+	}
+
+	uc.Logger.Debug("Successfully fetched user mentions", "id", id, "count", len(mentions))
+	c.JSON(http.StatusOK, mentions)
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"errors"
+	"go-api/models"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// metadataKeyPattern restricts keys to characters usable in meta[key] query parameters
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// errInvalidMetadataKey is returned for keys not matching metadataKeyPattern
+var errInvalidMetadataKey = errors.New("key must be 1 to 64 letters, digits, '_', '.' or '-'")
+
+// SetMetadataRequest is the payload for setting a metadata value
+type SetMetadataRequest struct {
+	Key   string `json:"key" binding:"required"`
+	Value string `json:"value" binding:"max=1024"`
+}
+
+// SetUserMetadata godoc
+// @Summary Set user metadata
+// @Description Set a deployment-specific attribute of a user, replacing the value the key had
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.SetMetadataRequest true "Metadata"
+// @Success 200 {object} models.UserMetadata
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/metadata [post]
+func (uc *UserController) SetUserMetadata(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request SetMetadataRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if !metadataKeyPattern.MatchString(request.Key) {
+		uc.RespondError(c, http.StatusBadRequest, errInvalidMetadataKey)
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	metadata := models.UserMetadata{UserID: id, Key: request.Key, Value: request.Value}
+	result := uc.DB.WithContext(c.Request.Context()).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&metadata)
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	// User lists filter on metadata
+	uc.invalidateUsersCache()
+	uc.Logger.Info("Metadata set successfully", "id", id, "key", metadata.Key)
+	c.JSON(http.StatusOK, metadata)
+}
+
+// GetUserMetadata godoc
+// @Summary List user metadata
+// @Description Get the deployment-specific attributes of a user, sorted by key
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} models.UserMetadata
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/metadata [get]
+func (uc *UserController) GetUserMetadata(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	metadata := []models.UserMetadata{}
+	if err := uc.DB.WithContext(c.Request.Context()).Where("user_id = ?", id).Order("key").Find(&metadata).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Debug("Successfully fetched metadata", "id", id, "count", len(metadata))
+	c.JSON(http.StatusOK, metadata)
+}
+
+// DeleteUserMetadata godoc
+// @Summary Delete user metadata
+// @Description Remove a deployment-specific attribute of a user
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Param key path string true "Metadata key"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/metadata/{key} [delete]
+func (uc *UserController) DeleteUserMetadata(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	key := c.Param("key")
+	result := uc.DB.WithContext(c.Request.Context()).Where("user_id = ? AND key = ?", id, key).Delete(&models.UserMetadata{})
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		uc.Logger.Info("Metadata not found", "id", id, "key", key)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Metadata not found"})
+		return
+	}
+
+	uc.invalidateUsersCache()
+	uc.Logger.Info("Metadata deleted successfully", "id", id, "key", key)
+	c.JSON(http.StatusOK, gin.H{"message": "Metadata deleted successfully"})
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-api/geoip"
+	"go-api/models"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseCoordinate parses a required float query parameter within [min, max]
+func parseCoordinate(c *gin.Context, param string, min, max float64) (float64, error) {
+	value, err := strconv.ParseFloat(c.Query(param), 64)
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("%s must be a number between %g and %g", param, min, max)
+	}
+	return value, nil
+}
+
+// GetUsersByDistance godoc
+// @Summary Get users near a location
+// @Description Get users whose registration country, resolved from the registration IP, has its center within radius_km of the point, nearest first. Results are cached for 5 minutes.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param lat query number true "Latitude"
+// @Param lon query number true "Longitude"
+// @Param radius_km query number true "Radius in kilometers"
+// @Success 200 {array} models.User
+// @Failure 400 {object} map[string]string
+// @Router /users/nearby [get]
+func (uc *UserController) GetUsersByDistance(c *gin.Context) {
+	lat, err := parseCoordinate(c, "lat", -90, 90)
+	if err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+	lon, err := parseCoordinate(c, "lon", -180, 180)
+	if err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+	radius, err := strconv.ParseFloat(c.Query("radius_km"), 64)
+	if err != nil || radius <= 0 {
+		uc.RespondError(c, http.StatusBadRequest, errors.New("radius_km must be a positive number"))
+		return
+	}
+
+	key := fmt.Sprintf("nearby:%g:%g:%g", lat, lon, radius)
+	if tenantID := requestTenant(c); tenantID != nil {
+		key += fmt.Sprintf(":%d", *tenantID)
+	}
+	if body, ok := uc.StatsCache.Get(key); ok {
+		c.Header("X-Cache", "HIT")
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var registrations []models.UserActivity
+	if err := db.Where("action = ? AND ip_country <> ''", models.ActivityRegister).Find(&registrations).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Filter on distance in Go, SQLite has no trigonometric functions by default
+	origin := geoip.Point{Lat: lat, Lon: lon}
+	distances := map[uint]float64{}
+	for _, registration := range registrations {
+		centroid, ok := geoip.CountryCentroid(registration.IPCountry)
+		if !ok {
+			continue
+		}
+		if distance := geoip.DistanceKm(origin, centroid); distance <= radius {
+			distances[registration.UserID] = distance
+		}
+	}
+
+	users := []models.User{}
+	if len(distances) > 0 {
+		ids := make([]uint, 0, len(distances))
+		for id := range distances {
+			ids = append(ids, id)
+		}
+		if err := db.Where("id IN ?", ids).Find(&users).Error; err != nil {
+			uc.RespondError(c, http.StatusInternalServerError, err)
+			return
+		}
+		slices.SortFunc(users, func(a, b models.User) int {
+			return cmp.Or(cmp.Compare(distances[a.ID], distances[b.ID]), cmp.Compare(a.ID, b.ID))
+		})
+	}
+
+	body, err := json.Marshal(users)
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	uc.StatsCache.Set(key, body)
+
+	uc.Logger.Debug("Successfully found nearby users", "lat", lat, "lon", lon, "radius_km", radius, "count", len(users))
+	c.Header("X-Cache", "MISS")
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"errors"
+	"go-api/config"
+	"go-api/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// UserNoteRequest is the payload for writing a note
+type UserNoteRequest struct {
+	Content string `json:"content" binding:"required,max=10000"`
+}
+
+// noteAuthor returns the authenticated user writing a note, answering 401
+// when the request is not attributed to a user
+func noteAuthor(c *gin.Context) (uint, bool) {
+	authorID, ok := config.UserIDFromContext(c.Request.Context())
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+	}
+	return authorID, ok
+}
+
+// findNote loads the note named in the path for the user, answering 404 when
+// it does not exist and 403 when the caller is neither its author nor an admin
+func (uc *UserController) findNote(c *gin.Context, userID uint) (models.UserNote, bool) {
+	var note models.UserNote
+	noteID, ok := uc.ParseID(c, "note_id")
+	if !ok {
+		return note, false
+	}
+	callerID, ok := noteAuthor(c)
+	if !ok {
+		return note, false
+	}
+
+	err := uc.DB.WithContext(c.Request.Context()).Where("id = ? AND user_id = ?", noteID, userID).First(&note).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		uc.Logger.Info("Note not found", "id", userID, "note_id", noteID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		return note, false
+	}
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return note, false
+	}
+
+	if role, _ := config.RoleFromContext(c.Request.Context()); note.AuthorID != callerID && role != models.RoleAdmin {
+		uc.Logger.Warn("Note change by another agent rejected", "id", userID, "note_id", noteID, "author_id", note.AuthorID, "caller_id", callerID)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the author or an admin can change a note"})
+		return note, false
+	}
+	return note, true
+}
+
+// CreateUserNote godoc
+// @Summary Create user note
+// @Description Attach a private note to a user account, written by the authenticated support agent or admin
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.UserNoteRequest true "Note"
+// @Success 201 {object} models.UserNote
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/notes [post]
+func (uc *UserController) CreateUserNote(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request UserNoteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	authorID, ok := noteAuthor(c)
+	if !ok {
+		return
+	}
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	note := models.UserNote{UserID: id, AuthorID: authorID, Content: request.Content}
+	if err := uc.DB.WithContext(c.Request.Context()).Create(&note).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("Note created successfully", "id", id, "note_id", note.ID, "author_id", authorID)
+	c.JSON(http.StatusCreated, note)
+}
+
+// GetUserNotes godoc
+// @Summary List user notes
+// @Description Get the notes kept on a user account, oldest first. When the page is full, the X-Next-After-ID header holds the after_id of the next page.
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Param after_id query int false "Return notes with a greater ID"
+// @Param limit query int false "Number of notes, up to 100" default(20)
+// @Success 200 {array} models.UserNote
+// @Header 200 {integer} X-Next-After-ID "after_id of the next page, absent on the last page"
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/notes [get]
+func (uc *UserController) GetUserNotes(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	pagination, limit, err := keyset(c)
+	if err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	notes := []models.UserNote{}
+	if err := uc.DB.WithContext(c.Request.Context()).Scopes(pagination).Where("user_id = ?", id).Find(&notes).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(notes) == limit {
+		c.Header("X-Next-After-ID", strconv.FormatUint(uint64(notes[len(notes)-1].ID), 10))
+	}
+
+	uc.Logger.Debug("Successfully fetched notes", "id", id, "count", len(notes))
+	c.JSON(http.StatusOK, notes)
+}
+
+// UpdateUserNote godoc
+// @Summary Edit user note
+// @Description Replace the content of a note, only its author or an admin can
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param note_id path int true "Note ID"
+// @Param request body controllers.UserNoteRequest true "Note"
+// @Success 200 {object} models.UserNote
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/notes/{note_id} [patch]
+func (uc *UserController) UpdateUserNote(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request UserNoteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	note, ok := uc.findNote(c, id)
+	if !ok {
+		return
+	}
+
+	if err := uc.DB.WithContext(c.Request.Context()).Model(&note).Update("content", request.Content).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("Note updated successfully", "id", id, "note_id", note.ID)
+	c.JSON(http.StatusOK, note)
+}
+
+// DeleteUserNote godoc
+// @Summary Delete user note
+// @Description Delete a note, only its author or an admin can. Deleted notes are kept but no longer listed.
+// @Tags admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Param note_id path int true "Note ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/notes/{note_id} [delete]
+func (uc *UserController) DeleteUserNote(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	note, ok := uc.findNote(c, id)
+	if !ok {
+		return
+	}
+
+	if err := uc.DB.WithContext(c.Request.Context()).Delete(&note).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("Note deleted successfully", "id", id, "note_id", note.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Note deleted successfully"})
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"go-api/config"
+	"go-api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ChangePasswordRequest is the payload for changing a password. The current
+// password is needed when users change their own.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"max=72"`
+	NewPassword     string `json:"new_password" binding:"required,min=8,max=72"`
+}
+
+// setPassword stores the hash of password on user, restarting its expiry and
+// the expiry reminder. Every password change goes through it.
+func (uc *UserController) setPassword(user *models.User, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	passwordHash := string(hash)
+	user.PasswordHash = &passwordHash
+	user.PasswordExpiresAt = uc.passwordExpiry()
+	user.PasswordExpiryRemindedAt = nil
+	return nil
+}
+
+// ChangePassword godoc
+// @Summary Change password
+// @Description Set a new password, which restarts its expiry. Users changing their own password must give the current one, admins can reset anyone's.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.ChangePasswordRequest true "Passwords"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /users/{id}/password [put]
+func (uc *UserController) ChangePassword(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request ChangePasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, ok := uc.findUser(c, id)
+	if !ok {
+		return
+	}
+
+	callerID, _ := config.UserIDFromContext(c.Request.Context())
+	if callerID == user.ID && user.PasswordHash != nil &&
+		bcrypt.CompareHashAndPassword([]byte(*user.PasswordHash), []byte(request.CurrentPassword)) != nil {
+		uc.Logger.Info("Password change with a wrong current password", "id", id)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	if err := uc.setPassword(&user, request.NewPassword); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+	err := uc.DB.WithContext(c.Request.Context()).Model(&user).
+		Select("PasswordHash", "PasswordExpiresAt", "PasswordExpiryRemindedAt").
+		Updates(&user).Error
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.invalidateUsersCache()
+	uc.recordAudit(c, models.AuditActionUpdate, user.ID)
+	uc.Logger.Info("Password changed", "id", user.ID, "by", callerID)
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// passwordExpiryNotice is how long before their password expires users are reminded
+const passwordExpiryNotice = 7 * 24 * time.Hour
+
+// passwordExpiry returns when a password set now expires, nil when passwords don't expire
+func (uc *UserController) passwordExpiry() *time.Time {
+	if uc.PasswordMaxAge <= 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(uc.PasswordMaxAge)
+	return &expiresAt
+}
+
+// GetUsersWithExpiredPasswords godoc
+// @Summary List users with expired passwords
+// @Description Get the users whose password has expired and must be reset, longest expired first
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param page query int false "Page number, starting at 1"
+// @Param per_page query int false "Page size, up to 100"
+// @Param page_size query int false "Page size, an alias of per_page"
+// @Success 200 {array} models.User
+// @Failure 400 {object} controllers.QueryError
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/password-expired [get]
+func (uc *UserController) GetUsersWithExpiredPasswords(c *gin.Context) {
+	pagination, fields := paginate(c)
+	if fields != nil {
+		uc.respondInvalidQuery(c, fields)
+		return
+	}
+
+	users := []models.User{}
+	result := uc.DB.WithContext(c.Request.Context()).
+		Where("password_expires_at < ?", time.Now()).
+		Order("password_expires_at").
+		Scopes(pagination).
+		Find(&users)
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	uc.Logger.Debug("Successfully fetched users with expired passwords", "count", len(users))
+	c.JSON(http.StatusOK, users)
+}
+
+// RemindExpiringPasswords emails the users whose password expires within the
+// next 7 days and who were not reminded since it was set, returning how many
+// were reminded. Setting a password clears the reminder, so each expiry gets
+// one. A failed email is logged and retried on the next run.
+func (uc *UserController) RemindExpiringPasswords(ctx context.Context) (int, error) {
+	db := uc.DB.WithContext(ctx)
+	now := time.Now()
+
+	var users []models.User
+	err := db.Where("password_expires_at >= ? AND password_expires_at < ? AND password_expiry_reminded_at IS NULL", now, now.Add(passwordExpiryNotice)).
+		Order("id").
+		Find(&users).Error
+	if err != nil {
+		return 0, err
+	}
+
+	reminded := 0
+	for _, user := range users {
+		if err := uc.Mailer.SendPasswordExpiry(user); err != nil {
+			uc.Logger.Error("Failed to send password expiry reminder", "error", err, "user", user)
+			continue
+		}
+		if err := db.Model(&user).UpdateColumn("password_expiry_reminded_at", now).Error; err != nil {
+			return reminded, err
+		}
+		reminded++
+	}
+	return reminded, nil
+}
+
+// StartPasswordExpiryReminders runs RemindExpiringPasswords right away, then
+// every interval until ctx is done
+func (uc *UserController) StartPasswordExpiryReminders(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			reminded, err := uc.RemindExpiringPasswords(ctx)
+			if err != nil {
+				uc.Logger.Error("Password expiry reminders failed", "error", err, "reminded", reminded)
+			} else {
+				uc.Logger.Info("Sent password expiry reminders", "reminded", reminded)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SearchUsersByPreference godoc
+// @Summary Search users by preference
+// @Description Get the users whose preference key is set to value, such as theme=dark or email_notifications=true
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param key query string true "Preference name" Enums(theme, language, email_notifications, items_per_page)
+// @Param value query string true "Preference value"
+// @Param page query int false "Page number, starting at 1"
+// @Param per_page query int false "Page size, up to 100"
+// @Param page_size query int false "Page size, an alias of per_page"
+// @Success 200 {array} models.User
+// @Failure 400 {object} map[string]string
+// @Router /users/search-by-preference [get]
+func (uc *UserController) SearchUsersByPreference(c *gin.Context) {
+	query := models.UserPreferenceQuery{Key: c.Query("key"), Value: c.Query("value")}
+	if err := query.Validate(); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	pagination, fields := paginate(c)
+	if fields != nil {
+		uc.respondInvalidQuery(c, fields)
+		return
+	}
+
+	users := []models.User{}
+	if err := uc.DB.WithContext(c.Request.Context()).Scopes(query.Scope(), pagination).Order("id").Find(&users).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Debug("Successfully searched users by preference", "key", query.Key, "count", len(users))
+	c.JSON(http.StatusOK, users)
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserPreferences godoc
+// @Summary Get user preferences
+// @Description Get the user's settings
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} models.UserPreferences
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/preferences [get]
+func (uc *UserController) GetUserPreferences(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	user, ok := uc.findUser(c, id)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Preferences)
+}
+
+// UpdateUserPreferences godoc
+// @Summary Update user preferences
+// @Description Change the settings given in the body, leaving the others as they are
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param preferences body models.UserPreferences true "Settings to change"
+// @Success 200 {object} models.UserPreferences
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/preferences [patch]
+func (uc *UserController) UpdateUserPreferences(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	user, ok := uc.findUser(c, id)
+	if !ok {
+		return
+	}
+
+	// Decoding over the stored preferences keeps the fields missing from the body
+	if err := c.ShouldBindJSON(&user.Preferences); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	if err := db.Model(&user).Select("Preferences").Updates(models.User{Preferences: user.Preferences}).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.invalidateUsersCache()
+	uc.recordAudit(c, models.AuditActionUpdate, user.ID)
+	uc.Logger.Info("User preferences updated", "id", user.ID)
+	c.JSON(http.StatusOK, user.Preferences)
+}
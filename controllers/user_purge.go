@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"go-api/config"
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// defaultPurgeAge is how long users stay soft-deleted before a purge removes them
+const defaultPurgeAge = "30d"
+
+// PurgeResponse is the number of users removed by a purge
+type PurgeResponse struct {
+	Purged int64 `json:"purged"`
+}
+
+// purgeDeletedUsers permanently removes users soft-deleted before cutoff along
+// with their tags, activities, history and SSH keys. Audit logs are kept.
+func (uc *UserController) purgeDeletedUsers(ctx context.Context, cutoff time.Time) (int64, error) {
+	var purged int64
+	err := uc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		if err := tx.Unscoped().Model(&models.User{}).Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		for _, dependent := range []any{&models.UserTag{}, &models.UserActivity{}, &models.UserHistory{}, &models.UserSSHKey{}, &models.UserDevice{}, &models.LoginEvent{}, &models.ConsentRecord{}, &models.UserAPIKey{}, &models.UserNote{}, &models.GDPRRequest{}, &models.UserEmbedding{}, &models.UserMetadata{}} {
+			if err := tx.Unscoped().Where("user_id IN ?", ids).Delete(dependent).Error; err != nil {
+				return err
+			}
+		}
+		// Keep the users they created or updated
+		for _, column := range []string{"created_by", "updated_by"} {
+			if err := tx.Unscoped().Model(&models.User{}).Where(column+" IN ?", ids).UpdateColumn(column, nil).Error; err != nil {
+				return err
+			}
+		}
+
+		result := tx.Unscoped().Where("id IN ?", ids).Delete(&models.User{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	return purged, err
+}
+
+// PurgeDeletedUsers godoc
+// @Summary Purge deleted users
+// @Description Permanently delete users soft-deleted longer ago than older_than, with their tags, activities, history and SSH keys
+// @Tags admin
+// @Produce json
+// @Param older_than query string false "Minimum time since deletion, e.g. 30d or 12h" default(30d)
+// @Success 200 {object} controllers.PurgeResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/purge-deleted [post]
+func (uc *UserController) PurgeDeletedUsers(c *gin.Context) {
+	age, err := config.ParseRetention(c.DefaultQuery("older_than", defaultPurgeAge))
+	if err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	purged, err := uc.purgeDeletedUsers(c.Request.Context(), time.Now().Add(-age))
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if purged > 0 {
+		uc.invalidateUsersCache()
+	}
+	uc.Logger.Info("Purged deleted users", "purged", purged, "older_than", age)
+	c.JSON(http.StatusOK, PurgeResponse{Purged: purged})
+}
+
+// StartAutoPurge purges users soft-deleted longer than age ago every interval
+// until ctx is done
+func (uc *UserController) StartAutoPurge(ctx context.Context, interval, age time.Duration) {
+	uc.autoPurgeAge = age
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			purged, err := uc.purgeDeletedUsers(ctx, time.Now().Add(-age))
+			if err != nil {
+				uc.Logger.Error("Automatic purge of deleted users failed", "error", err)
+				continue
+			}
+			if purged > 0 {
+				uc.invalidateUsersCache()
+			}
+			uc.Logger.Info("Automatically purged deleted users", "purged", purged, "older_than", age)
+		}
+	}()
+}
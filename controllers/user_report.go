@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"go-api/models"
+	"go-api/report"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetUserReport godoc
+// @Summary Export user report
+// @Description Get a PDF report with the user's profile, activity counts, audit log summary and devices
+// @Tags admin
+// @Produce application/pdf
+// @Param id path int true "User ID"
+// @Success 200 {file} file "PDF document"
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/report.pdf [get]
+func (uc *UserController) GetUserReport(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	// Deleted users can still be reported on
+	db := uc.DB.WithContext(c.Request.Context())
+	var user models.User
+	result := db.Unscoped().First(&user, id)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("User not found for report", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	data, err := uc.userReport(db, user)
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Render fully before responding so a failure can still get a 500
+	var pdf bytes.Buffer
+	if err := report.WriteUserPDF(&pdf, data); err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("User report generated", "id", id, "bytes", pdf.Len())
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="user-report-%d.pdf"`, id))
+	c.Data(http.StatusOK, "application/pdf", pdf.Bytes())
+}
+
+// userReport gathers the data of the user's PDF report
+func (uc *UserController) userReport(db *gorm.DB, user models.User) (report.UserReport, error) {
+	data := report.UserReport{User: user, GeneratedAt: time.Now()}
+
+	var activity []actionCount
+	if err := db.Model(&models.UserActivity{}).Where("user_id = ?", user.ID).
+		Select("action, COUNT(*) AS count").Group("action").Order("action").Scan(&activity).Error; err != nil {
+		return data, err
+	}
+	audit, err := auditActionCounts(db, user.ID)
+	if err != nil {
+		return data, err
+	}
+	summary, err := auditSummary(db, user.ID)
+	if err != nil {
+		return data, err
+	}
+	if err := db.Where("user_id = ?", user.ID).Order("last_seen DESC").Find(&data.Devices).Error; err != nil {
+		return data, err
+	}
+
+	data.Activity = reportCounts(activity)
+	data.Audit = reportCounts(audit)
+	data.CreatedAt, data.LastModifiedAt = summary.CreatedAt, summary.LastModifiedAt
+	return data, nil
+}
+
+func reportCounts(counts []actionCount) []report.Count {
+	converted := make([]report.Count, len(counts))
+	for i, count := range counts {
+		converted[i] = report.Count{Label: count.Action, Count: count.Count}
+	}
+	return converted
+}
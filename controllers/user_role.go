@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUsersByRole godoc
+// @Summary Get users by role
+// @Description Get list of users with the given role
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param role path string true "Role" Enums(admin, user, support)
+// @Param page query int false "Page number, starting at 1"
+// @Param per_page query int false "Page size, up to 100"
+// @Param page_size query int false "Page size, an alias of per_page"
+// @Success 200 {array} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/by-role/{role} [get]
+func (uc *UserController) GetUsersByRole(c *gin.Context) {
+	role := c.Param("role")
+	if !slices.Contains(models.ValidRoles, role) {
+		uc.Logger.Warn("Invalid role provided", "role", role)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role, valid roles are: " + strings.Join(models.ValidRoles, ", ")})
+		return
+	}
+
+	pagination, fields := paginate(c)
+	if fields != nil {
+		uc.respondInvalidQuery(c, fields)
+		return
+	}
+
+	var users []models.User
+	result := uc.DB.WithContext(c.Request.Context()).Scopes(pagination).Where("role = ?", role).Find(&users)
+
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	uc.Logger.Debug("Successfully fetched users by role", "role", role, "count", len(users))
+	c.JSON(http.StatusOK, users)
+}
+
+// SetUserRoleRequest is the payload for changing a user's role
+type SetUserRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=admin user support"`
+}
+
+// SetUserRole godoc
+// @Summary Set user role
+// @Description Change the user's role. Roles can't be set when creating or updating users, only here.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.SetUserRoleRequest true "Role"
+// @Success 200 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/users/{id}/role [put]
+func (uc *UserController) SetUserRole(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request SetUserRoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, ok := uc.findUser(c, id)
+	if !ok {
+		return
+	}
+	if err := uc.DB.WithContext(c.Request.Context()).Model(&user).Update("role", request.Role).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.invalidateUsersCache()
+	uc.recordAudit(c, models.AuditActionUpdate, user.ID)
+	uc.Logger.Info("User role changed", "id", user.ID, "role", request.Role)
+	c.JSON(http.StatusOK, user)
+}
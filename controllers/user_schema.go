@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserSchemaResponse describes the User JSON representation so clients can
+// check compatibility
+type UserSchemaResponse struct {
+	Version   string                `json:"version"`
+	Fields    []models.SchemaField  `json:"fields"`
+	Changelog []models.SchemaChange `json:"changelog"`
+}
+
+// GetUserSchemaVersion godoc
+// @Summary Get user schema
+// @Description Get the current version of the user JSON schema, its fields and the changelog of previous versions
+// @Tags schema
+// @Produce json
+// @Success 200 {object} controllers.UserSchemaResponse
+// @Router /schema/user [get]
+func (uc *UserController) GetUserSchemaVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, UserSchemaResponse{
+		Version:   models.UserSchemaVersion,
+		Fields:    models.UserSchemaFields,
+		Changelog: models.UserChangelog,
+	})
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// searchUsersLimit is the maximum number of search results returned
+const searchUsersLimit = 50
+
+// searchUsersQuery matches users_fts and joins back to users of the tenant,
+// if any, best match first
+const searchUsersQuery = `
+SELECT users.*
+FROM (SELECT rowid, rank FROM users_fts WHERE users_fts MATCH ?) AS matches
+JOIN users ON users.id = matches.rowid AND users.deleted_at IS NULL
+WHERE ? IS NULL OR users.tenant_id = ?
+ORDER BY matches.rank, users.id
+LIMIT ?`
+
+// ftsQuery turns free text into an FTS5 query matching any of its words as a
+// prefix, quoting each word so user input can't inject FTS5 syntax
+func ftsQuery(q string) string {
+	words := strings.Fields(q)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"*`
+	}
+	return strings.Join(words, " OR ")
+}
+
+// SearchUsers godoc
+// @Summary Search users
+// @Description Full-text search of user names and emails, ordered by relevance
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param q query string true "Search terms"
+// @Success 200 {array} models.User
+// @Failure 400 {object} map[string]string
+// @Router /users/search [get]
+func (uc *UserController) SearchUsers(c *gin.Context) {
+	q := ftsQuery(c.Query("q"))
+	if q == "" {
+		uc.Logger.Warn("Empty search query provided")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing search query"})
+		return
+	}
+
+	tenantID := requestTenant(c)
+	users := []models.User{}
+	result := uc.DB.WithContext(c.Request.Context()).Raw(searchUsersQuery, q, tenantID, tenantID, searchUsersLimit).Scan(&users)
+
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	uc.Logger.Debug("Successfully searched users", "q", c.Query("q"), "count", len(users))
+	c.JSON(http.StatusOK, users)
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// similarUsersLimit is the maximum number of similar users returned
+const similarUsersLimit = 10
+
+// similarUsersQuery ranks other users by the Jaccard similarity of their tags
+// with the target user's tags: shared / (target + other - shared), only
+// ranking users of the tenant when @tenant is set
+const similarUsersQuery = `
+SELECT shared.user_id AS id,
+       shared.count AS shared_tags,
+       CAST(shared.count AS REAL) / (target.count + other.count - shared.count) AS similarity
+FROM (
+    SELECT candidate.user_id, COUNT(*) AS count
+    FROM user_tags AS own
+    JOIN user_tags AS candidate ON candidate.tag = own.tag AND candidate.user_id <> own.user_id
+    WHERE own.user_id = @id
+    GROUP BY candidate.user_id
+) AS shared
+JOIN (SELECT user_id, COUNT(*) AS count FROM user_tags GROUP BY user_id) AS other ON other.user_id = shared.user_id
+JOIN users ON users.id = shared.user_id AND users.deleted_at IS NULL AND (@tenant IS NULL OR users.tenant_id = @tenant)
+CROSS JOIN (SELECT COUNT(*) AS count FROM user_tags WHERE user_id = @id) AS target
+ORDER BY similarity DESC, shared_tags DESC, shared.user_id
+LIMIT @limit`
+
+// SimilarUser is a user ranked by tag overlap with another user
+type SimilarUser struct {
+	User       models.User `json:"user"`
+	SharedTags int         `json:"shared_tags"`
+	Similarity float64     `json:"similarity"`
+}
+
+// GetSimilarUsers godoc
+// @Summary Get similar users
+// @Description Get up to 10 users ranked by Jaccard similarity of their tags
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} controllers.SimilarUser
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/similar [get]
+func (uc *UserController) GetSimilarUsers(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var user models.User
+	result := db.First(&user, id)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("User not found for similar users", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	var ranking []struct {
+		ID         uint
+		SharedTags int
+		Similarity float64
+	}
+	result = db.Raw(similarUsersQuery, map[string]any{"id": id, "tenant": requestTenant(c), "limit": similarUsersLimit}).Scan(&ranking)
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	similar := make([]SimilarUser, 0, len(ranking))
+	if len(ranking) > 0 {
+		ids := make([]uint, len(ranking))
+		for i, rank := range ranking {
+			ids[i] = rank.ID
+		}
+
+		var users []models.User
+		if err := db.Find(&users, ids).Error; err != nil {
+			uc.RespondError(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		byID := make(map[uint]models.User, len(users))
+		for _, u := range users {
+			byID[u.ID] = u
+		}
+		// Users deleted since the ranking are left out
+		for _, rank := range ranking {
+			if user, ok := byID[rank.ID]; ok {
+				similar = append(similar, SimilarUser{User: user, SharedTags: rank.SharedTags, Similarity: rank.Similarity})
+			}
+		}
+	}
+
+	uc.Logger.Debug("Successfully ranked similar users", "id", id, "count", len(similar))
+	c.JSON(http.StatusOK, similar)
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"errors"
+	"go-api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AddSSHKeyRequest is the payload for adding an SSH public key
+type AddSSHKeyRequest struct {
+	Name      string `json:"name" binding:"required"`
+	PublicKey string `json:"public_key" binding:"required"`
+}
+
+// AddSSHKey godoc
+// @Summary Add SSH key
+// @Description Add an SSH public key in authorized_keys format, its SHA256 fingerprint is computed on save
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.AddSSHKeyRequest true "SSH key"
+// @Success 201 {object} models.UserSSHKey
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /users/{id}/ssh-keys [post]
+func (uc *UserController) AddSSHKey(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request AddSSHKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	key := models.UserSSHKey{UserID: id, Name: request.Name, PublicKey: request.PublicKey}
+	// Validate and fingerprint the key before checking for duplicates
+	if err := key.Normalize(); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var taken int64
+	if err := db.Model(&models.UserSSHKey{}).Where("user_id = ? AND fingerprint = ?", id, key.Fingerprint).Count(&taken).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if taken > 0 {
+		uc.Logger.Info("SSH key already added", "id", id, "fingerprint", key.Fingerprint)
+		c.JSON(http.StatusConflict, gin.H{"error": "SSH key already added"})
+		return
+	}
+
+	if err := db.Create(&key).Error; err != nil {
+		if errors.Is(err, models.ErrInvalidSSHKey) {
+			uc.RespondError(c, http.StatusBadRequest, err)
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Info("SSH key added successfully", "id", id, "key_id", key.ID, "fingerprint", key.Fingerprint)
+	c.JSON(http.StatusCreated, key)
+}
+
+// ListSSHKeys godoc
+// @Summary List SSH keys
+// @Description Get the user's SSH public keys
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {array} models.UserSSHKey
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/ssh-keys [get]
+func (uc *UserController) ListSSHKeys(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	keys := []models.UserSSHKey{}
+	if err := uc.DB.WithContext(c.Request.Context()).Where("user_id = ?", id).Order("id").Find(&keys).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Debug("Successfully fetched SSH keys", "id", id, "count", len(keys))
+	c.JSON(http.StatusOK, keys)
+}
+
+// DeleteSSHKey godoc
+// @Summary Delete SSH key
+// @Description Remove one of the user's SSH public keys
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param key_id path int true "SSH key ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/ssh-keys/{key_id} [delete]
+func (uc *UserController) DeleteSSHKey(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+	keyID, ok := uc.ParseID(c, "key_id")
+	if !ok {
+		return
+	}
+
+	result := uc.DB.WithContext(c.Request.Context()).Where("id = ? AND user_id = ?", keyID, id).Delete(&models.UserSSHKey{})
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		uc.Logger.Info("SSH key not found for deletion", "id", id, "key_id", keyID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "SSH key not found"})
+		return
+	}
+
+	uc.Logger.Info("SSH key deleted successfully", "id", id, "key_id", keyID)
+	c.JSON(http.StatusOK, gin.H{"message": "SSH key deleted successfully"})
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"go-api/models"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// userCounts maps the counts GET /users can include to the subquery computing them
+var userCounts = map[string]string{
+	"sessions_count": "(SELECT COUNT(*) FROM login_events WHERE login_events.user_id = users.id AND login_events.success)",
+	"audit_count":    "(SELECT COUNT(*) FROM audit_logs WHERE audit_logs.entity_type = 'user' AND audit_logs.entity_id = users.id)",
+}
+
+// UserWithStats is a user with the aggregated counts requested through include
+type UserWithStats struct {
+	models.User
+	UserStats
+}
+
+// UserStats are the counts attached to a user, those not requested are nil
+type UserStats struct {
+	// SessionsCount is the number of successful logins
+	SessionsCount *int64 `json:"sessions_count,omitempty"`
+	AuditCount    *int64 `json:"audit_count,omitempty"`
+}
+
+func (u UserWithStats) MarshalJSON() ([]byte, error) {
+	return marshalUserWith(u.User, u.UserStats)
+}
+
+// GetUsersWithStats serves GET /users when include is given, attaching the
+// requested counts to every user with subqueries in the same SELECT. The
+// counts change without writes to users, so these lists aren't cached.
+func (uc *UserController) GetUsersWithStats(c *gin.Context, list UserListQuery, active func(*gorm.DB) *gorm.DB) {
+	include := strings.Split(c.Query("include"), ",")
+	columns := []string{"users.*"}
+	for _, name := range include {
+		subquery, ok := userCounts[name]
+		if !ok {
+			valid := slices.Sorted(maps.Keys(userCounts))
+			uc.respondInvalidQuery(c, map[string]string{"include": "must be one of " + strings.Join(valid, ", ")})
+			return
+		}
+		columns = append(columns, subquery+" AS "+name)
+	}
+
+	users := []UserWithStats{}
+	result := uc.DB.WithContext(c.Request.Context()).Model(&models.User{}).
+		Select(strings.Join(columns, ", ")).
+		Scopes(list.scope(), active).
+		Scan(&users)
+
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	if len(users) > 0 {
+		if next, ok := list.nextAfterID(len(users), users[len(users)-1].ID); ok {
+			c.Header("X-Next-After-ID", next)
+		}
+	}
+
+	uc.Logger.Debug("Successfully fetched users with stats", "count", len(users), "include", include)
+	c.JSON(http.StatusOK, users)
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxStatusIDs is the most users a bulk status request may ask about
+const maxStatusIDs = 500
+
+// User statuses reported by GetBulkUserStatus
+const (
+	UserStatusActive   = "active"
+	UserStatusLocked   = "locked"
+	UserStatusDeleted  = "deleted"
+	UserStatusNotFound = "not_found"
+)
+
+// BulkStatusRequest lists the users to report the status of
+type BulkStatusRequest struct {
+	IDs []uint `json:"ids" binding:"required,min=1,max=500"`
+}
+
+// GetBulkUserStatus godoc
+// @Summary Get user statuses
+// @Description Get whether each of up to 500 users is active, locked or deleted, keyed by user ID, for cheap polling. Unknown IDs are reported as not_found.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body controllers.BulkStatusRequest true "User IDs"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /users/status [post]
+func (uc *UserController) GetBulkUserStatus(c *gin.Context) {
+	var request BulkStatusRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Only the columns deciding the status are read, deleted users included
+	var users []models.User
+	result := uc.DB.WithContext(c.Request.Context()).Unscoped().
+		Select("id, deleted_at, locked_until").Where("id IN ?", request.IDs).Find(&users)
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	statuses := make(map[string]string, len(request.IDs))
+	for _, id := range request.IDs {
+		statuses[strconv.FormatUint(uint64(id), 10)] = UserStatusNotFound
+	}
+	now := time.Now()
+	for _, user := range users {
+		status := UserStatusActive
+		switch {
+		case user.DeletedAt.Valid:
+			status = UserStatusDeleted
+		case user.LockedUntil != nil && user.LockedUntil.After(now):
+			status = UserStatusLocked
+		}
+		statuses[strconv.FormatUint(uint64(user.ID), 10)] = status
+	}
+
+	uc.Logger.Debug("Successfully fetched user statuses", "count", len(statuses))
+	c.JSON(http.StatusOK, statuses)
+}
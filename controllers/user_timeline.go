@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"errors"
+	"go-api/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Timeline event types
+const (
+	TimelineEventAudit   = "audit"
+	TimelineEventLogin   = "login"
+	TimelineEventConsent = "consent"
+)
+
+// timelineQuery lists the type and ID of every event of a user, newest
+// first. The rows are loaded afterwards, so the union only needs the columns
+// all three tables share.
+const timelineQuery = `
+SELECT type, id FROM (
+	SELECT 'audit' AS type, id, created_at AS timestamp FROM audit_logs
+		WHERE (actor_id = @user OR (entity_type = 'user' AND entity_id = @user)) AND (@before IS NULL OR created_at < @before)
+	UNION ALL
+	SELECT 'login', id, timestamp FROM login_events WHERE user_id = @user AND (@before IS NULL OR timestamp < @before)
+	UNION ALL
+	SELECT 'consent', id, timestamp FROM consent_records WHERE user_id = @user AND (@before IS NULL OR timestamp < @before)
+)
+ORDER BY timestamp DESC, type, id DESC
+LIMIT @limit`
+
+// TimelineEvent is one entry of a user timeline. Payload is the audit log
+// entry, login event or consent record, depending on Type.
+type TimelineEvent struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Payload   any       `json:"payload"`
+}
+
+// timelineRef identifies an event found by timelineQuery
+type timelineRef struct {
+	Type string
+	ID   uint
+}
+
+// GetUserTimeline godoc
+// @Summary Get user timeline
+// @Description Get the audit log entries, login attempts and consent decisions of a user as one feed, newest first. Pass the timestamp of the last event as before to get the next page.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param before query string false "Only events before this RFC3339 time"
+// @Param limit query int false "Number of events, up to 100" default(20)
+// @Success 200 {array} controllers.TimelineEvent
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/timeline [get]
+func (uc *UserController) GetUserTimeline(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	limit := defaultPageSize
+	if limitParam := c.Query("limit"); limitParam != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitParam); err != nil || limit < 1 || limit > maxPageSize {
+			uc.RespondError(c, http.StatusBadRequest, errors.New("limit must be between 1 and "+strconv.Itoa(maxPageSize)))
+			return
+		}
+	}
+
+	var before *time.Time
+	if beforeParam := c.Query("before"); beforeParam != "" {
+		t, err := time.Parse(time.RFC3339Nano, beforeParam)
+		if err != nil {
+			uc.Logger.Warn("Invalid before parameter provided", "before", beforeParam)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before parameter, expected RFC3339 timestamp"})
+			return
+		}
+		// Timestamps are stored in local time, compare in the same zone
+		t = t.Local()
+		before = &t
+	}
+
+	if _, ok := uc.findUser(c, id); !ok {
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var refs []timelineRef
+	err := db.Raw(timelineQuery, map[string]any{"user": id, "before": before, "limit": limit}).Scan(&refs).Error
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	events, err := loadTimelineEvents(db, refs)
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.Logger.Debug("Successfully fetched user timeline", "id", id, "count", len(events))
+	c.JSON(http.StatusOK, events)
+}
+
+// loadTimelineEvents loads the rows behind refs, keeping their order
+func loadTimelineEvents(db *gorm.DB, refs []timelineRef) ([]TimelineEvent, error) {
+	ids := make(map[string][]uint)
+	for _, ref := range refs {
+		ids[ref.Type] = append(ids[ref.Type], ref.ID)
+	}
+
+	loaded := make(map[timelineRef]TimelineEvent, len(refs))
+	if len(ids[TimelineEventAudit]) > 0 {
+		var entries []models.AuditLog
+		if err := db.Find(&entries, ids[TimelineEventAudit]).Error; err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			loaded[timelineRef{TimelineEventAudit, entry.ID}] = TimelineEvent{Type: TimelineEventAudit, Timestamp: entry.CreatedAt, Payload: entry}
+		}
+	}
+	if len(ids[TimelineEventLogin]) > 0 {
+		var logins []models.LoginEvent
+		if err := db.Find(&logins, ids[TimelineEventLogin]).Error; err != nil {
+			return nil, err
+		}
+		for _, login := range logins {
+			loaded[timelineRef{TimelineEventLogin, login.ID}] = TimelineEvent{Type: TimelineEventLogin, Timestamp: login.Timestamp, Payload: login}
+		}
+	}
+	if len(ids[TimelineEventConsent]) > 0 {
+		var records []models.ConsentRecord
+		if err := db.Find(&records, ids[TimelineEventConsent]).Error; err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			loaded[timelineRef{TimelineEventConsent, record.ID}] = TimelineEvent{Type: TimelineEventConsent, Timestamp: record.Timestamp, Payload: record}
+		}
+	}
+
+	events := make([]TimelineEvent, 0, len(refs))
+	for _, ref := range refs {
+		if event, ok := loaded[ref]; ok {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
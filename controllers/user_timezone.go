@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"errors"
+	"go-api/geoip"
+	"go-api/models"
+	"net/http"
+	_ "time/tzdata" // timezone validation must not depend on the host's zoneinfo
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Where a returned timezone comes from
+const (
+	TimezoneSourceUser    = "user_preference"
+	TimezoneSourceGeoIP   = "geoip"
+	TimezoneSourceDefault = "default"
+)
+
+// defaultTimezone is returned when neither a preference nor a country is known
+const defaultTimezone = "UTC"
+
+// TimezoneResponse is a user's timezone and where it was determined from
+type TimezoneResponse struct {
+	Timezone string `json:"timezone"`
+	Source   string `json:"source"`
+}
+
+// SetTimezoneRequest is the payload for setting a timezone preference, empty clears it
+type SetTimezoneRequest struct {
+	Timezone string `json:"timezone" binding:"omitempty,timezone"`
+}
+
+// GetUserTimezone godoc
+// @Summary Get user timezone
+// @Description Get the user's timezone preference, or infer it from the registration IP country
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} controllers.TimezoneResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/timezone [get]
+func (uc *UserController) GetUserTimezone(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var user models.User
+	result := db.First(&user, id)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("User not found for timezone", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	if user.Timezone != "" {
+		c.JSON(http.StatusOK, TimezoneResponse{Timezone: user.Timezone, Source: TimezoneSourceUser})
+		return
+	}
+
+	var activity models.UserActivity
+	err := db.Where("user_id = ? AND ip_country <> ''", user.ID).Order("created_at DESC, id DESC").First(&activity).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	if tz, ok := geoip.CountryTimezone(activity.IPCountry); ok {
+		c.JSON(http.StatusOK, TimezoneResponse{Timezone: tz, Source: TimezoneSourceGeoIP})
+		return
+	}
+
+	uc.Logger.Debug("No timezone known for user, using default", "id", id, "country", activity.IPCountry)
+	c.JSON(http.StatusOK, TimezoneResponse{Timezone: defaultTimezone, Source: TimezoneSourceDefault})
+}
+
+// SetUserTimezone godoc
+// @Summary Set user timezone
+// @Description Set the user's IANA timezone preference, an empty timezone falls back to GeoIP
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.SetTimezoneRequest true "Timezone"
+// @Success 200 {object} controllers.TimezoneResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /users/{id}/timezone [put]
+func (uc *UserController) SetUserTimezone(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request SetTimezoneRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	db := uc.DB.WithContext(c.Request.Context())
+	var user models.User
+	result := db.First(&user, id)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("User not found for timezone update", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	if err := db.Model(&user).Update("timezone", request.Timezone).Error; err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	uc.invalidateUsersCache()
+	uc.recordAudit(c, models.AuditActionUpdate, user.ID)
+	uc.Logger.Info("User timezone updated", "id", user.ID, "timezone", request.Timezone)
+
+	if request.Timezone == "" {
+		uc.GetUserTimezone(c)
+		return
+	}
+	c.JSON(http.StatusOK, TimezoneResponse{Timezone: request.Timezone, Source: TimezoneSourceUser})
+}
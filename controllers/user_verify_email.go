@@ -0,0 +1,320 @@
+package controllers
+
+import (
+	"go-api/models"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+// emailVerificationTTL is how long an emailed verification code stays valid
+const emailVerificationTTL = 10 * time.Minute
+
+// emailVerificationCode configures the 6-digit codes, one TOTP period per TTL
+var emailVerificationCode = totp.ValidateOpts{
+	Period:    uint(emailVerificationTTL / time.Second),
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// maxVerificationFailures is how many wrong codes clear the pending code
+const maxVerificationFailures = 5
+
+const (
+	// maxVerificationResends is how many codes can be sent per window, by
+	// requesting or resending them
+	maxVerificationResends = 3
+	// verificationResendWindow starts over once no code was sent for this long
+	verificationResendWindow = time.Hour
+)
+
+// VerifyEmailRequest is the payload for verifying an email address
+type VerifyEmailRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+// findUser loads a user by ID, responding 404 or 500 when it can't
+func (uc *UserController) findUser(c *gin.Context, id uint) (models.User, bool) {
+	var user models.User
+	result := uc.DB.WithContext(c.Request.Context()).First(&user, id)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("User not found", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return user, false
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return user, false
+	}
+	return user, true
+}
+
+// sendVerificationCode emails the user a new verification code, replacing any
+// pending one, and reports whether it was sent
+func (uc *UserController) sendVerificationCode(c *gin.Context, user models.User) bool {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      "go-api",
+		AccountName: user.Email,
+		Period:      emailVerificationCode.Period,
+		Digits:      emailVerificationCode.Digits,
+		Algorithm:   emailVerificationCode.Algorithm,
+	})
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return false
+	}
+	secret := key.Secret()
+
+	// The code is bound to the issue time rather than the current TOTP
+	// window, so it stays valid for the full TTL
+	issuedAt := time.Now()
+	code, err := totp.GenerateCodeCustom(secret, issuedAt, emailVerificationCode)
+	if err != nil {
+		uc.RespondError(c, http.StatusInternalServerError, err)
+		return false
+	}
+
+	expiresAt := issuedAt.Add(emailVerificationTTL)
+	result := uc.DB.WithContext(c.Request.Context()).Model(&user).Updates(map[string]any{
+		"email_verification_secret":     secret,
+		"email_verification_expires_at": expiresAt,
+		"verification_failures":         0,
+	})
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return false
+	}
+
+	if err := uc.Mailer.SendEmailVerification(user, code); err != nil {
+		uc.Logger.Error("Failed to send email verification code", "error", err, "id", user.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+		return false
+	}
+	return true
+}
+
+// limitVerificationSends counts a code sent to user, through either the
+// request or the resend endpoint, and reports whether it is within the limit.
+// Over it, it responds 429 with Retry-After.
+func (uc *UserController) limitVerificationSends(c *gin.Context, user models.User) bool {
+	// Counting in the UPDATE keeps concurrent sends from all passing the check
+	now := time.Now()
+	windowStart := now.Add(-verificationResendWindow)
+	result := uc.DB.WithContext(c.Request.Context()).Model(&user).
+		Where("last_attempt_at IS NULL OR last_attempt_at <= ? OR verification_attempts < ?", windowStart, maxVerificationResends).
+		UpdateColumns(map[string]any{
+			"verification_attempts": gorm.Expr("CASE WHEN last_attempt_at IS NULL OR last_attempt_at <= ? THEN 1 ELSE verification_attempts + 1 END", windowStart),
+			"last_attempt_at":       now,
+		})
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		// user was read before the UPDATE, concurrent sends may have filled the window since
+		retryAfter := verificationResendWindow
+		var lastAttemptAt *time.Time
+		err := uc.DB.WithContext(c.Request.Context()).Model(&models.User{}).Where("id = ?", user.ID).Select("last_attempt_at").Scan(&lastAttemptAt).Error
+		if err == nil && lastAttemptAt != nil {
+			retryAfter = lastAttemptAt.Add(verificationResendWindow).Sub(now)
+		}
+		uc.Logger.Warn("Verification emails rate limited", "id", user.ID, "retry_after", retryAfter)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many verification emails, try again later"})
+		return false
+	}
+	return true
+}
+
+// RequestEmailVerification godoc
+// @Summary Request email verification
+// @Description Email a 6-digit code, valid for 10 minutes, replacing any pending one. Shares the limit of 3 codes per hour with resend-verification.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Header 429 {integer} Retry-After "Seconds until a code can be sent"
+// @Router /users/{id}/request-email-verification [post]
+func (uc *UserController) RequestEmailVerification(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	user, ok := uc.findUser(c, id)
+	if !ok {
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		uc.Logger.Info("Email already verified", "id", id)
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already verified"})
+		return
+	}
+
+	if !uc.limitVerificationSends(c, user) {
+		return
+	}
+	if !uc.sendVerificationCode(c, user) {
+		return
+	}
+
+	uc.Logger.Info("Email verification requested", "id", id)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification code sent"})
+}
+
+// ResendVerificationEmail godoc
+// @Summary Resend verification email
+// @Description Email a new 6-digit code, replacing any pending one. Up to 3 codes can be resent until an hour passes without a resend, further requests get 429 with Retry-After.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Header 429 {integer} Retry-After "Seconds until a code can be resent"
+// @Router /users/{id}/resend-verification [post]
+func (uc *UserController) ResendVerificationEmail(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	user, ok := uc.findUser(c, id)
+	if !ok {
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		uc.Logger.Info("Email already verified", "id", id)
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already verified"})
+		return
+	}
+
+	if !uc.limitVerificationSends(c, user) {
+		return
+	}
+
+	if !uc.sendVerificationCode(c, user) {
+		return
+	}
+
+	uc.Logger.Info("Email verification resent", "id", id)
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification code sent"})
+}
+
+// VerifyEmail godoc
+// @Summary Verify email
+// @Description Mark the user's email as verified using the emailed code, each code works once. After 5 wrong codes the pending code is cleared and a new one must be requested.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body controllers.VerifyEmailRequest true "Verification code"
+// @Success 200 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Router /users/{id}/verify-email [post]
+func (uc *UserController) VerifyEmail(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var request VerifyEmailRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		uc.RespondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, ok := uc.findUser(c, id)
+	if !ok {
+		return
+	}
+	if user.EmailVerificationSecret == nil || user.EmailVerificationExpiresAt == nil {
+		uc.Logger.Info("No pending email verification", "id", id)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verification code"})
+		return
+	}
+	if time.Now().After(*user.EmailVerificationExpiresAt) {
+		uc.Logger.Info("Expired email verification code", "id", id)
+		c.JSON(http.StatusGone, gin.H{"error": "Verification code expired"})
+		return
+	}
+
+	secret := *user.EmailVerificationSecret
+	issuedAt := user.EmailVerificationExpiresAt.Add(-emailVerificationTTL)
+	valid, err := totp.ValidateCustom(request.Code, secret, issuedAt, emailVerificationCode)
+	if err != nil || !valid {
+		uc.rejectVerificationCode(c, user, secret)
+		return
+	}
+
+	// Claiming the secret in the UPDATE makes the code single use, even for
+	// concurrent requests
+	now := time.Now()
+	result := uc.DB.WithContext(c.Request.Context()).Model(&user).
+		Where("email_verification_secret = ?", secret).
+		Updates(map[string]any{
+			"email_verified_at":             now,
+			"email_verification_secret":     nil,
+			"email_verification_expires_at": nil,
+			"verification_failures":         0,
+			"verification_attempts":         0,
+			"last_attempt_at":               nil,
+		})
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		uc.Logger.Info("Email verification code already used", "id", id)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verification code"})
+		return
+	}
+
+	uc.invalidateUsersCache()
+	uc.recordAudit(c, models.AuditActionUpdate, user.ID)
+	uc.Logger.Info("Email verified successfully", "user", user)
+	c.JSON(http.StatusOK, user)
+}
+
+// rejectVerificationCode counts a wrong code for the pending secret, clearing
+// it once maxVerificationFailures wrong codes were entered
+func (uc *UserController) rejectVerificationCode(c *gin.Context, user models.User, secret string) {
+	// Counting in the UPDATE keeps concurrent guesses from exceeding the limit
+	result := uc.DB.WithContext(c.Request.Context()).Model(&user).
+		Where("email_verification_secret = ?", secret).
+		UpdateColumns(map[string]any{
+			"verification_failures":         gorm.Expr("verification_failures + 1"),
+			"email_verification_secret":     gorm.Expr("CASE WHEN verification_failures + 1 >= ? THEN NULL ELSE email_verification_secret END", maxVerificationFailures),
+			"email_verification_expires_at": gorm.Expr("CASE WHEN verification_failures + 1 >= ? THEN NULL ELSE email_verification_expires_at END", maxVerificationFailures),
+		})
+	if result.Error != nil {
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	if user.VerificationFailures+1 >= maxVerificationFailures {
+		uc.Logger.Warn("Too many invalid email verification codes", "id", user.ID)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many invalid codes, request a new verification code"})
+		return
+	}
+	uc.Logger.Info("Invalid email verification code", "id", user.ID)
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid verification code"})
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"go-api/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// WebhookTestResponse reports the outcome of a test delivery
+type WebhookTestResponse struct {
+	Delivered  bool   `json:"delivered"`
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// SendTestWebhook godoc
+// @Summary Send a test webhook
+// @Description Deliver a signed synthetic test event to the webhook URL, retrying like real deliveries
+// @Tags admin
+// @Produce json
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} controllers.WebhookTestResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/webhooks/{id}/test [post]
+func (uc *UserController) SendTestWebhook(c *gin.Context) {
+	id, ok := uc.ParseID(c, "id")
+	if !ok {
+		return
+	}
+
+	var hook models.Webhook
+	result := uc.DB.WithContext(c.Request.Context()).First(&hook, id)
+
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			uc.Logger.Info("Webhook not found", "id", id)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+		uc.RespondError(c, http.StatusInternalServerError, result.Error)
+		return
+	}
+
+	payload := gin.H{"event": "test", "timestamp": time.Now().UTC().Format(time.RFC3339)}
+	status, err := uc.Webhooks.Deliver(c.Request.Context(), hook, payload)
+
+	response := WebhookTestResponse{Delivered: err == nil, HTTPStatus: status}
+	if err != nil {
+		response.Error = err.Error()
+		uc.Logger.Warn("Test webhook delivery failed", "id", id, "url", hook.URL, "http_status", status, "error", err)
+	} else {
+		uc.Logger.Info("Test webhook delivered", "id", id, "url", hook.URL, "http_status", status)
+	}
+	c.JSON(http.StatusOK, response)
+}
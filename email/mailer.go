@@ -0,0 +1,116 @@
+package email
+
+import (
+	"cmp"
+	"fmt"
+	"go-api/models"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strconv"
+)
+
+// Mailer sends notification emails to users
+type Mailer interface {
+	SendWelcome(user models.User) error
+	SendEmailChange(user models.User, token string) error
+	SendEmailVerification(user models.User, code string) error
+	SendNewDevice(user models.User, device models.UserDevice) error
+	SendPasswordExpiry(user models.User) error
+}
+
+// LogMailer logs emails instead of sending them, for development and testing.
+// Addresses are masked and tokens and codes are left out.
+type LogMailer struct {
+	Logger *slog.Logger
+}
+
+func NewLogMailer(logger *slog.Logger) *LogMailer {
+	return &LogMailer{Logger: logger}
+}
+
+func (m *LogMailer) SendWelcome(user models.User) error {
+	m.Logger.Info("Sending welcome email", "to", models.MaskEmail(user.Email), "name", user.Name)
+	return nil
+}
+
+func (m *LogMailer) SendEmailChange(user models.User, token string) error {
+	m.Logger.Info("Sending email change confirmation", "to", models.MaskEmail(*user.PendingEmail), "name", user.Name)
+	return nil
+}
+
+func (m *LogMailer) SendEmailVerification(user models.User, code string) error {
+	m.Logger.Info("Sending email verification code", "to", models.MaskEmail(user.Email), "name", user.Name)
+	return nil
+}
+
+func (m *LogMailer) SendNewDevice(user models.User, device models.UserDevice) error {
+	m.Logger.Info("Sending new device notification", "to", models.MaskEmail(user.Email), "name", user.Name, "device_id", device.DeviceID, "device_name", device.DeviceName)
+	return nil
+}
+
+func (m *LogMailer) SendPasswordExpiry(user models.User) error {
+	m.Logger.Info("Sending password expiry reminder", "to", models.MaskEmail(user.Email), "name", user.Name, "expires_at", user.PasswordExpiresAt)
+	return nil
+}
+
+// SMTPMailer sends emails through an SMTP server using net/smtp
+type SMTPMailer struct {
+	Host string
+	Port int
+	From string
+}
+
+func NewSMTPMailer(host string, port int, from string) *SMTPMailer {
+	return &SMTPMailer{
+		Host: host,
+		Port: port,
+		From: from,
+	}
+}
+
+func (m *SMTPMailer) SendWelcome(user models.User) error {
+	body := fmt.Sprintf("Hi %s, welcome aboard!", user.DisplayName())
+	if err := m.send(user.Email, "Welcome!", body); err != nil {
+		return fmt.Errorf("send welcome email to %s: %w", user.Email, err)
+	}
+	return nil
+}
+
+func (m *SMTPMailer) SendEmailChange(user models.User, token string) error {
+	body := fmt.Sprintf("Hi %s, use this token to confirm your new email address: %s", user.DisplayName(), token)
+	if err := m.send(*user.PendingEmail, "Confirm your new email address", body); err != nil {
+		return fmt.Errorf("send email change confirmation to %s: %w", *user.PendingEmail, err)
+	}
+	return nil
+}
+
+func (m *SMTPMailer) SendEmailVerification(user models.User, code string) error {
+	body := fmt.Sprintf("Hi %s, your email verification code is %s", user.DisplayName(), code)
+	if err := m.send(user.Email, "Verify your email address", body); err != nil {
+		return fmt.Errorf("send email verification code to %s: %w", user.Email, err)
+	}
+	return nil
+}
+
+func (m *SMTPMailer) SendNewDevice(user models.User, device models.UserDevice) error {
+	body := fmt.Sprintf("Hi %s, your account was just used from a new device: %s. If this wasn't you, remove the device and change your credentials.", user.DisplayName(), cmp.Or(device.DeviceName, device.DeviceID))
+	if err := m.send(user.Email, "New device signed in", body); err != nil {
+		return fmt.Errorf("send new device notification to %s: %w", user.Email, err)
+	}
+	return nil
+}
+
+func (m *SMTPMailer) SendPasswordExpiry(user models.User) error {
+	body := fmt.Sprintf("Hi %s, your password expires on %s. Please choose a new one before then.", user.DisplayName(), user.PasswordExpiresAt.Format("January 2, 2006"))
+	if err := m.send(user.Email, "Your password expires soon", body); err != nil {
+		return fmt.Errorf("send password expiry reminder to %s: %w", user.Email, err)
+	}
+	return nil
+}
+
+func (m *SMTPMailer) send(to, subject, body string) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", m.From, to, subject, body)
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	return smtp.SendMail(addr, nil, m.From, []string{to}, []byte(msg))
+}
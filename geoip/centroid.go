@@ -0,0 +1,46 @@
+package geoip
+
+import (
+	_ "embed"
+	"encoding/json"
+	"math"
+)
+
+// centroidsJSON maps ISO country codes to the approximate geographic center
+// of the country
+//
+//go:embed centroids.json
+var centroidsJSON []byte
+
+// Point is a position in decimal degrees
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+var countryCentroids = func() map[string]Point {
+	var m map[string]Point
+	if err := json.Unmarshal(centroidsJSON, &m); err != nil {
+		panic("geoip: invalid centroids.json: " + err.Error())
+	}
+	return m
+}()
+
+// earthRadiusKm is the mean radius of the Earth
+const earthRadiusKm = 6371.0
+
+// CountryCentroid returns the approximate center of the country with an ISO country code
+func CountryCentroid(country string) (Point, bool) {
+	p, ok := countryCentroids[country]
+	return p, ok
+}
+
+// DistanceKm returns the great-circle distance between a and b using the Haversine formula
+func DistanceKm(a, b Point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
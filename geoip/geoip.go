@@ -0,0 +1,42 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Resolver looks up the ISO country code of an IP address
+type Resolver interface {
+	Country(ip net.IP) (string, error)
+}
+
+// MaxMindResolver resolves countries from a local MaxMind GeoLite2 database
+type MaxMindResolver struct {
+	reader *maxminddb.Reader
+}
+
+func NewMaxMindResolver(path string) (*MaxMindResolver, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open geoip database %s: %w", path, err)
+	}
+	return &MaxMindResolver{reader: reader}, nil
+}
+
+func (r *MaxMindResolver) Country(ip net.IP) (string, error) {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := r.reader.Lookup(ip, &record); err != nil {
+		return "", fmt.Errorf("lookup %s: %w", ip, err)
+	}
+	return record.Country.ISOCode, nil
+}
+
+func (r *MaxMindResolver) Close() error {
+	return r.reader.Close()
+}
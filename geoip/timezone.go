@@ -0,0 +1,26 @@
+package geoip
+
+import (
+	_ "embed"
+	"encoding/json"
+)
+
+// timezonesJSON maps ISO country codes to the IANA timezone of their most
+// populous region, countries spanning several zones get a single best guess
+//
+//go:embed timezones.json
+var timezonesJSON []byte
+
+var countryTimezones = func() map[string]string {
+	var m map[string]string
+	if err := json.Unmarshal(timezonesJSON, &m); err != nil {
+		panic("geoip: invalid timezones.json: " + err.Error())
+	}
+	return m
+}()
+
+// CountryTimezone returns the IANA timezone inferred from an ISO country code
+func CountryTimezone(country string) (string, bool) {
+	tz, ok := countryTimezones[country]
+	return tz, ok
+}
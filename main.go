@@ -1,33 +1,90 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"go-api/auth"
 	"go-api/config"
 	"go-api/controllers"
 	"go-api/docs"
+	"go-api/email"
+	"go-api/geoip"
+	"go-api/middleware"
 	"go-api/models"
 	"go-api/routes"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/gin-gonic/gin"
-	sloggin "github.com/samber/slog-gin"
-	swaggerFiles "github.com/swaggo/files"
-	ginSwagger "github.com/swaggo/gin-swagger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
 )
 
 type CLI struct {
-	Port      int              `kong:"default='8080',help='Server port'"`
-	Host      string           `kong:"default='localhost',help='Server host'"`
-	DbPath    string           `kong:"default='app.db',help='SQLite database path'"`
-	Debug     bool             `kong:"help='Enable debug mode'"`
-	LogLevel  string           `kong:"default='info',enum='debug,info,warn,error',help='Log level (debug, info, warn, error)'"`
-	LogFormat string           `kong:"default='text',enum='text,json',help='Log format (text, json)'"`
-	Version   kong.VersionFlag `kong:"short='v',help='Show version'"`
+	Port                    int              `kong:"default='8080',help='Server port'"`
+	Host                    string           `kong:"default='localhost',help='Server host'"`
+	DbPath                  string           `kong:"default='app.db',help='SQLite database path or file: URI with connection pragmas'"`
+	DbRetries               int              `kong:"default='3',help='Maximum attempts for database operations failing with transient errors'"`
+	DbBackoff               time.Duration    `kong:"default='50ms',help='Base backoff between database retries'"`
+	DbVacuum                bool             `kong:"help='Enable incremental auto vacuum and reclaim free pages on startup'"`
+	DbCheckpointOnClose     bool             `kong:"help='Write the WAL back to the database file and truncate it on shutdown'"`
+	DbStartupTimeout        time.Duration    `kong:"default='30s',help='How long to keep retrying to open the database at startup'"`
+	DbRetryAttempts         int              `kong:"help='Attempts to open the database at startup before giving up (0 retries until --db-startup-timeout)'"`
+	DbRetryDelay            time.Duration    `kong:"default='1s',help='Wait between attempts to open the database at startup'"`
+	DbMaxQueryDepth         int              `kong:"help='Reject raw SQL nesting SELECTs deeper than this or using WITH RECURSIVE (0 disables)'"`
+	DbEncryptionKey         string           `kong:"env='DB_ENCRYPTION_KEY',help='Hex encoded 256-bit SQLCipher key of the database, needs a build with CGO_ENABLED=1 and -tags sqlcipher (unencrypted when empty)'"`
+	DbDefaultIsolation      string           `kong:"default='default',enum='default,read-uncommitted,read-committed,repeatable-read,serializable',help='Isolation level of transactions that do not set one (default keeps the driver default, SQLite is always serializable)'"`
+	Debug                   bool             `kong:"help='Enable debug mode'"`
+	LogLevel                string           `kong:"default='info',enum='debug,info,warn,error',help='Log level (debug, info, warn, error)'"`
+	LogFormat               string           `kong:"default='text',enum='text,json',help='Log format (text, json)'"`
+	LogCaller               bool             `kong:"help='Include source file and line in log records'"`
+	JsonCase                string           `kong:"default='snake',enum='snake,camel',help='JSON key case for request and response bodies (snake, camel)'"`
+	GeoipDb                 string           `kong:"help='MaxMind GeoLite2 country database path, IP geolocation is disabled when empty'"`
+	SmtpHost                string           `kong:"help='SMTP server host, emails are only logged when empty'"`
+	SmtpPort                int              `kong:"default='25',help='SMTP server port'"`
+	SmtpFrom                string           `kong:"default='noreply@localhost',help='Sender address for outgoing emails'"`
+	ResponseTimeout         time.Duration    `kong:"default='30s',help='Maximum time for handlers to start a response, not counting the request body upload (0 disables)'"`
+	RateLimitRps            float64          `kong:"help='Requests per second allowed per client IP after the initial burst (0 disables)'"`
+	RateLimitBurst          int              `kong:"default='20',help='Requests a client IP can make at once before --rate-limit-rps applies'"`
+	RateLimitStore          string           `kong:"default='memory',enum='memory,sqlite',help='Where rate limit state is kept (memory, sqlite), sqlite survives restarts'"`
+	MaxConcurrentRequests   int              `kong:"help='Requests handled at once, others queue with X-Priority: high requests of admins and API keys first (0 disables)'"`
+	HighPriorityReserved    int              `kong:"default='1',help='Worker slots of --max-concurrent-requests only X-Priority: high requests may use'"`
+	RequestQueueSize        int              `kong:"default='100',help='Requests of each priority waiting for --max-concurrent-requests before new ones are rejected'"`
+	CircuitBreakerThreshold int              `kong:"help='Consecutive 5xx responses after which requests get a 503 without reaching handlers (0 disables)'"`
+	CircuitBreakerTimeout   time.Duration    `kong:"default='30s',help='How long the circuit breaker stays open before letting a request through to probe'"`
+	SlowRequestMs           int              `kong:"default='500',help='Log a warning for requests taking longer than this many milliseconds (0 disables)'"`
+	AutoPurgeInterval       time.Duration    `kong:"help='How often to permanently delete users soft-deleted longer ago than --auto-purge-older-than (0 disables)'"`
+	AutoPurgeOlderThan      string           `kong:"default='30d',help='Minimum time since deletion before automatic purging, e.g. 30d or 12h'"`
+	PasswordMaxAgeDays      int              `kong:"default='90',help='Days a password stays valid, users are emailed a week before it expires (0 disables expiry)'"`
+	AllowedEmailDomains     []string         `kong:"help='Comma-separated email domains users may register with (any domain when empty)'"`
+	JwtSecret               string           `kong:"env='JWT_SECRET',help='Secret for signing and verifying JWTs, bearer tokens and admin impersonation are disabled when empty'"`
+	DeprecationDate         time.Time        `kong:"help='Announce /api/v1 as deprecated with this RFC 3339 sunset date, e.g. 2027-01-01T00:00:00Z'"`
+	SuccessorUrl            string           `kong:"help='URL of the API version replacing /api/v1, sent with deprecation notices'"`
+	Version                 kong.VersionFlag `kong:"short='v',help='Show version'"`
+
+	Serve  struct{} `kong:"cmd,default='1',help='Start the API server (default)'"`
+	Vacuum struct{} `kong:"cmd,help='Rebuild the database file to reclaim free space'"`
+
+	MigrateDown struct {
+		Steps int `kong:"default='1',help='Number of migrations to roll back'"`
+	} `kong:"cmd,help='Roll back the newest versioned migrations'"`
 }
 
-// Build-time variables for version info
+// debugBodyLogBytes is how much of each response body is logged in debug mode
+const debugBodyLogBytes = 4 << 10
+
+// shutdownTimeout is how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// Build-time variables for version info, set with -ldflags "-X main.version=...".
+// date is the RFC3339 build time and commit the git SHA.
 var (
 	version = "dev"
 	commit  = "none"
@@ -49,6 +106,16 @@ var (
 
 // @host localhost:8080
 // @BasePath /api/v1
+
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @description An API key created with POST /users/{id}/api-keys.
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Type "Bearer" followed by a space and the JWT token, accepted when the server runs with --jwt-secret.
 func main() {
 	var cli CLI
 	ctx := kong.Parse(&cli,
@@ -60,7 +127,7 @@ func main() {
 	)
 
 	// Setup structured logging
-	logger := setupLogger(cli.LogLevel, cli.LogFormat)
+	logger := config.SetupLogger(os.Stdout, cli.LogLevel, cli.LogFormat, cli.LogCaller)
 	slog.SetDefault(logger)
 
 	// Set Gin mode based on debug flag
@@ -71,78 +138,237 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Initialize database with custom path
-	database := config.InitDB(cli.DbPath, logger)
+	isolation, _ := config.ParseIsolationLevel(cli.DbDefaultIsolation) // kong already validated the name
+	dbConfig := config.DBConfig{
+		Path:              cli.DbPath,
+		AutoVacuum:        cli.DbVacuum,
+		CheckpointOnClose: cli.DbCheckpointOnClose,
+		MaxQueryDepth:     cli.DbMaxQueryDepth,
+		DefaultIsolation:  isolation,
+		EncryptionKey:     cli.DbEncryptionKey,
+		RetryAttempts:     cli.DbRetryAttempts,
+		RetryDelay:        cli.DbRetryDelay,
+		Metrics:           prometheus.DefaultRegisterer,
+	}
+
+	// Stop background work and the server on SIGINT or SIGTERM
+	shutdownCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if ctx.Command() == "vacuum" {
+		database := openDatabase(ctx, shutdownCtx, dbConfig, logger, cli.DbStartupTimeout)
+		before, after, err := config.Vacuum(database, cli.DbPath)
+		if err != nil {
+			slog.Error("Failed to vacuum database", "error", err, "db_path", cli.DbPath)
+			ctx.FatalIfErrorf(err, "Failed to vacuum database")
+		}
+		slog.Info("Database vacuumed", "db_path", cli.DbPath, "size_before", before, "size_after", after)
+		return
+	}
+
+	if ctx.Command() == "migrate-down" {
+		database := openDatabase(ctx, shutdownCtx, dbConfig, logger, cli.DbStartupTimeout)
+		if err := config.MigrateDown(database, cli.MigrateDown.Steps); err != nil {
+			slog.Error("Failed to roll back migrations", "error", err, "steps", cli.MigrateDown.Steps)
+			ctx.FatalIfErrorf(err, "Failed to roll back migrations")
+		}
+		version, err := config.SchemaVersion(database)
+		if err != nil {
+			ctx.FatalIfErrorf(err, "Failed to read schema version")
+		}
+		slog.Info("Migrations rolled back", "steps", cli.MigrateDown.Steps, "schema_version", version)
+		return
+	}
+
+	// Accept connections while the database comes up, /healthz answers 503 until it is ready
+	serverAddr := fmt.Sprintf("%s:%d", cli.Host, cli.Port)
+	slog.Info("Starting server",
+		"address", serverAddr,
+		"debug", cli.Debug,
+		"log_level", cli.LogLevel,
+		"log_format", cli.LogFormat,
+		"log_caller", cli.LogCaller,
+		"db_path", cli.DbPath,
+	)
+
+	health := routes.NewHealth()
+	handler := routes.NewSwitchHandler(routes.NewStartupRouter(health))
+	server := &http.Server{Addr: serverAddr, Handler: handler}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+	go func() {
+		<-shutdownCtx.Done()
+		slog.Info("Shutting down server")
+		timeout, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(timeout); err != nil {
+			slog.Error("Failed to shut down server gracefully", "error", err)
+		}
+	}()
+
+	database := openDatabase(ctx, shutdownCtx, dbConfig, logger, cli.DbStartupTimeout)
+
+	// Report the server unhealthy at /healthz once the database stops answering
+	health.Register(config.NewDBHealthChecker(database))
+
+	// Expose connection pool statistics at /metrics
+	prometheus.MustRegister(config.NewDBMetricsCollector(database))
+
+	database = config.WithRetry(database, cli.DbRetries, cli.DbBackoff)
+
+	// Track which user created or last updated each record
+	if err := database.Use(config.AuditPlugin{}); err != nil {
+		slog.Error("Failed to register audit plugin", "error", err)
+		ctx.FatalIfErrorf(err, "Failed to register audit plugin")
+	}
+
+	// Scope tenant-owned records to the request's tenant
+	if err := database.Use(config.TenantPlugin{}); err != nil {
+		slog.Error("Failed to register tenant plugin", "error", err)
+		ctx.FatalIfErrorf(err, "Failed to register tenant plugin")
+	}
 
 	// Auto migrate models
-	err := database.AutoMigrate(&models.User{})
+	err := database.AutoMigrate(&models.Tenant{}, &models.User{}, &models.AuditLog{}, &models.UserActivity{}, &models.UserTag{}, &models.UserHistory{}, &models.UserSSHKey{}, &models.Webhook{}, &models.RateLimit{}, &models.UserDevice{}, &models.InviteToken{}, &models.LoginEvent{}, &models.FeatureFlag{}, &models.ConsentRecord{}, &models.UserAPIKey{}, &models.UserNote{}, &models.GDPRRequest{}, &models.UserEmbedding{}, &models.UserMetadata{})
 	if err != nil {
 		slog.Error("Failed to migrate database", "error", err)
 		ctx.FatalIfErrorf(err, "Failed to migrate database")
 	}
+	if err := config.RunMigrations(database); err != nil {
+		slog.Error("Failed to run migrations", "error", err)
+		ctx.FatalIfErrorf(err, "Failed to run migrations")
+	}
+	if err := models.MigrateUserSearch(database); err != nil {
+		slog.Error("Failed to create user search index", "error", err)
+		ctx.FatalIfErrorf(err, "Failed to create user search index")
+	}
 
-	// Initialize Gin with custom logger middleware
-	r := gin.New()
-	//	r.Use(ginSlogMiddleware(logger))
-	r.Use(sloggin.New(logger))
-	r.Use(gin.Recovery())
+	// Send emails over SMTP when configured, otherwise just log them
+	var mailer email.Mailer = email.NewLogMailer(logger)
+	if cli.SmtpHost != "" {
+		mailer = email.NewSMTPMailer(cli.SmtpHost, cli.SmtpPort, cli.SmtpFrom)
+	}
 
 	// Initialize controllers
-	userController := controllers.NewUserController(database, logger)
+	userController := controllers.NewUserController(database, logger, mailer)
+	userController.UserPolicy = models.UserPolicy{AllowedEmailDomains: cli.AllowedEmailDomains}
 
-	// Setup routes
-	routes.SetupRoutes(r, userController)
+	var tokens *auth.Issuer
+	if cli.JwtSecret != "" {
+		tokens = auth.NewIssuer([]byte(cli.JwtSecret))
+		userController.Tokens = tokens
+	}
+
+	if cli.PasswordMaxAgeDays > 0 {
+		userController.PasswordMaxAge = time.Duration(cli.PasswordMaxAgeDays) * 24 * time.Hour
+		userController.StartPasswordExpiryReminders(shutdownCtx, 24*time.Hour)
+	}
+
+	if cli.AutoPurgeInterval > 0 {
+		age, err := config.ParseRetention(cli.AutoPurgeOlderThan)
+		if err != nil {
+			slog.Error("Invalid automatic purge age", "error", err)
+			ctx.FatalIfErrorf(err, "Invalid automatic purge age")
+		}
+		userController.StartAutoPurge(shutdownCtx, cli.AutoPurgeInterval, age)
+	}
+
+	// Resolve registration countries when a GeoIP database is provided
+	if cli.GeoipDb != "" {
+		resolver, err := geoip.NewMaxMindResolver(cli.GeoipDb)
+		if err != nil {
+			slog.Error("Failed to load GeoIP database", "error", err, "path", cli.GeoipDb)
+			ctx.FatalIfErrorf(err, "Failed to load GeoIP database")
+		}
+		defer resolver.Close()
+		userController.GeoIP = resolver
+	}
+
+	routerOptions := []routes.RouterOption{
+		routes.WithLogger(logger),
+		routes.WithMiddleware("api-version", middleware.PriorityRequestID, middleware.APIVersion(version, date, commit)),
+		routes.WithMiddleware("api-key", middleware.PriorityAuth, middleware.APIKeyAuth(database, userController.RecordLogin)),
+		routes.WithMiddleware("tenant", middleware.PriorityTenant, middleware.TenantContext(database)),
+		routes.WithMiddleware("device", middleware.PriorityDevice, middleware.DeviceTracker(database, mailer, logger)),
+		routes.WithMiddleware("body-hash", middleware.PriorityBodyHash, middleware.BodyHash()),
+		routes.WithMiddleware("json-case", middleware.PriorityJSONCase, middleware.JSONKeyCase(middleware.KeyCase(cli.JsonCase), routes.KnownJSONFields())),
+		routes.WithMiddleware("query-params", middleware.PriorityLogging, middleware.QueryParamLogger(routes.KnownQueryParams(), logger)),
+	}
+	if tokens != nil {
+		routerOptions = append(routerOptions, routes.WithMiddleware("bearer-auth", middleware.PriorityAuth, middleware.BearerAuth(database, tokens, userController.RecordLogin)))
+	}
+	if cli.ResponseTimeout > 0 {
+		routerOptions = append(routerOptions, routes.WithMiddleware("response-timeout", middleware.PriorityTimeout, middleware.ResponseTimeout(cli.ResponseTimeout)))
+	}
+	if cli.RateLimitRps > 0 {
+		var store middleware.RateLimiterStore = middleware.NewMemoryRateLimiterStore()
+		if cli.RateLimitStore == "sqlite" {
+			store = middleware.NewSQLiteRateLimiterStore(database, logger)
+		}
+		routerOptions = append(routerOptions, routes.WithMiddleware("rate-limit", middleware.PriorityRateLimit, middleware.RateLimit(store, cli.RateLimitRps, cli.RateLimitBurst)))
+	}
+	if cli.MaxConcurrentRequests > 0 {
+		routerOptions = append(routerOptions, routes.WithMiddleware("priority-queue", middleware.PriorityRequestQueue, middleware.PriorityQueue(middleware.PriorityPools(shutdownCtx, cli.MaxConcurrentRequests, cli.HighPriorityReserved, cli.RequestQueueSize))))
+	}
+	if cli.CircuitBreakerThreshold > 0 {
+		routerOptions = append(routerOptions, routes.WithMiddleware("circuit-breaker", middleware.PriorityBreaker, middleware.CircuitBreaker(cli.CircuitBreakerThreshold, cli.CircuitBreakerTimeout)))
+	}
+	if cli.SlowRequestMs > 0 {
+		threshold := time.Duration(cli.SlowRequestMs) * time.Millisecond
+		routerOptions = append(routerOptions, routes.WithMiddleware("slow-request", middleware.PriorityLogging, middleware.SlowRequestLogger(threshold, logger)))
+	}
+	if cli.Debug {
+		routerOptions = append(routerOptions, routes.WithMiddleware("response-body", middleware.PriorityLogging, middleware.ResponseBodyLogger(logger, debugBodyLogBytes)))
+	}
+
+	// Setup routes, announcing the deprecation of /api/v1 when configured
+	var apiOptions []routes.RouteOption
+	if !cli.DeprecationDate.IsZero() {
+		apiOptions = append(apiOptions, routes.WithGroupMiddleware(middleware.DeprecationNotice(cli.DeprecationDate, cli.SuccessorUrl)))
+	}
+	apiOptions = append(apiOptions,
+		routes.WithVersionRoutes(routes.VersionInfo{Version: version, BuildTime: date, GitSHA: commit}),
+		routes.WithUserRoutes(userController),
+		routes.WithAdminRoutes(userController),
+		routes.WithAnalyticsRoutes(userController),
+	)
+	r := routes.SetupRoutes(
+		routes.NewRouter(routerOptions...),
+		apiOptions...,
+	)
 
 	// Swagger endpoint
 	docs.SwaggerInfo.BasePath = "/api/v1"
 	docs.SwaggerInfo.Host = cli.Host + ":" + string(rune(cli.Port))
-	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	routes.SetupSwagger(r, middleware.KeyCase(cli.JsonCase))
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.GET("/healthz", health.Handle)
 
-	// Start server
-	serverAddr := fmt.Sprintf("%s:%d", cli.Host, cli.Port)
-	slog.Info("Starting server",
-		"address", serverAddr,
-		"debug", cli.Debug,
-		"log_level", cli.LogLevel,
-		"log_format", cli.LogFormat,
-		"db_path", cli.DbPath,
-	)
+	// Switch from the startup router to the API
+	handler.Set(r)
+	health.SetReady()
+	slog.Info("Server ready", "address", serverAddr)
 
-	if err := r.Run(serverAddr); err != nil {
+	if err := <-serverErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("Failed to start server", "error", err, "address", serverAddr)
 		ctx.FatalIfErrorf(err, "Failed to start server")
 	}
+
+	if err := config.CloseDB(database, dbConfig); err != nil {
+		slog.Error("Failed to close database", "error", err, "db_path", cli.DbPath)
+	}
+	slog.Info("Server stopped")
 }
 
-// setupLogger configures slog with the specified level and format
-func setupLogger(level, format string) *slog.Logger {
-	var logLevel slog.Level
-	switch level {
-	case "debug":
-		logLevel = slog.LevelDebug
-	case "info":
-		logLevel = slog.LevelInfo
-	case "warn":
-		logLevel = slog.LevelWarn
-	case "error":
-		logLevel = slog.LevelError
-	default:
-		logLevel = slog.LevelInfo
-	}
-
-	opts := &slog.HandlerOptions{
-		Level: logLevel,
-	}
-
-	var handler slog.Handler
-	switch format {
-	case "json":
-		handler = slog.NewJSONHandler(os.Stdout, opts)
-	case "text":
-		handler = slog.NewTextHandler(os.Stdout, opts)
-	default:
-		handler = slog.NewTextHandler(os.Stdout, opts)
-	}
-
-	return slog.New(handler)
+// openDatabase waits for the database to become available, exiting with
+// status 1 when it does not within timeout
+func openDatabase(ctx *kong.Context, shutdownCtx context.Context, cfg config.DBConfig, logger *slog.Logger, timeout time.Duration) *gorm.DB {
+	database, err := config.WaitInitDB(shutdownCtx, cfg, logger, timeout)
+	if err != nil {
+		slog.Error("Failed to open database", "error", err, "db_path", cfg.Path, "timeout", timeout)
+		ctx.FatalIfErrorf(err, "Failed to open database")
+	}
+	return database
 }
@@ -0,0 +1,21 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+// Headers identifying the server build
+const (
+	APIVersionHeader = "X-API-Version"
+	BuildTimeHeader  = "X-Build-Time"
+	GitSHAHeader     = "X-Git-SHA"
+)
+
+// APIVersion adds the server version, build time and git commit to every
+// response, so clients can check compatibility
+func APIVersion(version, buildTime, gitSHA string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header(APIVersionHeader, version)
+		c.Header(BuildTimeHeader, buildTime)
+		c.Header(GitSHAHeader, gitSHA)
+		c.Next()
+	}
+}
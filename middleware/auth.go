@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"go-api/config"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole rejects requests whose authenticated user does not have one of the given roles.
+// The role is read from the request context, where the authentication middleware stores it.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, ok := config.RoleFromContext(c.Request.Context())
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		if !slices.Contains(roles, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelfOrRole rejects requests unless the authenticated user is the one
+// identified by the named path parameter or has one of the given roles.
+// Anonymous requests get a 401 and other users a 403.
+func RequireSelfOrRole(param string, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := config.UserIDFromContext(c.Request.Context())
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		role, _ := config.RoleFromContext(c.Request.Context())
+		if strconv.FormatUint(uint64(userID), 10) != c.Param(param) && !slices.Contains(roles, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RejectImpersonation rejects requests made with an impersonation token with a
+// 403. It guards routes minting credentials or taking over the account, an
+// admin acting as a user must not be able to keep that access.
+func RejectImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := config.ImpersonatedByFromContext(c.Request.Context()); ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Not allowed while impersonating"})
+			return
+		}
+		c.Next()
+	}
+}
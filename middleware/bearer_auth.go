@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"errors"
+	"go-api/auth"
+	"go-api/config"
+	"go-api/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// BearerAuth authenticates requests sending an "Authorization: Bearer" JWT
+// signed by issuer as the token's subject, storing the user ID, role and the
+// impersonating admin, if any, in the request context. The role is read from
+// the database on every request, not from the token, so demoting or deleting a
+// user takes effect before their tokens expire. Invalid or expired tokens, and
+// tokens of deleted users, get a JSON 401. Impersonation tokens also need the
+// admin to still be one, and the user to still not be. Requests without a bearer token are passed on
+// untouched. Authentications of a known user, and expired tokens of one, are
+// passed to record when it is not nil.
+func BearerAuth(db *gorm.DB, issuer *auth.Issuer, record LoginRecorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			c.Next()
+			return
+		}
+
+		claims, err := issuer.Parse(strings.TrimSpace(token))
+		if claims == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+		userID, idErr := claims.UserID()
+		if idErr != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+		if err != nil {
+			record.login(c, userID, false)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+
+		user, err := tokenUser(db.WithContext(c.Request.Context()), userID, claims.ImpersonatedBy)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token"})
+			return
+		}
+
+		ctx := config.ContextWithRole(config.ContextWithUserID(c.Request.Context(), userID), user.Role)
+		if claims.ImpersonatedBy != nil {
+			ctx = config.ContextWithImpersonatedBy(ctx, *claims.ImpersonatedBy)
+		}
+		c.Request = c.Request.WithContext(ctx)
+		record.login(c, userID, true)
+		c.Next()
+	}
+}
+
+// tokenUser loads the user a token was issued to, and checks the admin of an
+// impersonation token still may impersonate them. It returns
+// gorm.ErrRecordNotFound when the token no longer grants access.
+func tokenUser(db *gorm.DB, userID uint, impersonatedBy *uint) (models.User, error) {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return user, err
+	}
+	if impersonatedBy == nil {
+		return user, nil
+	}
+
+	var admin models.User
+	if err := db.First(&admin, *impersonatedBy).Error; err != nil {
+		return user, err
+	}
+	if admin.Role != models.RoleAdmin || user.Role == models.RoleAdmin {
+		return user, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
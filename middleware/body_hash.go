@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentSHA256Header carries the hex SHA-256 of the request body
+const ContentSHA256Header = "X-Content-SHA256"
+
+// BodyHash rejects POST, PUT and PATCH requests with 400 when they send an
+// X-Content-SHA256 header that does not match the SHA-256 of their body, so
+// bodies tampered with in transit are not processed. Requests without the
+// header pass unchecked. It must run before middleware rewriting the body.
+func BodyHash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader(ContentSHA256Header)
+		if header == "" {
+			c.Next()
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			c.Next()
+			return
+		}
+
+		expected, err := hex.DecodeString(header)
+		if err != nil || len(expected) != sha256.Size {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid " + ContentSHA256Header + " header, expected a hex SHA-256"})
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil {
+			if body, err = io.ReadAll(c.Request.Body); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+				return
+			}
+		}
+
+		sum := sha256.Sum256(body)
+		if subtle.ConstantTimeCompare(sum[:], expected) != 1 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Request body does not match " + ContentSHA256Header})
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
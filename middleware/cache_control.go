@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CachePolicy describes how browsers and CDNs may cache a response
+type CachePolicy struct {
+	// MaxAge is how long a cached response stays fresh, rounded down to seconds
+	MaxAge time.Duration
+	// Private limits caching to the client, shared caches such as CDNs must not store it
+	Private bool
+	// NoStore forbids caching altogether and overrides the other fields
+	NoStore bool
+}
+
+// String formats the policy as a Cache-Control header value
+func (p CachePolicy) String() string {
+	if p.NoStore {
+		return "no-store"
+	}
+	visibility := "public"
+	if p.Private {
+		visibility = "private"
+	}
+	return visibility + ", max-age=" + strconv.Itoa(int(p.MaxAge/time.Second))
+}
+
+// CacheControl sets the Cache-Control header of the routes it is attached to
+// according to policy, handlers can still override it
+func CacheControl(policy CachePolicy) gin.HandlerFunc {
+	value := policy.String()
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", value)
+		c.Next()
+	}
+}
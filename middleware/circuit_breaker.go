@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Circuit breaker states
+const (
+	breakerClosed int32 = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker is the shared state of a CircuitBreaker middleware
+type circuitBreaker struct {
+	threshold int32
+	timeout   time.Duration
+	state     atomic.Int32
+	failures  atomic.Int32
+	openedAt  atomic.Int64 // UnixNano
+}
+
+// allow reports whether a request may reach the handler. Once the timeout of
+// an open breaker has passed, exactly one caller wins the switch to half-open
+// and is let through as a probe.
+func (b *circuitBreaker) allow() (allowed, probe bool) {
+	switch b.state.Load() {
+	case breakerClosed:
+		return true, false
+	case breakerOpen:
+		if time.Since(time.Unix(0, b.openedAt.Load())) < b.timeout {
+			return false, false
+		}
+		if b.state.CompareAndSwap(breakerOpen, breakerHalfOpen) {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// record updates the breaker with the outcome of a request that was let through
+func (b *circuitBreaker) record(failed, probe bool) {
+	if !failed {
+		b.failures.Store(0)
+		if probe {
+			b.state.Store(breakerClosed)
+		}
+		return
+	}
+	if probe || b.failures.Add(1) >= b.threshold {
+		b.open()
+	}
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt.Store(time.Now().UnixNano())
+	b.failures.Store(0)
+	b.state.Store(breakerOpen)
+}
+
+// retryAfter is the whole number of seconds left until the breaker lets a probe through
+func (b *circuitBreaker) retryAfter() int {
+	left := b.timeout - time.Since(time.Unix(0, b.openedAt.Load()))
+	return max(int(math.Ceil(left.Seconds())), 1)
+}
+
+// CircuitBreaker stops passing requests to handlers once threshold responses
+// in a row were 5xx errors, so an overloaded database gets time to recover.
+// While open it answers every request with a JSON 503 and Retry-After. After
+// timeout a single request is let through: if it succeeds the breaker closes,
+// otherwise it opens for another timeout. 4xx responses count as successes,
+// and a panicking handler counts as a failure.
+func CircuitBreaker(threshold int, timeout time.Duration) gin.HandlerFunc {
+	breaker := &circuitBreaker{threshold: int32(max(threshold, 1)), timeout: timeout}
+
+	return func(c *gin.Context) {
+		allowed, probe := breaker.allow()
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(breaker.retryAfter()))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Service temporarily unavailable, try again later"})
+			return
+		}
+
+		failed := true
+		defer func() { breaker.record(failed, probe) }()
+
+		c.Next()
+		failed = c.Writer.Status() >= http.StatusInternalServerError
+	}
+}
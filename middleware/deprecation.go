@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeprecationNotice marks every response as coming from a deprecated API
+// version: Deprecation and Sunset carry deadline in RFC 3339 and, when
+// successorURL is set, Link points clients at the replacement version
+func DeprecationNotice(deadline time.Time, successorURL string) gin.HandlerFunc {
+	date := deadline.UTC().Format(time.RFC3339)
+	link := ""
+	if successorURL != "" {
+		link = "<" + successorURL + `>; rel="successor-version"`
+	}
+
+	return func(c *gin.Context) {
+		c.Header("Deprecation", date)
+		c.Header("Sunset", date)
+		if link != "" {
+			c.Writer.Header().Add("Link", link)
+		}
+		c.Next()
+	}
+}
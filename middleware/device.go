@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"cmp"
+	"errors"
+	"go-api/config"
+	"go-api/email"
+	"go-api/models"
+	"log/slog"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Headers identifying the client device
+const (
+	DeviceIDHeader   = "X-Device-ID"
+	DeviceNameHeader = "X-Device-Name"
+)
+
+// maxDeviceHeaderLength bounds the stored device ID and name, in characters
+const maxDeviceHeaderLength = 255
+
+// maxPendingDeviceEmails bounds the new device emails being sent at once,
+// notifications past it are dropped and logged
+const maxPendingDeviceEmails = 16
+
+// errDeviceRevoked is returned by trackDevice for devices the user revoked
+var errDeviceRevoked = errors.New("device revoked")
+
+// DeviceTracker records the device of each authenticated request sending an
+// X-Device-ID header, and emails the user in the background when a device is
+// seen for the first time. The device is named by X-Device-Name, or the
+// User-Agent. Requests from revoked devices get a JSON 403, whether the device
+// is trusted is stored in the request context for RequireTrustedDevice. Other
+// tracking and mail failures are logged and never fail the request. It must
+// run after the authentication middleware.
+func DeviceTracker(db *gorm.DB, mailer email.Mailer, logger *slog.Logger) gin.HandlerFunc {
+	pending := make(chan struct{}, maxPendingDeviceEmails)
+
+	return func(c *gin.Context) {
+		userID, ok := config.UserIDFromContext(c.Request.Context())
+		deviceID := c.GetHeader(DeviceIDHeader)
+		if !ok || deviceID == "" {
+			c.Next()
+			return
+		}
+
+		now := time.Now()
+		device := models.UserDevice{
+			UserID:     userID,
+			DeviceID:   truncate(deviceID, maxDeviceHeaderLength),
+			DeviceName: truncate(cmp.Or(c.GetHeader(DeviceNameHeader), c.Request.UserAgent()), maxDeviceHeaderLength),
+			FirstSeen:  now,
+			LastSeen:   now,
+		}
+		user, err := trackDevice(db.WithContext(c.Request.Context()), &device)
+		switch {
+		case errors.Is(err, errDeviceRevoked):
+			logger.Warn("Request from a revoked device", "user_id", userID, "device_id", device.DeviceID)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Device revoked"})
+			return
+		case err != nil:
+			logger.Error("Failed to track device", "error", err, "user_id", userID, "device_id", device.DeviceID)
+		default:
+			c.Request = c.Request.WithContext(config.ContextWithDeviceTrusted(c.Request.Context(), device.Trusted))
+		}
+
+		// A slow mail server must not hold up the request, nor pile up goroutines
+		if user != nil {
+			select {
+			case pending <- struct{}{}:
+				go func() {
+					defer func() { <-pending }()
+					if err := mailer.SendNewDevice(*user, device); err != nil {
+						logger.Error("Failed to send new device notification", "error", err, "user_id", userID, "device_id", device.DeviceID)
+					}
+				}()
+			default:
+				logger.Warn("Too many new device notifications pending, dropped one", "user_id", userID, "device_id", device.DeviceID)
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireTrustedDevice rejects requests from a known device the user has not
+// trusted with a 403. Requests not identifying a device are let through.
+func RequireTrustedDevice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if trusted, ok := config.DeviceTrustedFromContext(c.Request.Context()); ok && !trusted {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Device not trusted"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// trackDevice inserts the device, or bumps its last_seen and loads its trust
+// when the user already has it, and returns the user to notify when the
+// device is new. Inserting first keeps concurrent requests from a new device
+// from sending more than one notification. New devices join the user's tenant
+// and a user's first device is trusted, the others need trusting from it.
+// Revoked devices stay revoked, they return errDeviceRevoked.
+func trackDevice(db *gorm.DB, device *models.UserDevice) (*models.User, error) {
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(device)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		var known models.UserDevice
+		if err := db.Where("user_id = ? AND device_id = ?", device.UserID, device.DeviceID).First(&known).Error; err != nil {
+			return nil, err
+		}
+		if known.RevokedAt != nil {
+			return nil, errDeviceRevoked
+		}
+		device.Trusted = known.Trusted
+		return nil, db.Model(&known).Update("last_seen", device.LastSeen).Error
+	}
+
+	var user models.User
+	if err := db.First(&user, device.UserID).Error; err != nil {
+		return nil, err
+	}
+
+	// Trust on first use, a user's only device is trusted
+	var devices int64
+	if err := db.Model(&models.UserDevice{}).Where("user_id = ? AND revoked_at IS NULL", device.UserID).Count(&devices).Error; err != nil {
+		return nil, err
+	}
+	device.TenantID, device.Trusted = user.TenantID, devices == 1
+	if err := db.Model(device).Select("TenantID", "Trusted").Updates(device).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// truncate cuts s to at most n characters, never inside a multi-byte one
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyCase is the naming convention used for JSON object keys
+type KeyCase string
+
+const (
+	SnakeCase KeyCase = "snake"
+	CamelCase KeyCase = "camel"
+)
+
+// JSONKeyCase exposes the API with keyCase JSON keys. For CamelCase, object
+// keys in JSON request bodies are converted to snake_case before binding and
+// keys in JSON responses are converted to camelCase. Only the snake_case keys
+// in fields, the JSON names of struct fields, are converted, the keys of
+// free-form maps such as feature flag names are left as sent. SnakeCase, the
+// case the models are tagged with, leaves requests and responses untouched.
+func JSONKeyCase(keyCase KeyCase, fields map[string]bool) gin.HandlerFunc {
+	if keyCase != CamelCase {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	toSnake := func(key string) string {
+		if snake := ToSnakeCase(key); fields[snake] {
+			return snake
+		}
+		return key
+	}
+	toCamel := func(key string) string {
+		if fields[key] {
+			return ToCamelCase(key)
+		}
+		return key
+	}
+
+	return func(c *gin.Context) {
+		if c.Request.Body != nil && c.ContentType() == gin.MIMEJSON {
+			if body, err := io.ReadAll(c.Request.Body); err == nil {
+				body = convertKeys(body, toSnake)
+				c.Request.Body = io.NopCloser(bytes.NewReader(body))
+				c.Request.ContentLength = int64(len(body))
+			}
+		}
+
+		writer := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		// Restore the writer even on panic so recovery can still respond
+		defer func() { c.Writer = writer.ResponseWriter }()
+
+		c.Next()
+
+		if writer.buffering {
+			_, _ = writer.ResponseWriter.Write(convertKeys(writer.body.Bytes(), toCamel))
+		}
+	}
+}
+
+// bufferedWriter holds JSON response bodies back so their keys can be
+// converted. Other bodies, like CSV and ZIP downloads, are written through as
+// they come so they still stream.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+	// started is set by the first write, buffering when its Content-Type was JSON
+	started   bool
+	buffering bool
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.buffering = strings.HasPrefix(w.Header().Get("Content-Type"), gin.MIMEJSON)
+	}
+	if !w.buffering {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// convertKeys renames the object keys in a JSON document, returning data
+// unchanged when it is not valid JSON
+func convertKeys(data []byte, rename func(string) string) []byte {
+	var payload any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return data
+	}
+
+	converted, err := json.Marshal(renameKeys(payload, rename))
+	if err != nil {
+		return data
+	}
+	return converted
+}
+
+func renameKeys(v any, rename func(string) string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		renamed := make(map[string]any, len(v))
+		for key, value := range v {
+			renamed[rename(key)] = renameKeys(value, rename)
+		}
+		return renamed
+	case []any:
+		for i, value := range v {
+			v[i] = renameKeys(value, rename)
+		}
+	}
+	return v
+}
+
+// ToCamelCase converts a snake_case key to camelCase, e.g. user_id to userId
+func ToCamelCase(s string) string {
+	parts := strings.Split(s, "_")
+	var b strings.Builder
+	for i, part := range parts {
+		if i == 0 || part == "" {
+			b.WriteString(part)
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// ToSnakeCase converts a camelCase key to snake_case, e.g. userId to user_id
+func ToSnakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Start a new word after a lowercase letter or digit, or at the
+			// end of an acronym as in "userIDList"
+			prev := rune(0)
+			if i > 0 {
+				prev = runes[i-1]
+			}
+			endsAcronym := unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || endsAcronym {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
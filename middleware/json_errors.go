@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSONRecovery makes every error response JSON. Registered globally, it
+// re-encodes non-JSON 4xx and 5xx bodies as {"error": "<original body>"}.
+// Registered as the NoRoute and NoMethod handler, it answers with
+// {"error": "not found"} or {"error": "method not allowed"} in place of gin's
+// plain text pages.
+func JSONRecovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writer := &jsonErrorWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		c.Writer = writer.ResponseWriter
+		status := c.Writer.Status()
+		switch {
+		case writer.buffering:
+			c.Writer.Header().Del("Content-Type")
+			c.JSON(status, gin.H{"error": strings.TrimSpace(writer.body.String())})
+		case !c.Writer.Written() && status >= http.StatusBadRequest:
+			c.JSON(status, gin.H{"error": strings.ToLower(http.StatusText(status))})
+		}
+	}
+}
+
+// jsonErrorWriter holds back error bodies that are not JSON
+type jsonErrorWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	decided   bool
+	buffering bool
+}
+
+func (w *jsonErrorWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		contentType := w.Header().Get("Content-Type")
+		w.buffering = w.Status() >= http.StatusBadRequest && !strings.HasPrefix(contentType, gin.MIMEJSON)
+	}
+	if w.buffering {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *jsonErrorWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"context"
+	"go-api/config"
+	"go-api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PriorityHeader asks for high priority with the value "high", it is only
+// granted to admins and API key callers
+const PriorityHeader = "X-Priority"
+
+// queuedRequest waits in a queue of PriorityPools until ready is closed
+type queuedRequest struct {
+	ready chan struct{}
+	high  bool
+}
+
+// PriorityQueue runs high priority requests through high and all others
+// through low, typically the two handlers of PriorityPools. A request is high
+// priority when it sends "X-Priority: high" and was authenticated as an admin
+// or with an API key, so it must run after authentication. Anyone else asking
+// for it is queued as low priority.
+func PriorityQueue(high, low gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader(PriorityHeader) == "high" && mayUseHighPriority(c.Request.Context()) {
+			high(c)
+			return
+		}
+		low(c)
+	}
+}
+
+// mayUseHighPriority reports whether the caller is an admin or used an API key
+func mayUseHighPriority(ctx context.Context) bool {
+	if _, ok := config.APIKeyIDFromContext(ctx); ok {
+		return true
+	}
+	role, _ := config.RoleFromContext(ctx)
+	return role == models.RoleAdmin
+}
+
+// PriorityPools returns the handlers of a high and a low priority pool sharing
+// workers slots, each letting a request through once it gets a slot. Waiting
+// requests are held in one queue of up to queueSize requests per pool, which
+// a single dispatcher always drains high first. Low priority requests never
+// take the last reserved slots, so high priority requests get through even
+// when low priority ones saturate the server. At least one slot is left to
+// low priority requests. Requests arriving at a full queue get a JSON 503.
+// The dispatcher stops when ctx is done, requests still waiting then get a
+// JSON 503 too.
+func PriorityPools(ctx context.Context, workers, reserved, queueSize int) (high, low gin.HandlerFunc) {
+	highQueue := make(chan queuedRequest, queueSize)
+	lowQueue := make(chan queuedRequest, queueSize)
+	done := make(chan bool)
+	lowWorkers := max(workers-reserved, 1)
+
+	go func() {
+		running, runningLow := 0, 0
+		start := func(req queuedRequest) {
+			running++
+			if !req.high {
+				runningLow++
+			}
+			close(req.ready)
+		}
+		for {
+			// A nil channel blocks, so full pools stop taking from their queue
+			var highIn, lowIn chan queuedRequest
+			if running < workers {
+				highIn = highQueue
+				if runningLow < lowWorkers {
+					lowIn = lowQueue
+				}
+			}
+
+			select {
+			case req := <-highIn:
+				start(req)
+				continue
+			default:
+			}
+
+			select {
+			case req := <-highIn:
+				start(req)
+			case req := <-lowIn:
+				start(req)
+			case wasHigh := <-done:
+				running--
+				if !wasHigh {
+					runningLow--
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// finish hands a slot back, unless the dispatcher has stopped
+	finish := func(wasHigh bool) {
+		select {
+		case done <- wasHigh:
+		case <-ctx.Done():
+		}
+	}
+
+	pool := func(queue chan queuedRequest, isHigh bool) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			req := queuedRequest{ready: make(chan struct{}), high: isHigh}
+			select {
+			case queue <- req:
+			default:
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Server busy, try again later"})
+				return
+			}
+
+			select {
+			case <-req.ready:
+			case <-ctx.Done():
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Server shutting down"})
+				return
+			case <-c.Request.Context().Done():
+				// The dispatcher may still start the request, hand its slot back
+				go func() {
+					select {
+					case <-req.ready:
+						finish(req.high)
+					case <-ctx.Done():
+					}
+				}()
+				c.Abort()
+				return
+			}
+			defer finish(req.high)
+
+			c.Next()
+		}
+	}
+	return pool(highQueue, true), pool(lowQueue, false)
+}
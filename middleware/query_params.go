@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KnownQueryParamKey returns the key marking param as accepted by the route
+// registered as method and path. With an empty param, it returns the key
+// marking the route as checked by QueryParamLogger.
+func KnownQueryParamKey(method, path, param string) string {
+	key := method + " " + path
+	if param != "" {
+		key += "?" + param
+	}
+	return key
+}
+
+// QueryParamLogger warns about query parameters the matched route does not
+// accept, to help detect parameter pollution and probing. Routes are checked
+// when known holds their KnownQueryParamKey with an empty param, and accept
+// the parameters whose keys known holds, a map parameter such as meta[plan]
+// is accepted by the key of meta. Requests are never rejected.
+func QueryParamLogger(known map[string]bool, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Gin routes before running middleware, so FullPath is already set
+		path := c.FullPath()
+		if !known[KnownQueryParamKey(c.Request.Method, path, "")] {
+			c.Next()
+			return
+		}
+
+		for key := range c.Request.URL.Query() {
+			name, _, _ := strings.Cut(key, "[")
+			if !known[KnownQueryParamKey(c.Request.Method, path, key)] && !known[KnownQueryParamKey(c.Request.Method, path, name)] {
+				logger.Warn("unexpected_query_param", "param", key, "path", path)
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"go-api/models"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RateLimiterStore keeps a token bucket per key
+type RateLimiterStore interface {
+	// Allow takes a token from the bucket of key, which refills at rps tokens
+	// per second up to burst. When none is available it reports how long
+	// until one will be.
+	Allow(key string, rps float64, burst int) (bool, time.Duration)
+}
+
+// RateLimit rejects clients making more than rps requests per second, after
+// an initial burst, with 429 Too Many Requests. Clients are identified by IP.
+func RateLimit(store RateLimiterStore, rps float64, burst int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, retryAfter := store.Allow(c.ClientIP(), rps, burst)
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// tokenBucket holds the tokens left and when they were last refilled
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(now time.Time, burst int) tokenBucket {
+	return tokenBucket{tokens: float64(burst), lastRefill: now}
+}
+
+// take refills the bucket for the time elapsed since the last refill and
+// takes a token, or reports how long until one is available
+func (b *tokenBucket) take(now time.Time, rps float64, burst int) (bool, time.Duration) {
+	if elapsed := now.Sub(b.lastRefill).Seconds(); elapsed > 0 {
+		b.tokens = min(float64(burst), b.tokens+elapsed*rps)
+	}
+	b.lastRefill = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rps * float64(time.Second))
+}
+
+// full reports whether the bucket will have refilled completely by now, so
+// forgetting it does not change the outcome of later requests
+func (b *tokenBucket) full(now time.Time, rps float64, burst int) bool {
+	return b.tokens+now.Sub(b.lastRefill).Seconds()*rps >= float64(burst)
+}
+
+// memoryStoreSweepSize is how many buckets MemoryRateLimiterStore holds
+// before it drops the ones that have refilled completely
+const memoryStoreSweepSize = 10000
+
+// MemoryRateLimiterStore keeps the buckets in process memory, they are lost
+// on restart
+type MemoryRateLimiterStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func NewMemoryRateLimiterStore() *MemoryRateLimiterStore {
+	return &MemoryRateLimiterStore{buckets: make(map[string]*tokenBucket)}
+}
+
+func (s *MemoryRateLimiterStore) Allow(key string, rps float64, burst int) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= memoryStoreSweepSize {
+			for k, b := range s.buckets {
+				if b.full(now, rps, burst) {
+					delete(s.buckets, k)
+				}
+			}
+		}
+		b := newTokenBucket(now, burst)
+		bucket = &b
+		s.buckets[key] = bucket
+	}
+	return bucket.take(now, rps, burst)
+}
+
+// SQLiteRateLimiterStore keeps the buckets in the rate_limits table, so limits
+// survive restarts and are shared by every process using the database. Each
+// check takes an exclusive lock on the database, which makes it considerably
+// slower than MemoryRateLimiterStore.
+type SQLiteRateLimiterStore struct {
+	db     *gorm.DB
+	logger *slog.Logger
+}
+
+// NewSQLiteRateLimiterStore returns a store using db, which must have the
+// models.RateLimit table migrated
+func NewSQLiteRateLimiterStore(db *gorm.DB, logger *slog.Logger) *SQLiteRateLimiterStore {
+	return &SQLiteRateLimiterStore{db: db, logger: logger}
+}
+
+// Allow lets the request through when the database fails, so an unavailable
+// database does not also take down every endpoint
+func (s *SQLiteRateLimiterStore) Allow(key string, rps float64, burst int) (bool, time.Duration) {
+	var (
+		allowed    bool
+		retryAfter time.Duration
+	)
+	err := s.db.Connection(func(conn *gorm.DB) error {
+		// BEGIN EXCLUSIVE serializes the read-modify-write across connections
+		// and processes, a deferred transaction would let two of them read the
+		// same token count
+		if err := conn.Exec("BEGIN EXCLUSIVE").Error; err != nil {
+			return err
+		}
+
+		var err error
+		allowed, retryAfter, err = s.take(conn.Session(&gorm.Session{SkipDefaultTransaction: true}), key, rps, burst)
+		if err == nil {
+			err = conn.Exec("COMMIT").Error
+		}
+		if err != nil {
+			conn.Exec("ROLLBACK")
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Error("Failed to check rate limit", "error", err, "key", key)
+		return true, 0
+	}
+	return allowed, retryAfter
+}
+
+func (s *SQLiteRateLimiterStore) take(tx *gorm.DB, key string, rps float64, burst int) (bool, time.Duration, error) {
+	now := time.Now()
+	bucket := newTokenBucket(now, burst)
+
+	var row models.RateLimit
+	result := tx.Where("key = ?", key).Limit(1).Find(&row)
+	if result.Error != nil {
+		return false, 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		bucket = tokenBucket{tokens: row.Tokens, lastRefill: row.LastRefill}
+	}
+
+	allowed, retryAfter := bucket.take(now, rps, burst)
+
+	row = models.RateLimit{Key: key, Tokens: bucket.tokens, LastRefill: bucket.lastRefill}
+	if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error; err != nil {
+		return false, 0, err
+	}
+	return allowed, retryAfter, nil
+}
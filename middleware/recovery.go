@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxStackSize caps the logged stack trace to avoid flooding the logs
+const maxStackSize = 3 << 10
+
+// RecoverWithSlog recovers from panics, logs them through logger and responds with a JSON 500
+func RecoverWithSlog(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				stack := debug.Stack()
+				if len(stack) > maxStackSize {
+					stack = stack[:maxStackSize]
+				}
+
+				logger.Error("panic recovered",
+					"error", r,
+					"stack", string(stack),
+					"method", c.Request.Method,
+					"path", c.Request.URL.Path,
+				)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			}
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Well-known middleware priorities, lower values run first
+const (
+	PriorityRecovery     = 0
+	PriorityJSONErrors   = 5
+	PriorityRequestID    = 10
+	PriorityRequestStart = 15
+	PriorityLogging      = 20
+	PriorityRateLimit    = 22
+	PriorityBreaker      = 24
+	PriorityTimeout      = 25
+	PriorityAuth         = 30
+	PriorityRequestQueue = 31
+	PriorityDevice       = 32
+	PriorityTenant       = 33
+	PriorityBodyHash     = 34
+	PriorityJSONCase     = 35
+)
+
+type registryEntry struct {
+	name     string
+	priority int
+	fn       gin.HandlerFunc
+}
+
+// Registry collects global middleware and applies it to an engine in priority order
+type Registry struct {
+	entries []registryEntry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a middleware. Entries with equal priority keep their registration order.
+func (reg *Registry) Register(name string, priority int, fn gin.HandlerFunc) {
+	reg.entries = append(reg.entries, registryEntry{name: name, priority: priority, fn: fn})
+}
+
+// Names returns the registered middleware names in the order they will be applied
+func (reg *Registry) Names() []string {
+	names := make([]string, 0, len(reg.entries))
+	for _, entry := range reg.sorted() {
+		names = append(names, entry.name)
+	}
+	return names
+}
+
+// Apply sorts the registered middleware by priority and installs it on r
+func (reg *Registry) Apply(r *gin.Engine) {
+	for _, entry := range reg.sorted() {
+		r.Use(entry.fn)
+	}
+}
+
+func (reg *Registry) sorted() []registryEntry {
+	entries := slices.Clone(reg.entries)
+	slices.SortStableFunc(entries, func(a, b registryEntry) int {
+		return a.priority - b.priority
+	})
+	return entries
+}
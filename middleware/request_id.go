@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID in requests and responses
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID tags every request with the incoming X-Request-ID header or a new
+// UUID, echoes it in the response and stores it on the request so logging
+// middleware running later reports the same ID
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" {
+			id = uuid.NewString()
+			c.Request.Header.Set(RequestIDHeader, id)
+		}
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
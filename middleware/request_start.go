@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	sloggin "github.com/samber/slog-gin"
+)
+
+// RequestStartHeader is set by reverse proxies to when they received the request
+const RequestStartHeader = "X-Request-Start"
+
+// queueTimeKey stores the request's queue time on the gin context
+const queueTimeKey = "queue_time"
+
+// RequestStartTime measures how long the request waited between reaching the
+// proxy, per the X-Request-Start header, and reaching this middleware. The
+// queue time is stored on the context and added to the access log as
+// queue_time_ms. Without the header the queue time is zero.
+func RequestStartTime() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		start, ok := parseRequestStart(c.GetHeader(RequestStartHeader))
+		if !ok || start.After(now) {
+			// Missing header or clock skew between proxy and server
+			start = now
+		}
+
+		queueTime := now.Sub(start)
+		c.Set(queueTimeKey, queueTime)
+		sloggin.AddCustomAttributes(c, slog.Int64("queue_time_ms", queueTime.Milliseconds()))
+		c.Next()
+	}
+}
+
+// QueueTime returns the queue time measured by RequestStartTime
+func QueueTime(c *gin.Context) time.Duration {
+	queueTime, _ := c.Get(queueTimeKey)
+	d, _ := queueTime.(time.Duration)
+	return d
+}
+
+// parseRequestStart accepts "t=<unix millis>" as sent by most proxies and
+// "t=<unix seconds>.<fraction>" as produced by Nginx's $msec
+func parseRequestStart(header string) (time.Time, bool) {
+	value := strings.TrimPrefix(strings.TrimSpace(header), "t=")
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if strings.Contains(value, ".") {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.UnixMicro(int64(seconds * 1e6)), true
+	}
+
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(millis), true
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponseBodyLogger logs up to maxBytes of every response body at debug
+// level, marking longer bodies with a [truncated] suffix
+func ResponseBodyLogger(logger *slog.Logger, maxBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		writer := &capturingWriter{ResponseWriter: c.Writer, limit: maxBytes}
+		c.Writer = writer
+
+		c.Next()
+
+		body := writer.body.String()
+		if writer.truncated {
+			body += "[truncated]"
+		}
+		logger.Debug("Response body",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", writer.Status(),
+			"body", body,
+		)
+	}
+}
+
+// capturingWriter passes the response through while keeping its first limit bytes
+type capturingWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *capturingWriter) capture(b []byte) {
+	if room := w.limit - w.body.Len(); room < len(b) {
+		b = b[:max(room, 0)]
+		w.truncated = true
+	}
+	w.body.Write(b)
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"go-api/config"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SlowRequestLogger warns about requests taking longer than threshold to handle
+func SlowRequestLogger(threshold time.Duration, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		duration := time.Since(start)
+		if duration <= threshold {
+			return
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"duration_ms", duration.Milliseconds(),
+			"status", c.Writer.Status(),
+		}
+		// Read after c.Next so the authentication middleware has run
+		if userID, ok := config.UserIDFromContext(c.Request.Context()); ok {
+			attrs = append(attrs, "user_id", userID)
+		}
+		logger.Warn("Slow request", attrs...)
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"errors"
+	"go-api/config"
+	"go-api/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TenantIDHeader selects the tenant a request acts on, for admins outside any tenant
+const TenantIDHeader = "X-Tenant-ID"
+
+// TenantContext stores the authenticated user's tenant in the request
+// context, so config.TenantPlugin scopes the request's database operations to
+// it. It must run after authentication. Users of a tenant always act on it,
+// sending another tenant in X-Tenant-ID gets a 403. Admins outside any tenant
+// pick the tenant with the header and are not scoped without it, other users
+// outside any tenant can't send it. Unauthenticated requests sending the
+// header get a 401, those without it are not scoped.
+func TenantContext(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader(TenantIDHeader)
+		userID, authenticated := config.UserIDFromContext(c.Request.Context())
+		if !authenticated {
+			if header != "" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		var user models.User
+		if err := db.WithContext(c.Request.Context()).Select("id", "tenant_id").First(&user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load tenant"})
+			return
+		}
+
+		var tenantID uint64
+		if header != "" {
+			var err error
+			tenantID, err = strconv.ParseUint(header, 10, 0)
+			if err != nil || tenantID == 0 {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+				return
+			}
+		}
+
+		if user.TenantID != nil {
+			if header != "" && uint(tenantID) != *user.TenantID {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Not a member of this tenant"})
+				return
+			}
+			c.Request = c.Request.WithContext(config.ContextWithTenantID(c.Request.Context(), *user.TenantID))
+			c.Next()
+			return
+		}
+
+		if header == "" {
+			c.Next()
+			return
+		}
+		if role, _ := config.RoleFromContext(c.Request.Context()); role != models.RoleAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Not a member of this tenant"})
+			return
+		}
+
+		var tenant models.Tenant
+		if err := db.WithContext(c.Request.Context()).First(&tenant, tenantID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load tenant"})
+			return
+		}
+
+		c.Request = c.Request.WithContext(config.ContextWithTenantID(c.Request.Context(), tenant.ID))
+		c.Next()
+	}
+}
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponseTimeout limits how long the rest of the chain may take to start its
+// response. Time spent waiting for the request body is not counted, so slow
+// uploads are left to the server's read timeout. When d elapses before the
+// handler writes anything the request context is cancelled and the client
+// gets a JSON 503, whatever the handler writes afterwards is discarded.
+// Once the handler has started writing, the response is streamed as usual
+// and no longer times out. Nothing is buffered, the handler's headers are
+// only held until its first write.
+func ResponseTimeout(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		original := c.Writer
+		writer := &timeoutWriter{ResponseWriter: original, header: make(http.Header), status: http.StatusOK, remaining: d, resumed: time.Now()}
+		writer.timer = time.AfterFunc(d, func() {
+			if writer.timeout() {
+				cancel()
+			}
+		})
+
+		c.Request = c.Request.WithContext(ctx)
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			c.Request.Body = &pausingBody{ReadCloser: c.Request.Body, writer: writer}
+		}
+		c.Writer = writer
+		defer func() {
+			c.Writer = original
+			// Leave the response to the recovery middleware
+			if p := recover(); p != nil {
+				writer.stop()
+				panic(p)
+			}
+			writer.finish()
+		}()
+
+		c.Next()
+	}
+}
+
+// timeoutWriter holds the status and headers of the response until the
+// handler first writes, which disarms the timeout
+type timeoutWriter struct {
+	gin.ResponseWriter
+	mu     sync.Mutex
+	header http.Header
+	status int
+	// started is set once the response went out, from the handler or as a 503
+	started  bool
+	timedOut bool
+	done     bool
+
+	timer *time.Timer
+	// remaining is the time left when the clock was last resumed
+	remaining time.Duration
+	resumed   time.Time
+	paused    bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started && !w.timedOut {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.started {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.start()
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.start()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.started {
+		return -1
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.started
+}
+
+// Flush sends the response so far, streaming handlers flush to start early
+func (w *timeoutWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+	w.start()
+	w.ResponseWriter.Flush()
+}
+
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	w.started = true
+	w.timer.Stop()
+	return w.ResponseWriter.Hijack()
+}
+
+// start sends the status and headers and disarms the timeout, w.mu must be held
+func (w *timeoutWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	w.timer.Stop()
+	maps.Copy(w.ResponseWriter.Header(), w.header)
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// timeout answers 503 unless the response already started, the handler
+// returned or the clock was paused meanwhile, and reports whether it did
+func (w *timeoutWriter) timeout() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started || w.done || w.paused {
+		return false
+	}
+	w.started = true
+	w.timedOut = true
+	w.ResponseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.ResponseWriter.Write([]byte(`{"error":"Response timeout"}`))
+	// Send the 503 now, the handler may keep running if it ignores the context
+	w.ResponseWriter.Flush()
+	return true
+}
+
+// finish stops the clock, sending the response of handlers that only set a
+// status. The response writer must not be used once the middleware returns.
+func (w *timeoutWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.done = true
+	w.timer.Stop()
+	if !w.started {
+		w.start()
+	}
+}
+
+// stop disarms the timeout without sending anything
+func (w *timeoutWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.done = true
+	w.timer.Stop()
+}
+
+// pause stops the clock while the handler waits for the request body
+func (w *timeoutWriter) pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started || w.paused {
+		return
+	}
+	w.paused = true
+	w.timer.Stop()
+	w.remaining -= time.Since(w.resumed)
+}
+
+func (w *timeoutWriter) resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started || !w.paused {
+		return
+	}
+	w.paused = false
+	w.resumed = time.Now()
+	w.timer.Reset(max(w.remaining, 0))
+}
+
+// pausingBody pauses the response timeout during reads of the request body
+type pausingBody struct {
+	io.ReadCloser
+	writer *timeoutWriter
+}
+
+func (b *pausingBody) Read(p []byte) (int, error) {
+	b.writer.pause()
+	defer b.writer.resume()
+	return b.ReadCloser.Read(p)
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Audit log actions
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+	// AuditActionImpersonate records an admin acting as the user, ActorID is the admin
+	AuditActionImpersonate = "impersonate"
+)
+
+type AuditLog struct {
+	ID         uint   `json:"id" gorm:"primarykey"`
+	EntityType string `json:"entity_type" gorm:"not null;index:idx_audit_logs_entity"`
+	EntityID   uint   `json:"entity_id" gorm:"not null;index:idx_audit_logs_entity"`
+	Action     string `json:"action" gorm:"not null"`
+	ActorID    *uint  `json:"actor_id,omitempty" gorm:"index"`
+	// TenantID is the tenant of the request that made the change
+	TenantID       *uint     `json:"-" gorm:"index"`
+	BeforeSnapshot *string   `json:"before_snapshot,omitempty" gorm:"type:text"`
+	AfterSnapshot  *string   `json:"after_snapshot,omitempty" gorm:"type:text"`
+	CreatedAt      time.Time `json:"created_at"`
+}
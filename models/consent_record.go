@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ConsentRecord is a user granting or withdrawing one type of consent, kept
+// as evidence of when and from where the decision was made
+type ConsentRecord struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	UserID      uint      `json:"user_id" gorm:"not null;index:idx_consent_records_user_type"`
+	User        User      `json:"-"`
+	ConsentType string    `json:"consent_type" gorm:"not null;index:idx_consent_records_user_type"`
+	Granted     bool      `json:"granted" gorm:"not null"`
+	IPAddress   string    `json:"ip_address"`
+	UserAgent   string    `json:"user_agent"`
+	Timestamp   time.Time `json:"timestamp" gorm:"not null"`
+}
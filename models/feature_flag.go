@@ -0,0 +1,30 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// FeatureFlag turns a feature on for the users it targets. A flag without
+// targets applies to every user, a disabled flag to none.
+type FeatureFlag struct {
+	ID            uint      `json:"id" gorm:"primarykey"`
+	Name          string    `json:"name" gorm:"not null;uniqueIndex"`
+	Enabled       bool      `json:"enabled" gorm:"not null;default:false"`
+	TargetRoles   []string  `json:"target_roles" gorm:"type:json;serializer:json"`
+	TargetUserIDs []uint    `json:"target_user_ids" gorm:"type:json;serializer:json"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// EnabledFor reports whether the flag is on for user, because it targets the
+// user's role or ID or targets nobody in particular
+func (f FeatureFlag) EnabledFor(user User) bool {
+	if !f.Enabled {
+		return false
+	}
+	if len(f.TargetRoles) == 0 && len(f.TargetUserIDs) == 0 {
+		return true
+	}
+	return slices.Contains(f.TargetRoles, user.Role) || slices.Contains(f.TargetUserIDs, user.ID)
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// GDPR request types, after the data subject rights they exercise
+const (
+	GDPRRequestAccess        = "access"
+	GDPRRequestRectification = "rectification"
+	GDPRRequestErasure       = "erasure"
+	GDPRRequestPortability   = "portability"
+)
+
+// GDPRRequest is a data subject request a user made, logged by the data
+// protection officer. CompletedAt is set once it has been fulfilled.
+type GDPRRequest struct {
+	ID          uint       `json:"id" gorm:"primarykey"`
+	UserID      uint       `json:"user_id" gorm:"not null;index"`
+	User        User       `json:"-"`
+	Type        string     `json:"type" gorm:"not null"`
+	RequestedAt time.Time  `json:"requested_at" gorm:"not null"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
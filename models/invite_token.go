@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// InviteToken lets the holder register an account with Email and Role until
+// ExpiresAt, once. The account is created in the tenant the invite was made in.
+type InviteToken struct {
+	ID        uint       `json:"id" gorm:"primarykey"`
+	Token     string     `json:"token" gorm:"uniqueIndex;not null"`
+	Email     string     `json:"email" gorm:"not null"`
+	Role      string     `json:"role" gorm:"not null;default:user"`
+	TenantID  *uint      `json:"-" gorm:"index"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// LoginEvent is one attempt to log in as a user
+type LoginEvent struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	User      User      `json:"-"`
+	IPAddress string    `json:"ip_address"`
+	IPCountry string    `json:"ip_country" gorm:"index"`
+	UserAgent string    `json:"user_agent"`
+	Timestamp time.Time `json:"timestamp" gorm:"not null;index"`
+	Success   bool      `json:"success"`
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// RateLimit is the persisted token bucket of a rate limited client
+type RateLimit struct {
+	Key        string    `gorm:"primarykey"`
+	Tokens     float64   `gorm:"not null"`
+	LastRefill time.Time `gorm:"not null"`
+}
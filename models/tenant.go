@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Tenant is a customer whose users are isolated from other tenants
+type Tenant struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	Name      string    `json:"name" gorm:"not null"`
+	Slug      string    `json:"slug" gorm:"uniqueIndex;not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
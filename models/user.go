@@ -1,16 +1,138 @@
 package models
 
 import (
+	"encoding/json"
+	"html"
+	"log/slog"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"gorm.io/gorm"
 )
 
+// User roles
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+	// RoleSupport is for support agents, who can read and annotate accounts
+	RoleSupport = "support"
+)
+
+// ValidRoles lists every role a user can have
+var ValidRoles = []string{RoleAdmin, RoleUser, RoleSupport}
+
 type User struct {
-	ID        uint           `json:"id" gorm:"primarykey"`
-	Name      string         `json:"name" gorm:"not null"`
-	Email     string         `json:"email" gorm:"uniqueIndex;not null"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID                         uint            `json:"id" gorm:"primarykey"`
+	Name                       string          `json:"name" gorm:"not null"`
+	Role                       string          `json:"role" gorm:"not null;default:user" binding:"omitempty,oneof=admin user support"`
+	Email                      string          `json:"email" gorm:"uniqueIndex;not null"`
+	TenantID                   *uint           `json:"tenant_id,omitempty" gorm:"index"`
+	Tenant                     *Tenant         `json:"-"`
+	Timezone                   string          `json:"timezone,omitempty" binding:"omitempty,timezone"`
+	Preferences                UserPreferences `json:"preferences" gorm:"type:json;serializer:json"`
+	CreatedAt                  time.Time       `json:"created_at"`
+	UpdatedAt                  time.Time       `json:"updated_at"`
+	LockedUntil                *time.Time      `json:"locked_until,omitempty"`
+	PendingEmail               *string         `json:"pending_email,omitempty"`
+	EmailChangeToken           *string         `json:"-" gorm:"uniqueIndex"`
+	EmailChangeExpiresAt       *time.Time      `json:"-"`
+	EmailVerifiedAt            *time.Time      `json:"email_verified_at,omitempty"`
+	EmailVerificationSecret    *string         `json:"-"`
+	EmailVerificationExpiresAt *time.Time      `json:"-"`
+	// VerificationFailures counts wrong codes entered for the pending code
+	VerificationFailures     int            `json:"-" gorm:"not null;default:0"`
+	VerificationAttempts     int            `json:"-" gorm:"not null;default:0"`
+	LastAttemptAt            *time.Time     `json:"-"`
+	PasswordHash             *string        `json:"-"`
+	PasswordExpiresAt        *time.Time     `json:"password_expires_at,omitempty" gorm:"index"`
+	PasswordExpiryRemindedAt *time.Time     `json:"-"`
+	CreatedBy                *uint          `json:"created_by,omitempty"`
+	UpdatedBy                *uint          `json:"updated_by,omitempty"`
+	Creator                  *User          `json:"-" gorm:"foreignKey:CreatedBy"`
+	Updater                  *User          `json:"-" gorm:"foreignKey:UpdatedBy"`
+	DeletedAt                gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// IsActive reports whether the user is neither deleted nor currently locked
+func (u User) IsActive() bool {
+	if u.DeletedAt.Valid {
+		return false
+	}
+	return u.LockedUntil == nil || u.LockedUntil.Before(time.Now())
+}
+
+// BeforeSave HTML-escapes the name so it can't inject markup into frontends
+// that render it as HTML. Escaping is idempotent, saving a stored user again
+// leaves its name as is.
+func (u *User) BeforeSave(tx *gorm.DB) error {
+	switch dest := tx.Statement.Dest.(type) {
+	case map[string]any:
+		if name, ok := dest["name"].(string); ok {
+			dest["name"] = escapeName(name)
+		}
+	case User:
+		if dest.Name != "" {
+			tx.Statement.SetColumn("Name", escapeName(dest.Name))
+		}
+	case *User:
+		if dest != u && dest.Name != "" {
+			tx.Statement.SetColumn("Name", escapeName(dest.Name))
+		}
+	}
+	u.Name = escapeName(u.Name)
+	return nil
+}
+
+// BeforeUpdate clears the email verification when the email changes, unless
+// the same update sets it, a new address is unverified
+func (u *User) BeforeUpdate(tx *gorm.DB) error {
+	if tx.Statement.Changed("Email") && !tx.Statement.Changed("EmailVerifiedAt") {
+		tx.Statement.SetColumn("EmailVerifiedAt", nil)
+	}
+	return nil
+}
+
+func escapeName(name string) string {
+	return html.EscapeString(html.UnescapeString(name))
+}
+
+// DisplayName returns the name as typed, for plain text like emails and
+// PDFs, where the stored HTML escaping would show
+func (u User) DisplayName() string {
+	return html.UnescapeString(u.Name)
+}
+
+// MarshalJSON adds the computed is_active field to the user's JSON representation
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User // drops the MarshalJSON method to avoid recursion
+	return json.Marshal(struct {
+		user
+		IsActive bool `json:"is_active"`
+	}{user(u), u.IsActive()})
+}
+
+// LogValue implements slog.LogValuer so logging a user only records its
+// identifying attributes, with the email masked
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Uint64("id", uint64(u.ID)),
+		slog.String("email", MaskEmail(u.Email)),
+		slog.String("name", u.Name),
+		slog.String("role", u.Role),
+	)
+}
+
+// MaskEmail keeps the first character of the local part and the domain of an
+// email address, e.g. u***@example.com
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return "***"
+	}
+	if local == "" {
+		return "***@" + domain
+	}
+	_, size := utf8.DecodeRuneInString(local)
+	return local[:size] + "***@" + domain
 }
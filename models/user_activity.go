@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// User activity actions
+const (
+	ActivityRegister = "register"
+)
+
+type UserActivity struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	User      User      `json:"-"`
+	Action    string    `json:"action" gorm:"not null"`
+	IP        string    `json:"ip"`
+	IPCountry string    `json:"ip_country" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// apiKeyPrefix starts every API key so leaked keys are easy to spot
+const apiKeyPrefix = "gak_"
+
+// UserAPIKey lets a client authenticate as the user with an X-API-Key header.
+// Only the SHA-256 hash of the key is stored. A key being rotated stays valid
+// until RotatingUntil. It belongs to the tenant it was created in.
+type UserAPIKey struct {
+	ID            uint       `json:"id" gorm:"primarykey"`
+	UserID        uint       `json:"user_id" gorm:"not null;index"`
+	TenantID      *uint      `json:"-" gorm:"index"`
+	User          User       `json:"-"`
+	Name          string     `json:"name" gorm:"not null"`
+	Prefix        string     `json:"prefix" gorm:"not null"`
+	KeyHash       string     `json:"-" gorm:"uniqueIndex;not null"`
+	RotatingUntil *time.Time `json:"rotating_until,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// NewUserAPIKey generates a key for the user, returning the record to save and
+// the plaintext key, which is only ever shown once
+func NewUserAPIKey(userID uint, name string) (UserAPIKey, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return UserAPIKey{}, "", err
+	}
+	plaintext := apiKeyPrefix + hex.EncodeToString(secret)
+	return UserAPIKey{
+		UserID:  userID,
+		Name:    name,
+		Prefix:  plaintext[:len(apiKeyPrefix)+8],
+		KeyHash: HashAPIKey(plaintext),
+	}, plaintext, nil
+}
+
+// HashAPIKey returns the hash a plaintext API key is stored and looked up by
+func HashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidAt reports whether the key is accepted at t, keys that are not being
+// rotated never expire
+func (k *UserAPIKey) ValidAt(t time.Time) bool {
+	return k.RotatingUntil == nil || t.Before(*k.RotatingUntil)
+}
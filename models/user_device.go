@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// UserDevice is a device a user has made authenticated requests from,
+// identified by the client-generated X-Device-ID header. Revoked devices are
+// kept so their requests can be rejected. Devices belong to the user's tenant.
+type UserDevice struct {
+	ID         uint       `json:"id" gorm:"primarykey"`
+	UserID     uint       `json:"user_id" gorm:"not null;uniqueIndex:idx_user_devices_user_device"`
+	TenantID   *uint      `json:"-" gorm:"index"`
+	User       User       `json:"-"`
+	DeviceID   string     `json:"device_id" gorm:"not null;uniqueIndex:idx_user_devices_user_device"`
+	DeviceName string     `json:"device_name"`
+	FirstSeen  time.Time  `json:"first_seen" gorm:"not null"`
+	LastSeen   time.Time  `json:"last_seen" gorm:"not null"`
+	Trusted    bool       `json:"trusted" gorm:"not null;default:false"`
+	RevokedAt  *time.Time `json:"-"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// UserEmbedding is a feature vector describing a user, computed by an
+// external model for recommendations. SQLite stores it as a JSON array in a
+// BLOB, a PostgreSQL deployment would use a pgvector vector column instead.
+type UserEmbedding struct {
+	UserID    uint      `json:"user_id" gorm:"primarykey;autoIncrement:false"`
+	User      User      `json:"-"`
+	Vector    []float32 `json:"vector" gorm:"type:blob;serializer:json;not null"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// UserHistory is a numbered snapshot of a user taken after each create and update
+type UserHistory struct {
+	ID     uint `json:"id" gorm:"primarykey"`
+	UserID uint `json:"user_id" gorm:"not null;uniqueIndex:idx_user_histories_user_version"`
+	User   User `json:"-"`
+	// Version counts the user's snapshots, starting at 1
+	Version int `json:"version" gorm:"not null;uniqueIndex:idx_user_histories_user_version"`
+	// Snapshot is the user as a JSON object, sensitive fields hold a hash of their value
+	Snapshot  string    `json:"snapshot" gorm:"type:text;not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// UserMetadata is a deployment-specific attribute of a user, such as
+// plan=enterprise. A user has at most one value per key.
+type UserMetadata struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_user_metadata_user_key"`
+	User      User      `json:"-"`
+	Key       string    `json:"key" gorm:"not null;uniqueIndex:idx_user_metadata_user_key;index:idx_user_metadata_key_value"`
+	Value     string    `json:"value" gorm:"not null;index:idx_user_metadata_key_value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserNote is a private note support staff keep on a user account, the user
+// only sees it in their data export
+type UserNote struct {
+	ID        uint           `json:"id" gorm:"primarykey"`
+	UserID    uint           `json:"user_id" gorm:"not null;index"`
+	User      User           `json:"-"`
+	AuthorID  uint           `json:"author_id" gorm:"not null;index"`
+	Content   string         `json:"content" gorm:"type:text;not null"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
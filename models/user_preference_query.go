@@ -0,0 +1,90 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidPreferenceQuery is returned when a preference query names an
+// unknown preference or a value of the wrong type
+var ErrInvalidPreferenceQuery = errors.New("invalid preference query")
+
+// preferenceKinds maps the JSON name of every preference to its Go kind
+var preferenceKinds = func() map[string]reflect.Kind {
+	t := reflect.TypeFor[UserPreferences]()
+	kinds := make(map[string]reflect.Kind, t.NumField())
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		kinds[name] = t.Field(i).Type.Kind()
+	}
+	return kinds
+}()
+
+// UserPreferenceQuery matches users whose preference Key, a JSON field name of
+// UserPreferences, is set to Value
+type UserPreferenceQuery struct {
+	Key   string
+	Value string
+}
+
+// Validate checks that Key is a known preference and Value parses as its type
+func (q UserPreferenceQuery) Validate() error {
+	_, err := q.value()
+	return err
+}
+
+// value parses Value as the type of the preference
+func (q UserPreferenceQuery) value() (any, error) {
+	kind, ok := preferenceKinds[q.Key]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown preference %q", ErrInvalidPreferenceQuery, q.Key)
+	}
+	switch kind {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(q.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be true or false", ErrInvalidPreferenceQuery, q.Key)
+		}
+		return b, nil
+	case reflect.Int:
+		n, err := strconv.Atoi(q.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be an integer", ErrInvalidPreferenceQuery, q.Key)
+		}
+		return n, nil
+	}
+	return q.Value, nil
+}
+
+// Scope returns a scope keeping the users matching q, which must be valid.
+// PostgreSQL compares the preference as text, SQLite as its JSON value.
+func (q UserPreferenceQuery) Scope() func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		value, err := q.value()
+		if err != nil {
+			db.AddError(err)
+			return db
+		}
+		if db.Dialector.Name() == "postgres" {
+			return db.Where("preferences->>? = ?", q.Key, fmt.Sprint(value))
+		}
+		// JSON_EXTRACT returns booleans as 1 or 0
+		if b, ok := value.(bool); ok {
+			value = 0
+			if b {
+				value = 1
+			}
+		}
+		return db.Where("JSON_EXTRACT(preferences, ?) = ?", "$."+q.Key, value)
+	}
+}
+
+// ScopeByPreference returns a scope keeping the users whose preference key is set to value
+func ScopeByPreference(key, value string) func(*gorm.DB) *gorm.DB {
+	return UserPreferenceQuery{Key: key, Value: value}.Scope()
+}
@@ -0,0 +1,10 @@
+package models
+
+// UserPreferences holds per-user settings, stored as JSON so new settings
+// don't need a migration
+type UserPreferences struct {
+	Theme              string `json:"theme,omitempty" binding:"omitempty,oneof=light dark system"`
+	Language           string `json:"language,omitempty" binding:"omitempty,bcp47_language_tag"`
+	EmailNotifications bool   `json:"email_notifications"`
+	ItemsPerPage       int    `json:"items_per_page,omitempty" binding:"omitempty,min=1,max=100"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	_ "embed"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SchemaField describes a field of a model's JSON representation
+type SchemaField struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// SchemaChange lists the fields added in a schema version
+type SchemaChange struct {
+	Version string   `json:"version"`
+	Added   []string `json:"added"`
+}
+
+// userChangelogJSON records how the User JSON representation evolved, add an
+// entry whenever a field is added to User
+//
+//go:embed user_changelog.json
+var userChangelogJSON []byte
+
+// UserChangelog lists the User schema versions, oldest first
+var UserChangelog = func() []SchemaChange {
+	var changes []SchemaChange
+	if err := json.Unmarshal(userChangelogJSON, &changes); err != nil {
+		panic("models: invalid user_changelog.json: " + err.Error())
+	}
+	return changes
+}()
+
+// UserSchemaVersion is the current version of the User JSON representation
+var UserSchemaVersion = UserChangelog[len(UserChangelog)-1].Version
+
+// UserSchemaFields lists the fields of the User JSON representation,
+// including the computed fields added by MarshalJSON
+var UserSchemaFields = append(schemaFields(reflect.TypeFor[User]()), SchemaField{Name: "is_active", Type: "boolean"})
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// schemaFields describes the JSON-visible fields of struct type t
+func schemaFields(t reflect.Type) []SchemaField {
+	var fields []SchemaField
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldType, nullable := field.Type, false
+		if fieldType.Kind() == reflect.Pointer {
+			fieldType, nullable = fieldType.Elem(), true
+		}
+		fields = append(fields, SchemaField{Name: name, Type: schemaType(fieldType), Nullable: nullable})
+	}
+	return fields
+}
+
+// schemaType maps a Go type to its JSON type name
+func schemaType(t reflect.Type) string {
+	switch {
+	case t == timeType:
+		return "datetime"
+	case t.Kind() == reflect.Bool:
+		return "boolean"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return "integer"
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return "number"
+	case t.Kind() == reflect.String:
+		return "string"
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,41 @@
+package models
+
+import "gorm.io/gorm"
+
+// MigrateUserSearch creates the users_fts FTS5 table used for full-text search
+// and indexes any users that are not in it yet. It must run after the users
+// table has been migrated.
+func MigrateUserSearch(db *gorm.DB) error {
+	if err := db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(name, email)").Error; err != nil {
+		return err
+	}
+	return db.Exec(`INSERT INTO users_fts (rowid, name, email)
+		SELECT id, name, email FROM users
+		WHERE deleted_at IS NULL AND id NOT IN (SELECT rowid FROM users_fts)`).Error
+}
+
+// AfterCreate adds the user to the search index
+func (u *User) AfterCreate(tx *gorm.DB) error {
+	return tx.Exec("INSERT INTO users_fts (rowid, name, email) VALUES (?, ?, ?)", u.ID, u.Name, u.Email).Error
+}
+
+// AfterUpdate re-indexes the user from the stored row, so partial updates
+// don't leave stale or empty columns in the index
+func (u *User) AfterUpdate(tx *gorm.DB) error {
+	if u.ID == 0 {
+		return nil // batch update without a loaded model
+	}
+	if err := tx.Exec("DELETE FROM users_fts WHERE rowid = ?", u.ID).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`INSERT INTO users_fts (rowid, name, email)
+		SELECT id, name, email FROM users WHERE id = ? AND deleted_at IS NULL`, u.ID).Error
+}
+
+// AfterDelete removes the user from the search index
+func (u *User) AfterDelete(tx *gorm.DB) error {
+	if u.ID == 0 {
+		return nil
+	}
+	return tx.Exec("DELETE FROM users_fts WHERE rowid = ?", u.ID).Error
+}
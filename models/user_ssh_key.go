@@ -0,0 +1,45 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidSSHKey is returned when saving a key that is not a valid SSH public key
+var ErrInvalidSSHKey = errors.New("invalid SSH public key")
+
+type UserSSHKey struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	UserID      uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_user_ssh_keys_user_fingerprint"`
+	User        User      `json:"-"`
+	Name        string    `json:"name" gorm:"not null"`
+	PublicKey   string    `json:"public_key" gorm:"type:text;not null"`
+	Fingerprint string    `json:"fingerprint" gorm:"not null;uniqueIndex:idx_user_ssh_keys_user_fingerprint"`
+	AddedAt     time.Time `json:"added_at" gorm:"autoCreateTime"`
+}
+
+// BeforeSave fingerprints the key on every save
+func (k *UserSSHKey) BeforeSave(tx *gorm.DB) error {
+	return k.Normalize()
+}
+
+// Normalize validates the public key, rewrites it in the authorized_keys
+// format and computes its SHA256 fingerprint
+func (k *UserSSHKey) Normalize() error {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(k.PublicKey))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSSHKey, err)
+	}
+
+	k.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	if comment != "" {
+		k.PublicKey += " " + comment
+	}
+	k.Fingerprint = ssh.FingerprintSHA256(key)
+	return nil
+}
@@ -0,0 +1,8 @@
+package models
+
+type UserTag struct {
+	ID     uint   `json:"id" gorm:"primarykey"`
+	UserID uint   `json:"user_id" gorm:"not null;uniqueIndex:idx_user_tags_user_tag"`
+	User   User   `json:"-"`
+	Tag    string `json:"tag" gorm:"not null;uniqueIndex:idx_user_tags_user_tag;index"`
+}
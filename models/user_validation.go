@@ -0,0 +1,77 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidUser is returned when a user breaks a validation rule
+var ErrInvalidUser = errors.New("invalid user")
+
+// ReservedNames are user names that could be mistaken for the system or staff
+var ReservedNames = []string{"administrator", "anonymous", "null", "root", "system", "undefined"}
+
+// userValidator checks the binding tags of the user's fields
+var userValidator = func() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.SetTagName("binding")
+	return v
+}()
+
+// Validate checks the user against the binding tags of its fields and the
+// business rules every deployment shares, whoever creates or changes it.
+// Errors wrap ErrInvalidUser.
+func (u *User) Validate() error {
+	if err := userValidator.Struct(u); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidUser, err)
+	}
+	if strings.TrimSpace(u.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidUser)
+	}
+	if slices.Contains(ReservedNames, strings.ToLower(strings.TrimSpace(u.Name))) {
+		return fmt.Errorf("%w: name %q is reserved", ErrInvalidUser, u.Name)
+	}
+	if err := userValidator.Var(u.Email, "required,email"); err != nil {
+		return fmt.Errorf("%w: email must be an email address", ErrInvalidUser)
+	}
+	return nil
+}
+
+// BeforeCreate keeps invalid users out of the database even when they are
+// created without going through a controller
+func (u *User) BeforeCreate(tx *gorm.DB) error {
+	return u.Validate()
+}
+
+// UserPolicy holds the deployment-specific rules users are validated
+// against on top of User.Validate
+type UserPolicy struct {
+	// AllowedEmailDomains restricts emails to these domains, any domain is
+	// allowed when empty
+	AllowedEmailDomains []string
+}
+
+// Validate checks u against User.Validate and the policy
+func (p UserPolicy) Validate(u *User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	return p.CheckEmail(u.Email)
+}
+
+// CheckEmail checks that email may be given to a user under the policy
+func (p UserPolicy) CheckEmail(email string) error {
+	if len(p.AllowedEmailDomains) == 0 {
+		return nil
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	if !slices.ContainsFunc(p.AllowedEmailDomains, func(allowed string) bool { return strings.EqualFold(allowed, domain) }) {
+		return fmt.Errorf("%w: email domain %q is not allowed", ErrInvalidUser, domain)
+	}
+	return nil
+}
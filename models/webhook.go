@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Webhook is an endpoint notified about events, deliveries are signed with Secret
+type Webhook struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	URL       string    `json:"url" gorm:"not null"`
+	Secret    string    `json:"-" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
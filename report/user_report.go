@@ -0,0 +1,126 @@
+// Package report renders documents about users
+package report
+
+import (
+	"fmt"
+	"go-api/models"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Count is a labelled number in a report table
+type Count struct {
+	Label string
+	Count int64
+}
+
+// UserReport is the data rendered by WriteUserPDF
+type UserReport struct {
+	User models.User
+	// Activity counts the user's activities by action
+	Activity []Count
+	// Audit counts the user's audit log entries by action
+	Audit          []Count
+	CreatedAt      *time.Time
+	LastModifiedAt *time.Time
+	Devices        []models.UserDevice
+	GeneratedAt    time.Time
+}
+
+// timeLayout formats the times shown in reports
+const timeLayout = "2006-01-02 15:04 MST"
+
+// WriteUserPDF renders r as an A4 PDF document to w
+func WriteUserPDF(w io.Writer, r UserReport) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(fmt.Sprintf("User report %d", r.User.ID), true)
+	pdf.SetCreationDate(r.GeneratedAt)
+	// The core fonts only cover cp1252, translate UTF-8 text to it
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, tr("User report: "+r.User.DisplayName()), "", 1, "", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.CellFormat(0, 6, "Generated "+r.GeneratedAt.Format(timeLayout), "", 1, "", false, 0, "")
+
+	section(pdf, "Profile")
+	rows(pdf, tr, [][2]string{
+		{"ID", strconv.FormatUint(uint64(r.User.ID), 10)},
+		{"Name", r.User.Name},
+		{"Email", r.User.Email},
+		{"Role", r.User.Role},
+		{"Timezone", orNone(r.User.Timezone)},
+		{"Email verified", formatTime(r.User.EmailVerifiedAt)},
+		{"Created", r.User.CreatedAt.Format(timeLayout)},
+		{"Active", strconv.FormatBool(r.User.IsActive())},
+	})
+
+	section(pdf, "Activity")
+	rows(pdf, tr, countRows(r.Activity))
+
+	section(pdf, "Audit log")
+	rows(pdf, tr, append([][2]string{
+		{"First recorded", formatTime(r.CreatedAt)},
+		{"Last modified", formatTime(r.LastModifiedAt)},
+	}, countRows(r.Audit)...))
+
+	section(pdf, "Devices")
+	if len(r.Devices) == 0 {
+		rows(pdf, tr, [][2]string{{"Devices", "none"}})
+	}
+	for _, device := range r.Devices {
+		trust := "untrusted"
+		if device.Trusted {
+			trust = "trusted"
+		}
+		rows(pdf, tr, [][2]string{{
+			device.DeviceID,
+			fmt.Sprintf("%s, %s, last seen %s", orNone(device.DeviceName), trust, device.LastSeen.Format(timeLayout)),
+		}})
+	}
+
+	return pdf.Output(w)
+}
+
+func section(pdf *gofpdf.Fpdf, title string) {
+	pdf.Ln(4)
+	pdf.SetFont("Helvetica", "B", 13)
+	pdf.CellFormat(0, 8, title, "B", 1, "", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+}
+
+func rows(pdf *gofpdf.Fpdf, tr func(string) string, rows [][2]string) {
+	for _, row := range rows {
+		pdf.CellFormat(50, 6, tr(row[0]), "", 0, "", false, 0, "")
+		pdf.MultiCell(0, 6, tr(row[1]), "", "", false)
+	}
+}
+
+func countRows(counts []Count) [][2]string {
+	if len(counts) == 0 {
+		return [][2]string{{"Entries", "none"}}
+	}
+	rows := make([][2]string, len(counts))
+	for i, count := range counts {
+		rows[i] = [2]string{count.Label, strconv.FormatInt(count.Count, 10)}
+	}
+	return rows
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Format(timeLayout)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
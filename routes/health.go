@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"context"
+	"go-api/config"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthCheckTimeout bounds how long /healthz waits for the registered checks
+const healthCheckTimeout = 2 * time.Second
+
+// Health tracks whether the server has finished starting up and whether its
+// dependencies are still usable
+type Health struct {
+	ready    atomic.Bool
+	checkers config.CompositeHealthChecker
+}
+
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// Register adds a check run on every request once ready, call it before SetReady
+func (h *Health) Register(checker config.HealthChecker) {
+	h.checkers = append(h.checkers, checker)
+}
+
+// SetReady marks startup as complete
+func (h *Health) SetReady() {
+	h.ready.Store(true)
+}
+
+// Handle answers GET /healthz with 200 {"status": "ok"} once ready and
+// 503 {"status": "starting"} before. A failing registered check gets a
+// 503 {"status": "unhealthy"} with the reason.
+func (h *Health) Handle(c *gin.Context) {
+	if !h.ready.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+	if err := h.checkers.Check(ctx); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// NewStartupRouter creates the engine served while the server starts up. It
+// only answers /healthz, every other request gets a 503.
+func NewStartupRouter(health *Health) *gin.Engine {
+	r := gin.New()
+	r.GET("/healthz", health.Handle)
+	r.NoRoute(func(c *gin.Context) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is starting"})
+	})
+	return r
+}
+
+// SwitchHandler is an http.Handler whose handler can be replaced while serving,
+// so a server can accept connections before its routes are set up
+type SwitchHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func NewSwitchHandler(handler http.Handler) *SwitchHandler {
+	s := &SwitchHandler{}
+	s.Set(handler)
+	return s
+}
+
+// Set replaces the handler for subsequent requests
+func (s *SwitchHandler) Set(handler http.Handler) {
+	s.handler.Store(&handler)
+}
+
+func (s *SwitchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.handler.Load()).ServeHTTP(w, r)
+}
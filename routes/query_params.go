@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"encoding/json"
+	"go-api/docs"
+	"go-api/middleware"
+	"regexp"
+	"strings"
+)
+
+// swaggerPathParam matches {name} path parameters in the spec
+var swaggerPathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// KnownQueryParams lists the query parameters each /api/v1 route accepts,
+// as documented by its @Param annotations, for middleware.QueryParamLogger
+func KnownQueryParams() map[string]bool {
+	var spec struct {
+		BasePath string `json:"basePath"`
+		Paths    map[string]map[string]struct {
+			Parameters []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+		} `json:"paths"`
+	}
+	known := make(map[string]bool)
+	if err := json.Unmarshal([]byte(docs.SwaggerInfo.ReadDoc()), &spec); err != nil {
+		return known
+	}
+
+	for path, operations := range spec.Paths {
+		route := spec.BasePath + swaggerPathParam.ReplaceAllString(path, ":$1")
+		for method, operation := range operations {
+			method = strings.ToUpper(method)
+			known[middleware.KnownQueryParamKey(method, route, "")] = true
+			for _, param := range operation.Parameters {
+				if param.In == "query" {
+					known[middleware.KnownQueryParamKey(method, route, param.Name)] = true
+				}
+			}
+		}
+	}
+	return known
+}
@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteDoc describes a registered endpoint
+type RouteDoc struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Description string `json:"description"`
+}
+
+// RouteDescriptions holds the description listed by /api/v1/routes, keyed by "METHOD /path"
+var RouteDescriptions = map[string]string{
+	"GET /api/v1/routes":                                "List available endpoints",
+	"GET /api/v1/invites/:token":                        "Validate an invite",
+	"POST /api/v1/invites/:token/accept":                "Register with an invite",
+	"GET /api/v1/version":                               "Get the server version",
+	"GET /api/v1/schema/user":                           "Get the user JSON schema version",
+	"GET /api/v1/password-strength":                     "Score how guessable a password is",
+	"POST /api/v1/password-strength":                    "Score how guessable a password sent in the body is",
+	"GET /api/v1/users":                                 "Get all users",
+	"GET /api/v1/users/sync":                            "Sync users",
+	"GET /api/v1/users/search":                          "Search users",
+	"GET /api/v1/users/search-by-preference":            "Search users by preference",
+	"GET /api/v1/users/similar-by-embedding":            "Rank users by embedding similarity",
+	"GET /api/v1/users/nearby":                          "Find users registered near a location",
+	"GET /api/v1/users/graph":                           "Get users as a graph connected by shared email domains",
+	"GET /api/v1/users/confirm-email":                   "Confirm email change",
+	"GET /api/v1/users/:id":                             "Get user by ID",
+	"GET /api/v1/users/:id/audit-summary":               "Get user audit summary",
+	"GET /api/v1/users/:id/activity-heatmap":            "Get user activity heatmap",
+	"GET /api/v1/users/:id/diff":                        "Diff user versions",
+	"GET /api/v1/users/:id/similar":                     "Get similar users",
+	"GET /api/v1/users/:id/timezone":                    "Get user timezone",
+	"GET /api/v1/users/:id/feature-flags":               "Evaluate feature flags for a user",
+	"GET /api/v1/users/:id/mentions":                    "Get the audit log entries mentioning a user",
+	"GET /api/v1/users/:id/timeline":                    "Get user events as one feed, newest first",
+	"GET /api/v1/users/:id/data-export":                 "Download everything stored about a user as a ZIP",
+	"GET /api/v1/users/:id/metadata":                    "List user metadata",
+	"POST /api/v1/users/:id/metadata":                   "Set a user metadata value",
+	"DELETE /api/v1/users/:id/metadata/:key":            "Delete a user metadata value",
+	"GET /api/v1/users/:id/embedding":                   "Get user embedding",
+	"GET /api/v1/users/:id/consent":                     "Get user consent history",
+	"GET /api/v1/users/:id/consent/current":             "Get current user consent per type",
+	"POST /api/v1/users/:id/consent":                    "Record user consent",
+	"PUT /api/v1/users/:id/timezone":                    "Set user timezone",
+	"GET /api/v1/users/:id/preferences":                 "Get user preferences",
+	"PATCH /api/v1/users/:id/preferences":               "Update some user preferences",
+	"POST /api/v1/users":                                "Create a new user",
+	"POST /api/v1/users/status":                         "Get the status of many users",
+	"POST /api/v1/users/import/ndjson":                  "Import users from newline-delimited JSON",
+	"PUT /api/v1/users/:id":                             "Update user",
+	"DELETE /api/v1/users/:id":                          "Delete user",
+	"PUT /api/v1/users/:id/password":                    "Change password",
+	"POST /api/v1/users/:id/change-email":               "Request email change",
+	"POST /api/v1/users/:id/request-email-verification": "Email a one-time verification code",
+	"POST /api/v1/users/:id/resend-verification":        "Resend a verification code, 3 per hour",
+	"POST /api/v1/users/:id/verify-email":               "Verify email with a one-time code",
+	"GET /api/v1/users/:id/ssh-keys":                    "List user SSH keys",
+	"POST /api/v1/users/:id/ssh-keys":                   "Add user SSH key",
+	"DELETE /api/v1/users/:id/ssh-keys/:key_id":         "Delete user SSH key",
+	"POST /api/v1/users/:id/api-keys":                   "Create user API key",
+	"POST /api/v1/users/:id/api-keys/:key_id/rotate":    "Rotate user API key",
+	"GET /api/v1/users/:id/devices":                     "List the devices a user has used",
+	"PATCH /api/v1/users/:id/devices/:device_id":        "Trust or distrust a user device",
+	"DELETE /api/v1/users/:id/devices/:device_id":       "Revoke a user device",
+	"GET /api/v1/admin/stats":                           "Get service statistics",
+	"GET /api/v1/admin/users/by-role/:role":             "Get users by role",
+	"PUT /api/v1/admin/users/:id/role":                  "Set user role",
+	"POST /api/v1/admin/users/:id/clone":                "Clone user",
+	"GET /api/v1/admin/users/:id/impersonate":           "Impersonate user",
+	"GET /api/v1/admin/users/:id/audit.csv":             "Export a user audit log as CSV",
+	"GET /api/v1/admin/users/:id/ip-history":            "Get the IP addresses a user logged in from",
+	"GET /api/v1/admin/users/:id/ip-history/anomalies":  "Get user logins from unusual countries",
+	"GET /api/v1/admin/users/:id/dependencies":          "Get what a user owns or can access",
+	"POST /api/v1/admin/users/:id/notes":                "Create user note",
+	"GET /api/v1/admin/users/:id/notes":                 "List user notes",
+	"PATCH /api/v1/admin/users/:id/notes/:note_id":      "Edit user note",
+	"DELETE /api/v1/admin/users/:id/notes/:note_id":     "Delete user note",
+	"GET /api/v1/admin/users/:id/compliance":            "Get user consent and data retention status",
+	"POST /api/v1/admin/users/:id/gdpr-requests":        "Log a GDPR request of a user",
+	"PUT /api/v1/admin/users/:id/embedding":             "Set user embedding",
+	"GET /api/v1/admin/users/:id/report.pdf":            "Export a user report as PDF",
+	"GET /api/v1/admin/users/password-expired":          "List users with expired passwords",
+	"GET /api/v1/admin/users/top-active":                "Rank the most active users",
+	"GET /api/v1/admin/users/deleted":                   "List soft-deleted users",
+	"PATCH /api/v1/admin/users/bulk-update":             "Update matching users in bulk",
+	"POST /api/v1/admin/users/purge-deleted":            "Purge deleted users",
+	"POST /api/v1/admin/users/invite":                   "Invite someone to register",
+	"POST /api/v1/admin/exports":                        "Start a background user export",
+	"GET /api/v1/admin/exports/:job_id":                 "Get a user export status",
+	"GET /api/v1/admin/exports/:job_id/download":        "Download a finished user export",
+	"POST /api/v1/admin/feature-flags":                  "Create or replace a feature flag",
+	"POST /api/v1/admin/webhooks/:id/test":              "Send a test webhook delivery",
+	"GET /api/v1/analytics/users-by-country":            "Get users by country",
+	"GET /healthz":                                      "Report whether the server has started and its database answers",
+	"GET /metrics":                                      "Prometheus metrics",
+	"GET /swagger/*any":                                 "Swagger UI and spec",
+}
+
+// listRoutes godoc
+// @Summary List routes
+// @Description List every registered endpoint with a short description
+// @Tags meta
+// @Produce json
+// @Success 200 {array} routes.RouteDoc
+// @Router /routes [get]
+//
+// Routes are read when requested so those added after SetupRoutes are included.
+func listRoutes(r *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		routes := r.Routes()
+		docs := make([]RouteDoc, 0, len(routes))
+		for _, route := range routes {
+			docs = append(docs, RouteDoc{
+				Method:      route.Method,
+				Path:        route.Path,
+				Description: RouteDescriptions[route.Method+" "+route.Path],
+			})
+		}
+		c.JSON(http.StatusOK, docs)
+	}
+}
@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"go-api/middleware"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	sloggin "github.com/samber/slog-gin"
+)
+
+// RouterOption customizes the engine built by NewRouter
+type RouterOption func(cfg *routerConfig)
+
+type routerConfig struct {
+	logger   *slog.Logger
+	registry *middleware.Registry
+}
+
+// WithLogger sets the logger used by the recovery and logging middleware, slog.Default() otherwise
+func WithLogger(logger *slog.Logger) RouterOption {
+	return func(cfg *routerConfig) {
+		cfg.logger = logger
+	}
+}
+
+// WithMiddleware adds a global middleware, ordered among the defaults by priority
+func WithMiddleware(name string, priority int, fn gin.HandlerFunc) RouterOption {
+	return func(cfg *routerConfig) {
+		cfg.registry.Register(name, priority, fn)
+	}
+}
+
+// NewRouter creates an engine with the recovery, JSON error, request ID,
+// request start and logging middleware applied, followed by any added through WithMiddleware.
+// Unknown routes and methods are answered with JSON 404 and 405 responses.
+func NewRouter(opts ...RouterOption) *gin.Engine {
+	cfg := &routerConfig{logger: slog.Default(), registry: middleware.NewRegistry()}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	cfg.registry.Register("recovery", middleware.PriorityRecovery, middleware.RecoverWithSlog(cfg.logger))
+	cfg.registry.Register("json-errors", middleware.PriorityJSONErrors, middleware.JSONRecovery())
+	cfg.registry.Register("request-id", middleware.PriorityRequestID, middleware.RequestID())
+	cfg.registry.Register("request-start", middleware.PriorityRequestStart, middleware.RequestStartTime())
+	cfg.registry.Register("logging", middleware.PriorityLogging, sloggin.New(cfg.logger))
+
+	r := gin.New()
+	r.HandleMethodNotAllowed = true
+	cfg.registry.Apply(r)
+	r.NoRoute(middleware.JSONRecovery())
+	r.NoMethod(middleware.JSONRecovery())
+	cfg.logger.Debug("Middleware registered", "order", cfg.registry.Names())
+	return r
+}
@@ -2,20 +2,150 @@ package routes
 
 import (
 	"go-api/controllers"
+	"go-api/middleware"
+	"go-api/models"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(r *gin.Engine, userController *controllers.UserController) {
+// RouteOption registers a set of routes on the /api/v1 group
+type RouteOption func(r *gin.RouterGroup)
+
+// Cache policies of individual routes
+var (
+	// userCache lets clients reuse a fetched user for a minute, it holds personal data so CDNs must not
+	userCache = middleware.CachePolicy{MaxAge: time.Minute, Private: true}
+	// exportCache keeps user exports and data exports out of every cache
+	exportCache = middleware.CachePolicy{NoStore: true}
+	// passwordCache keeps password strength results, which reveal the password, out of every cache
+	passwordCache = middleware.CachePolicy{NoStore: true}
+)
+
+// SetupRoutes registers /api/v1/routes, which lists every endpoint with its
+// RouteDescriptions entry, and the routes of each option. It returns r for chaining.
+func SetupRoutes(r *gin.Engine, opts ...RouteOption) *gin.Engine {
 	api := r.Group("/api/v1")
-	{
+	for _, opt := range opts {
+		opt(api)
+	}
+	api.GET("/routes", listRoutes(r))
+	return r
+}
+
+// WithGroupMiddleware applies middleware to every /api/v1 route registered by
+// the options after it, so pass it first
+func WithGroupMiddleware(middleware ...gin.HandlerFunc) RouteOption {
+	return func(api *gin.RouterGroup) {
+		api.Use(middleware...)
+	}
+}
+
+func WithUserRoutes(userController *controllers.UserController) RouteOption {
+	return func(api *gin.RouterGroup) {
+		api.GET("/schema/user", userController.GetUserSchemaVersion)
+		api.GET("/password-strength", middleware.CacheControl(passwordCache), userController.GetUserPasswordStrength)
+		api.POST("/password-strength", middleware.CacheControl(passwordCache), userController.GetUserPasswordStrength)
+
+		invites := api.Group("/invites")
+		{
+			invites.GET("/:token", userController.GetInvite)
+			invites.POST("/:token/accept", userController.AcceptInvite)
+		}
+
 		users := api.Group("/users")
 		{
 			users.GET("", userController.GetUsers)
-			users.GET("/:id", userController.GetUser)
+			users.GET("/sync", userController.GetUsersModifiedSince)
+			users.GET("/search", userController.SearchUsers)
+			users.GET("/search-by-preference", userController.SearchUsersByPreference)
+			users.GET("/similar-by-embedding", userController.GetUsersSimilarByEmbedding)
+			users.GET("/nearby", userController.GetUsersByDistance)
+			users.GET("/graph", userController.GetUserGraph)
+			users.GET("/confirm-email", userController.ConfirmEmail)
+			users.GET("/:id", middleware.CacheControl(userCache), userController.GetUser)
+			users.GET("/:id/audit-summary", userController.GetAuditSummary)
+			users.GET("/:id/activity-heatmap", userController.GetUserActivityHeatmap)
+			users.GET("/:id/diff", userController.GetUserDiff)
+			users.GET("/:id/similar", userController.GetSimilarUsers)
+			users.GET("/:id/timezone", userController.GetUserTimezone)
+			users.GET("/:id/feature-flags", userController.GetUserFeatureFlags)
+			users.GET("/:id/mentions", userController.GetUserMentions)
+			users.GET("/:id/timeline", userController.GetUserTimeline)
+			users.GET("/:id/embedding", userController.GetUserEmbedding)
+			users.GET("/:id/metadata", userController.GetUserMetadata)
+			users.POST("/:id/metadata", userController.SetUserMetadata)
+			users.DELETE("/:id/metadata/:key", userController.DeleteUserMetadata)
+			users.GET("/:id/data-export", middleware.RequireSelfOrRole("id", models.RoleAdmin), middleware.CacheControl(exportCache), userController.GetUserDataExport)
+			users.GET("/:id/consent", userController.GetUserConsentHistory)
+			users.GET("/:id/consent/current", userController.GetUserCurrentConsent)
+			users.POST("/:id/consent", userController.RecordConsent)
 			users.POST("", userController.CreateUser)
+			users.POST("/status", userController.GetBulkUserStatus)
+			users.POST("/import/ndjson", userController.ImportUsersJSON)
 			users.PUT("/:id", userController.UpdateUser)
 			users.DELETE("/:id", userController.DeleteUser)
+			users.PUT("/:id/password", middleware.RequireSelfOrRole("id", models.RoleAdmin), middleware.RejectImpersonation(), middleware.RequireTrustedDevice(), userController.ChangePassword)
+			users.POST("/:id/change-email", middleware.RequireSelfOrRole("id", models.RoleAdmin), middleware.RejectImpersonation(), middleware.RequireTrustedDevice(), userController.ChangeEmail)
+			users.POST("/:id/request-email-verification", userController.RequestEmailVerification)
+			users.POST("/:id/resend-verification", userController.ResendVerificationEmail)
+			users.POST("/:id/verify-email", userController.VerifyEmail)
+			users.GET("/:id/ssh-keys", userController.ListSSHKeys)
+			users.POST("/:id/ssh-keys", userController.AddSSHKey)
+			users.DELETE("/:id/ssh-keys/:key_id", userController.DeleteSSHKey)
+			users.POST("/:id/api-keys", middleware.RequireSelfOrRole("id", models.RoleAdmin), middleware.RejectImpersonation(), middleware.RequireTrustedDevice(), userController.CreateAPIKey)
+			users.POST("/:id/api-keys/:key_id/rotate", middleware.RequireSelfOrRole("id", models.RoleAdmin), middleware.RejectImpersonation(), middleware.RequireTrustedDevice(), userController.RotateAPIKey)
+			users.GET("/:id/devices", userController.GetUserDevices)
+			users.PATCH("/:id/devices/:device_id", middleware.RequireTrustedDevice(), userController.UpdateUserDevice)
+			users.DELETE("/:id/devices/:device_id", middleware.RequireTrustedDevice(), userController.DeleteUserDevice)
+			users.PUT("/:id/timezone", userController.SetUserTimezone)
+			users.GET("/:id/preferences", userController.GetUserPreferences)
+			users.PATCH("/:id/preferences", userController.UpdateUserPreferences)
+		}
+	}
+}
+
+func WithAdminRoutes(userController *controllers.UserController) RouteOption {
+	return func(api *gin.RouterGroup) {
+		admin := api.Group("/admin")
+		{
+			admin.GET("/stats", middleware.RequireRole(models.RoleAdmin), userController.GetStats)
+			admin.GET("/users/by-role/:role", middleware.RequireRole(models.RoleAdmin), userController.GetUsersByRole)
+			admin.PUT("/users/:id/role", middleware.RequireRole(models.RoleAdmin), userController.SetUserRole)
+			admin.POST("/users/:id/clone", middleware.RequireRole(models.RoleAdmin), userController.CloneUser)
+			admin.GET("/users/:id/impersonate", middleware.RequireRole(models.RoleAdmin), userController.ImpersonateUser)
+			admin.GET("/users/:id/audit.csv", middleware.RequireRole(models.RoleAdmin), userController.GetUserAuditCSV)
+			admin.GET("/users/:id/ip-history", middleware.RequireRole(models.RoleAdmin), userController.GetUserIPHistory)
+			admin.GET("/users/:id/ip-history/anomalies", middleware.RequireRole(models.RoleAdmin), userController.GetUserIPHistoryAnomalies)
+			admin.GET("/users/:id/dependencies", middleware.RequireRole(models.RoleAdmin), userController.GetUserDependencyGraph)
+			admin.POST("/users/:id/notes", middleware.RequireRole(models.RoleAdmin, models.RoleSupport), userController.CreateUserNote)
+			admin.GET("/users/:id/notes", middleware.RequireRole(models.RoleAdmin, models.RoleSupport), userController.GetUserNotes)
+			admin.PATCH("/users/:id/notes/:note_id", middleware.RequireRole(models.RoleAdmin, models.RoleSupport), userController.UpdateUserNote)
+			admin.DELETE("/users/:id/notes/:note_id", middleware.RequireRole(models.RoleAdmin, models.RoleSupport), userController.DeleteUserNote)
+			admin.GET("/users/:id/compliance", middleware.RequireRole(models.RoleAdmin), userController.GetUserCompliance)
+			admin.POST("/users/:id/gdpr-requests", middleware.RequireRole(models.RoleAdmin), userController.RecordGDPRRequest)
+			admin.PUT("/users/:id/embedding", middleware.RequireRole(models.RoleAdmin), userController.SetUserEmbedding)
+			admin.GET("/users/:id/report.pdf", middleware.RequireRole(models.RoleAdmin), userController.GetUserReport)
+			admin.GET("/users/password-expired", middleware.RequireRole(models.RoleAdmin), userController.GetUsersWithExpiredPasswords)
+			admin.GET("/users/top-active", middleware.RequireRole(models.RoleAdmin), userController.GetTopActiveUsers)
+			admin.GET("/users/deleted", middleware.RequireRole(models.RoleAdmin), userController.GetUsersDeleted)
+			admin.POST("/users/purge-deleted", middleware.RequireRole(models.RoleAdmin), userController.PurgeDeletedUsers)
+			admin.POST("/users/invite", middleware.RequireRole(models.RoleAdmin), userController.GenerateInviteLink)
+			admin.PATCH("/users/bulk-update", middleware.RequireRole(models.RoleAdmin), userController.BulkUpdate)
+			admin.POST("/exports", middleware.RequireRole(models.RoleAdmin), userController.CreateExport)
+			admin.GET("/exports/:job_id", middleware.RequireRole(models.RoleAdmin), middleware.CacheControl(exportCache), userController.GetExport)
+			admin.GET("/exports/:job_id/download", middleware.RequireRole(models.RoleAdmin), middleware.CacheControl(exportCache), userController.DownloadExport)
+			admin.POST("/feature-flags", middleware.RequireRole(models.RoleAdmin), userController.SetFeatureFlag)
+			admin.POST("/webhooks/:id/test", middleware.RequireRole(models.RoleAdmin), userController.SendTestWebhook)
+		}
+	}
+}
+
+func WithAnalyticsRoutes(userController *controllers.UserController) RouteOption {
+	return func(api *gin.RouterGroup) {
+		analytics := api.Group("/analytics")
+		{
+			analytics.GET("/users-by-country", userController.GetUsersGeolocated)
 		}
 	}
 }
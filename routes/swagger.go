@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"encoding/json"
+	"go-api/docs"
+	"go-api/middleware"
+	"go-api/models"
+
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files"
+	ginSwagger "github.com/swaggo/gin-swagger"
+	"github.com/swaggo/swag"
+)
+
+// camelCaseInstance is the swag instance serving the spec with camelCase model properties
+const camelCaseInstance = "swagger-camel"
+
+func init() {
+	swag.Register(camelCaseInstance, camelCaseDoc{})
+}
+
+// SwaggerUIConfig keeps the API key or Bearer token entered in the Authorize dialog across page reloads
+var SwaggerUIConfig = &ginSwagger.Config{
+	URL:                      "doc.json",
+	DocExpansion:             "list",
+	InstanceName:             "swagger",
+	Title:                    "Swagger UI",
+	DefaultModelsExpandDepth: 1,
+	DeepLinking:              true,
+	PersistAuthorization:     true,
+}
+
+// SetupSwagger serves the Swagger UI and spec, describing models with keyCase JSON keys
+func SetupSwagger(r *gin.Engine, keyCase middleware.KeyCase) {
+	config := *SwaggerUIConfig
+	if keyCase == middleware.CamelCase {
+		config.InstanceName = camelCaseInstance
+	}
+	r.GET("/swagger/*any", ginSwagger.CustomWrapHandler(&config, swaggerFiles.Handler))
+}
+
+// camelCaseDoc is the generated spec with model property names converted to camelCase
+type camelCaseDoc struct{}
+
+func (camelCaseDoc) ReadDoc() string {
+	doc := docs.SwaggerInfo.ReadDoc()
+
+	var spec map[string]any
+	if err := json.Unmarshal([]byte(doc), &spec); err != nil {
+		return doc
+	}
+
+	definitions, _ := spec["definitions"].(map[string]any)
+	for _, definition := range definitions {
+		schema, ok := definition.(map[string]any)
+		if !ok {
+			continue
+		}
+		if properties, ok := schema["properties"].(map[string]any); ok {
+			renamed := make(map[string]any, len(properties))
+			for name, property := range properties {
+				renamed[middleware.ToCamelCase(name)] = property
+			}
+			schema["properties"] = renamed
+		}
+		if required, ok := schema["required"].([]any); ok {
+			for i, name := range required {
+				if name, ok := name.(string); ok {
+					required[i] = middleware.ToCamelCase(name)
+				}
+			}
+		}
+	}
+
+	converted, err := json.Marshal(spec)
+	if err != nil {
+		return doc
+	}
+	return string(converted)
+}
+
+// KnownJSONFields lists the JSON names of the documented model fields, the
+// properties camelCaseDoc renames, and the computed User fields, for
+// middleware.JSONKeyCase
+func KnownJSONFields() map[string]bool {
+	known := make(map[string]bool)
+	for _, field := range models.UserSchemaFields {
+		known[field.Name] = true
+	}
+
+	var spec struct {
+		Definitions map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal([]byte(docs.SwaggerInfo.ReadDoc()), &spec); err != nil {
+		return known
+	}
+	for _, definition := range spec.Definitions {
+		for name := range definition.Properties {
+			known[name] = true
+		}
+	}
+	return known
+}
@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VersionInfo identifies the running server build
+type VersionInfo struct {
+	Version   string `json:"version"`
+	BuildTime string `json:"build_time"`
+	GitSHA    string `json:"git_sha"`
+}
+
+// WithVersionRoutes registers GET /api/v1/version, answering with info
+func WithVersionRoutes(info VersionInfo) RouteOption {
+	return func(api *gin.RouterGroup) {
+		api.GET("/version", info.Handle)
+	}
+}
+
+// Handle godoc
+// @Summary Get server version
+// @Description Get the version, build time and git commit of the running server, also sent in the X-API-Version, X-Build-Time and X-Git-SHA headers of every response
+// @Tags version
+// @Produce json
+// @Success 200 {object} routes.VersionInfo
+// @Router /version [get]
+func (info VersionInfo) Handle(c *gin.Context) {
+	c.JSON(http.StatusOK, info)
+}
@@ -0,0 +1,51 @@
+package tests
+
+import (
+	"errors"
+	"go-api/controllers"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaseControllerParseID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	base := controllers.BaseController{Logger: setupTestLogger()}
+
+	parse := func(value string) (uint, bool, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/users/"+value, nil)
+		c.Params = gin.Params{{Key: "id", Value: value}}
+		id, ok := base.ParseID(c, "id")
+		return id, ok, w
+	}
+
+	id, ok, w := parse("42")
+	assert.True(t, ok)
+	assert.Equal(t, uint(42), id)
+	assert.Equal(t, http.StatusOK, w.Code) // nothing written
+
+	for _, value := range []string{"abc", "-1", "0", "1.5", ""} {
+		_, ok, w := parse(value)
+		assert.False(t, ok, value)
+		assert.Equal(t, http.StatusBadRequest, w.Code, value)
+		assert.JSONEq(t, `{"error":"Invalid ID"}`, w.Body.String(), value)
+	}
+}
+
+func TestBaseControllerRespondError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	base := controllers.BaseController{Logger: setupTestLogger()}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	base.RespondError(c, http.StatusConflict, errors.New("already exists"))
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error":"already exists"}`, w.Body.String())
+}
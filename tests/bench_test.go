@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-api/models"
+	"go-api/routes"
+	"go-api/testutil"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Run with: go test ./tests -run '^$' -bench GetUsers -benchmem
+
+func benchmarkGetUsers(b *testing.B, rows int) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB()
+	users := make([]models.User, rows)
+	for i := range users {
+		users[i] = models.User{Name: fmt.Sprintf("Bench User %d", i), Email: fmt.Sprintf("bench%d@example.com", i)}
+	}
+	if err := db.CreateInBatches(users, 500).Error; err != nil {
+		b.Fatal(err)
+	}
+
+	userController := setupTestController(db)
+	router := gin.New()
+	routes.SetupRoutes(router, routes.WithUserRoutes(userController))
+
+	get := func(b *testing.B, path string) {
+		b.ReportAllocs()
+		for b.Loop() {
+			// Bypass the response cache, every request hits the database
+			userController.Cache.InvalidatePattern("users:*")
+			if w := testutil.GET(router, path); w.Code != http.StatusOK {
+				b.Fatal(w.Code)
+			}
+		}
+	}
+
+	b.Run("all", func(b *testing.B) { get(b, "/api/v1/users") })
+	b.Run("limit=100", func(b *testing.B) { get(b, "/api/v1/users?limit=100") })
+
+	// The share of the full request spent encoding the response
+	b.Run("marshal", func(b *testing.B) {
+		var loaded []models.User
+		db.Find(&loaded)
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := json.Marshal(loaded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetUsers1K(b *testing.B)   { benchmarkGetUsers(b, 1_000) }
+func BenchmarkGetUsers10K(b *testing.B)  { benchmarkGetUsers(b, 10_000) }
+func BenchmarkGetUsers100K(b *testing.B) { benchmarkGetUsers(b, 100_000) }
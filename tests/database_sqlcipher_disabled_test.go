@@ -0,0 +1,19 @@
+//go:build !(sqlcipher && cgo)
+
+package tests
+
+import (
+	"go-api/config"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedDatabaseUnavailable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encrypted.db")
+	db, err := config.TryInitDB(config.DBConfig{Path: path, EncryptionKey: strings.Repeat("2b", 32)}, setupTestLogger())
+	assert.ErrorIs(t, err, config.ErrEncryptionUnavailable)
+	assert.Nil(t, db)
+}
@@ -0,0 +1,47 @@
+//go:build sqlcipher && cgo
+
+package tests
+
+import (
+	"go-api/config"
+	"go-api/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encrypted.db")
+	cfg := config.DBConfig{Path: path, EncryptionKey: strings.Repeat("2b", 32)}
+
+	db, err := config.TryInitDB(cfg, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, db.AutoMigrate(&models.Tenant{}))
+	assert.NoError(t, db.Create(&models.Tenant{Name: "Acme"}).Error)
+	assert.NoError(t, config.CloseDB(db, cfg))
+
+	// The file holds no plaintext SQLite header
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "SQLite format 3")
+
+	wrong := cfg
+	wrong.EncryptionKey = strings.Repeat("3c", 32)
+	db, err = config.TryInitDB(wrong, setupTestLogger())
+	assert.Error(t, err)
+	assert.Nil(t, db)
+
+	db, err = config.TryInitDB(cfg, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer config.CloseDB(db, cfg)
+	var tenant models.Tenant
+	assert.NoError(t, db.First(&tenant).Error)
+	assert.Equal(t, "Acme", tenant.Name)
+}
@@ -0,0 +1,400 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"go-api/config"
+	"go-api/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForeignKeysEnforced(t *testing.T) {
+	db := setupTestDB()
+	var enabled int
+	db.Raw("PRAGMA foreign_keys").Scan(&enabled)
+	assert.Equal(t, 1, enabled)
+
+	user := models.User{Name: "Tagged User", Email: "tagged@example.com"}
+	assert.NoError(t, db.Create(&user).Error)
+	assert.NoError(t, db.Create(&models.UserTag{UserID: user.ID, Tag: "admin"}).Error)
+
+	// A tag pointing at a user that does not exist must be rejected
+	err := db.Create(&models.UserTag{UserID: 999, Tag: "orphan"}).Error
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "FOREIGN KEY constraint failed")
+	}
+}
+
+func TestVacuumShrinksDatabase(t *testing.T) {
+	logger := setupTestLogger()
+	path := filepath.Join(t.TempDir(), "vacuum.db")
+	db, err := config.TryInitDB(config.DBConfig{Path: path}, logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, db.AutoMigrate(&models.User{}))
+	assert.NoError(t, models.MigrateUserSearch(db))
+
+	users := make([]models.User, 1000)
+	for i := range users {
+		users[i] = models.User{Name: strings.Repeat("x", 200), Email: fmt.Sprintf("user%d@example.com", i)}
+	}
+	assert.NoError(t, db.CreateInBatches(users, 100).Error)
+	assert.NoError(t, db.Unscoped().Where("1 = 1").Delete(&models.User{}).Error)
+
+	before, after, err := config.Vacuum(db, path)
+	assert.NoError(t, err)
+	assert.Less(t, after, before)
+}
+
+func TestAutoVacuumEnabled(t *testing.T) {
+	logger := setupTestLogger()
+	db, err := config.TryInitDB(config.DBConfig{Path: filepath.Join(t.TempDir(), "auto.db"), AutoVacuum: true}, logger)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var mode int
+	db.Raw("PRAGMA auto_vacuum").Scan(&mode)
+	assert.Equal(t, 2, mode) // INCREMENTAL
+}
+
+func TestTryInitDBBadPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "app.db")
+	db, err := config.TryInitDB(config.DBConfig{Path: path}, setupTestLogger())
+	assert.Error(t, err)
+	assert.Nil(t, db)
+
+	assert.Panics(t, func() { config.MustInitDB(config.DBConfig{Path: path}, setupTestLogger()) })
+}
+
+func TestDBMetricsCollector(t *testing.T) {
+	collector := config.NewDBMetricsCollector(setupTestDB())
+
+	registry := prometheus.NewPedanticRegistry()
+	assert.NoError(t, registry.Register(collector))
+
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+
+	names := make([]string, 0, len(families))
+	for _, family := range families {
+		names = append(names, family.GetName())
+		if family.GetName() == "db_open_connections" {
+			// setupTestDB keeps a single connection open
+			assert.Equal(t, 1.0, family.GetMetric()[0].GetGauge().GetValue())
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"db_open_connections",
+		"db_in_use_connections",
+		"db_idle_connections",
+		"db_wait_count_total",
+		"db_wait_duration_seconds_total",
+		"db_max_idle_closed_total",
+		"db_max_lifetime_closed_total",
+	}, names)
+}
+
+func TestSQLiteDSNBuild(t *testing.T) {
+	dsn := config.SQLiteDSN{Path: ":memory:", BusyTimeout: 1000}.Build()
+	assert.Equal(t, "file::memory:?_pragma=busy_timeout(1000)", dsn)
+
+	dsn = config.SQLiteDSN{Path: "app.db", BusyTimeout: 5000, CacheSize: -2000, ForeignKeys: true, JournalMode: "WAL"}.Build()
+	assert.Equal(t, "file:app.db?_pragma=busy_timeout(5000)&_pragma=cache_size(-2000)&_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)", dsn)
+}
+
+func TestTryInitDBWithDSN(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dsn.db")
+	dsn := config.SQLiteDSN{Path: path, BusyTimeout: 1000, JournalMode: "WAL"}.Build()
+	db, err := config.TryInitDB(config.DBConfig{Path: dsn}, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var busyTimeout int
+	var journalMode string
+	db.Raw("PRAGMA busy_timeout").Scan(&busyTimeout)
+	db.Raw("PRAGMA journal_mode").Scan(&journalMode)
+	assert.Equal(t, 1000, busyTimeout)
+	assert.Equal(t, "wal", journalMode)
+
+	_, _, err = config.Vacuum(db, dsn)
+	assert.NoError(t, err)
+}
+
+func TestEnsureIndexes(t *testing.T) {
+	db := setupTestDB()
+	assert.NoError(t, config.EnsureIndexes(db))
+	assert.NoError(t, config.EnsureIndexes(db))
+	assert.NoError(t, config.RunMigrations(db))
+
+	queryPlan := func(query string) string {
+		var plan []struct{ Detail string }
+		db.Raw("EXPLAIN QUERY PLAN " + query).Scan(&plan)
+		var details []string
+		for _, step := range plan {
+			details = append(details, step.Detail)
+		}
+		return strings.Join(details, "; ")
+	}
+
+	assert.Contains(t, queryPlan("SELECT * FROM users WHERE email = 'alice@example.com'"), "USING INDEX idx_users_email")
+	assert.Contains(t, queryPlan("SELECT * FROM users ORDER BY created_at DESC"), "idx_users_created_at")
+}
+
+func TestMigrateDown(t *testing.T) {
+	db, err := config.TryInitDB(config.DBConfig{Path: filepath.Join(t.TempDir(), "migrations.db")}, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, db.AutoMigrate(&models.User{}))
+	version := func() uint {
+		v, err := config.SchemaVersion(db)
+		assert.NoError(t, err)
+		return v
+	}
+
+	// Reading the version of a database never migrated leaves it untouched
+	assert.Equal(t, uint(0), version())
+	assert.False(t, db.Migrator().HasTable("schema_migrations"))
+
+	// Migrating up twice applies each migration once
+	assert.NoError(t, config.RunMigrations(db))
+	assert.NoError(t, config.RunMigrations(db))
+	assert.Equal(t, uint(1), version())
+	assert.True(t, db.Migrator().HasIndex("users", "idx_users_created_at"))
+
+	// Going past the initial schema rolls back nothing
+	assert.ErrorIs(t, config.MigrateDown(db, 2), config.ErrMigrateDownTooFar)
+	assert.Equal(t, uint(1), version())
+	assert.Error(t, config.MigrateDown(db, 0))
+
+	// Rolling back leaves what AutoMigrate created in place
+	assert.NoError(t, config.MigrateDown(db, 1))
+	assert.Equal(t, uint(0), version())
+	assert.False(t, db.Migrator().HasIndex("users", "idx_users_created_at"))
+	assert.True(t, db.Migrator().HasIndex("users", "idx_users_email"))
+	assert.ErrorIs(t, config.MigrateDown(db, 1), config.ErrMigrateDownTooFar)
+
+	assert.NoError(t, config.RunMigrations(db))
+	assert.Equal(t, uint(1), version())
+	assert.True(t, db.Migrator().HasIndex("users", "idx_users_created_at"))
+}
+
+func TestCheckpointTruncatesWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.db")
+	cfg := config.DBConfig{Path: config.SQLiteDSN{Path: path, JournalMode: "WAL"}.Build(), CheckpointOnClose: true}
+	db, err := config.TryInitDB(cfg, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, db.AutoMigrate(&models.User{}))
+	assert.NoError(t, models.MigrateUserSearch(db))
+
+	users := make([]models.User, 1000)
+	for i := range users {
+		users[i] = models.User{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@example.com", i)}
+	}
+	assert.NoError(t, db.CreateInBatches(users, 100).Error)
+
+	walSize := func() int64 {
+		info, err := os.Stat(path + "-wal")
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		return info.Size()
+	}
+	before := walSize()
+	assert.NoError(t, config.Checkpoint(db))
+	assert.Less(t, walSize(), before)
+
+	assert.NoError(t, config.CloseDB(db, cfg))
+	var count int64
+	reopened := config.MustInitDB(config.DBConfig{Path: path}, setupTestLogger())
+	reopened.Model(&models.User{}).Count(&count)
+	assert.Equal(t, int64(1000), count)
+}
+
+func TestWaitInitDBRetriesUntilAvailable(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mount")
+	path := filepath.Join(dir, "app.db")
+
+	// The directory, like a slow volume mount, appears after the first attempt
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		os.Mkdir(dir, 0o755)
+	}()
+
+	start := time.Now()
+	db, err := config.WaitInitDB(context.Background(), config.DBConfig{Path: path}, setupTestLogger(), 5*time.Second)
+	if assert.NoError(t, err) {
+		assert.GreaterOrEqual(t, time.Since(start), time.Second)
+		assert.NoError(t, db.Exec("SELECT 1").Error)
+	}
+}
+
+func TestWaitInitDBTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "app.db")
+	db, err := config.WaitInitDB(context.Background(), config.DBConfig{Path: path}, setupTestLogger(), 1500*time.Millisecond)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "after 2 attempts")
+	}
+	assert.Nil(t, db)
+}
+
+func TestMaxQueryDepth(t *testing.T) {
+	db, err := config.TryInitDB(config.DBConfig{Path: ":memory:", MaxQueryDepth: 3}, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var count int
+	err = db.Raw(`WITH RECURSIVE cnt(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM cnt) SELECT COUNT(*) FROM cnt`).Scan(&count).Error
+	assert.ErrorIs(t, err, config.ErrRecursiveQuery)
+	assert.ErrorIs(t, db.Exec("with\n  recursive r(n) AS (SELECT 1) SELECT * FROM r").Error, config.ErrRecursiveQuery)
+
+	err = db.Raw(`SELECT (SELECT (SELECT (SELECT 1)))`).Scan(&count).Error
+	assert.ErrorIs(t, err, config.ErrQueryTooDeep)
+	assert.ErrorContains(t, err, "4 levels of SELECT, at most 3 allowed")
+
+	// Quoted text and comments don't count, and plain CTEs are allowed
+	assert.NoError(t, db.Raw(`SELECT (SELECT (SELECT 1)) -- WITH RECURSIVE (SELECT (SELECT`).Scan(&count).Error)
+	assert.NoError(t, db.Raw(`SELECT COUNT(*) FROM (SELECT 'WITH RECURSIVE (SELECT (SELECT (SELECT' AS "with recursive")`).Scan(&count).Error)
+	assert.NoError(t, db.Raw(`WITH t(x) AS (SELECT 1) SELECT x FROM t`).Scan(&count).Error)
+	assert.Equal(t, 1, count)
+
+	// Unterminated quotes and comments don't end the check early
+	assert.ErrorIs(t, config.CheckQueryDepth(`SELECT (SELECT (SELECT (SELECT 1))) -- x`, 3), config.ErrQueryTooDeep)
+	assert.ErrorIs(t, config.CheckQueryDepth(`SELECT (SELECT (SELECT (SELECT 1))) /* x`, 3), config.ErrQueryTooDeep)
+	assert.ErrorIs(t, config.CheckQueryDepth(`SELECT (SELECT (SELECT (SELECT 'x`, 3), config.ErrQueryTooDeep)
+
+	// Depth counts nested SELECTs, not parentheses
+	assert.NoError(t, config.CheckQueryDepth(`SELECT ((((SELECT 1))))`, 2))
+	assert.ErrorContains(t, config.CheckQueryDepth(`SELECT ((((SELECT 1))))`, 1), "2 levels of SELECT")
+	assert.NoError(t, config.CheckQueryDepth(`SELECT (SELECT 1) + (SELECT 2) FROM (SELECT 1 UNION SELECT (2))`, 2))
+	assert.NoError(t, config.CheckQueryDepth(`SELECT * FROM users WHERE id IN (1, 2) AND (name = 'a' OR (email = 'b'))`, 1))
+
+	// Queries built by GORM are not inspected
+	assert.NoError(t, db.AutoMigrate(&models.User{}))
+	assert.NoError(t, db.Where("id IN (?)", db.Table("users").Select("id")).Find(&[]models.User{}).Error)
+}
+
+// flakyDriver fails to connect until failures is used up, like a database
+// container still starting
+type flakyDriver struct {
+	driver.Driver
+	failures atomic.Int32
+}
+
+func (d *flakyDriver) Open(name string) (driver.Conn, error) {
+	if d.failures.Add(-1) >= 0 {
+		return nil, errors.New("connection refused")
+	}
+	return d.Driver.Open(name)
+}
+
+var (
+	flaky         *flakyDriver
+	registerFlaky sync.Once
+)
+
+// flakySQLite registers a SQLite driver failing the next failures connections
+func flakySQLite(t *testing.T, failures int32) string {
+	registerFlaky.Do(func() {
+		base, err := sql.Open("sqlite", ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		flaky = &flakyDriver{Driver: base.Driver()}
+		sql.Register("flaky-sqlite", flaky)
+	})
+	flaky.failures.Store(failures)
+	return "flaky-sqlite"
+}
+
+func TestInitDBRetriesUntilConnected(t *testing.T) {
+	cfg := config.DBConfig{Path: ":memory:", DriverName: flakySQLite(t, 2), RetryAttempts: 5, RetryDelay: 10 * time.Millisecond}
+	db := config.MustInitDB(cfg, setupTestLogger())
+	assert.NoError(t, db.Exec("SELECT 1").Error)
+	assert.Less(t, flaky.failures.Load(), int32(0), "the third attempt connected")
+}
+
+func TestInitDBGivesUpAfterRetryAttempts(t *testing.T) {
+	cfg := config.DBConfig{Path: ":memory:", DriverName: flakySQLite(t, 5), RetryAttempts: 3, RetryDelay: 10 * time.Millisecond}
+	db, err := config.WaitInitDB(context.Background(), cfg, setupTestLogger(), 0)
+	assert.Nil(t, db)
+	assert.ErrorContains(t, err, "database unavailable after 3 attempts: connection refused")
+	assert.Equal(t, int32(2), flaky.failures.Load())
+
+	flakySQLite(t, 5)
+	assert.PanicsWithError(t, "database unavailable after 3 attempts: connection refused", func() { config.MustInitDB(cfg, setupTestLogger()) })
+}
+
+func TestGORMPrometheus(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	db, err := config.TryInitDB(config.DBConfig{Path: "file::memory:", Metrics: registry}, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, db.AutoMigrate(&models.Tenant{}))
+
+	tenant := models.Tenant{Name: "Metered", Slug: "metered"}
+	assert.NoError(t, db.Create(&tenant).Error)
+	assert.NoError(t, db.First(&models.Tenant{}, tenant.ID).Error)
+	assert.NoError(t, db.Model(&tenant).Update("name", "Still Metered").Error)
+	assert.NoError(t, db.Delete(&tenant).Error)
+
+	families, err := registry.Gather()
+	if !assert.NoError(t, err) {
+		return
+	}
+	counts := map[string]uint64{}
+	for _, family := range families {
+		if family.GetName() != "db_query_duration_seconds" {
+			continue
+		}
+		assert.Equal(t, dto.MetricType_HISTOGRAM, family.GetType())
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			counts[labels["table"]+" "+labels["operation"]] += metric.GetHistogram().GetSampleCount()
+		}
+	}
+	for _, key := range []string{"tenants insert", "tenants select", "tenants update", "tenants delete"} {
+		assert.NotZero(t, counts[key], key)
+	}
+}
+
+func TestDBHealthChecker(t *testing.T) {
+	db := setupTestDB()
+	checker := config.NewDBHealthChecker(db)
+	assert.NoError(t, checker.Check(context.Background()))
+
+	broken, err := config.TryInitDB(config.DBConfig{Path: "file::memory:"}, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+	sqlDB, _ := broken.DB()
+	sqlDB.Close()
+	brokenChecker := config.NewDBHealthChecker(broken)
+	assert.ErrorContains(t, brokenChecker.Check(context.Background()), "database is closed")
+
+	assert.NoError(t, config.CompositeHealthChecker{checker, checker}.Check(context.Background()))
+	assert.Error(t, config.CompositeHealthChecker{checker, brokenChecker}.Check(context.Background()))
+}
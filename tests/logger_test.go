@@ -0,0 +1,35 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-api/config"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupLoggerWithCaller(t *testing.T) {
+	var buf bytes.Buffer
+	logger := config.SetupLogger(&buf, "info", "json", true)
+	logger.Info("hello")
+
+	var record map[string]any
+	err := json.Unmarshal(buf.Bytes(), &record)
+	assert.NoError(t, err)
+
+	source, ok := record["source"].(map[string]any)
+	if assert.True(t, ok, "expected source in log record") {
+		file, _ := source["file"].(string)
+		assert.True(t, strings.HasSuffix(file, ".go"), "unexpected source file %q", file)
+	}
+}
+
+func TestSetupLoggerWithoutCaller(t *testing.T) {
+	var buf bytes.Buffer
+	logger := config.SetupLogger(&buf, "info", "json", false)
+	logger.Info("hello")
+
+	assert.NotContains(t, buf.String(), `"source"`)
+}
@@ -0,0 +1,840 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-api/config"
+	"go-api/middleware"
+	"go-api/models"
+	"go-api/routes"
+	"go-api/testutil"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoverWithSlog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	router := gin.New()
+	router.Use(middleware.RecoverWithSlog(logger))
+	router.GET("/panic", func(c *gin.Context) {
+		panic("something went wrong")
+	})
+
+	w := testutil.GET(router, "/panic")
+	testutil.AssertStatus(t, w, http.StatusInternalServerError)
+	assert.JSONEq(t, `{"error": "Internal server error"}`, w.Body.String())
+
+	var record map[string]any
+	err := json.Unmarshal(logs.Bytes(), &record)
+	assert.NoError(t, err)
+	assert.Equal(t, "panic recovered", record["msg"])
+	assert.Equal(t, "ERROR", record["level"])
+	assert.Equal(t, "something went wrong", record["error"])
+	assert.Equal(t, "/panic", record["path"])
+
+	stack, _ := record["stack"].(string)
+	assert.True(t, strings.Contains(stack, "goroutine"), "expected a stack trace")
+	assert.LessOrEqual(t, len(stack), 3<<10)
+}
+
+func TestRegistryAppliesByPriority(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var order []string
+	record := func(name string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			order = append(order, name)
+			c.Next()
+		}
+	}
+
+	registry := middleware.NewRegistry()
+	registry.Register("auth", middleware.PriorityAuth, record("auth"))
+	registry.Register("recovery", middleware.PriorityRecovery, record("recovery"))
+	registry.Register("logging", middleware.PriorityLogging, record("logging"))
+	registry.Register("request-id", middleware.PriorityRequestID, record("request-id"))
+	registry.Register("logging-extra", middleware.PriorityLogging, record("logging-extra"))
+
+	expected := []string{"recovery", "request-id", "logging", "logging-extra", "auth"}
+	assert.Equal(t, expected, registry.Names())
+
+	router := gin.New()
+	registry.Apply(router)
+	router.GET("/ping", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	testutil.GET(router, "/ping")
+
+	assert.Equal(t, expected, order)
+}
+
+func TestJSONKeyCaseCamel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB()
+	userController := setupTestController(db)
+
+	router := gin.New()
+	router.Use(middleware.JSONKeyCase(middleware.CamelCase, routes.KnownJSONFields()))
+	routes.SetupRoutes(router, routes.WithUserRoutes(userController))
+
+	// Request keys are converted back to the snake_case the models bind
+	w := testutil.POST(router, "/api/v1/users", map[string]any{
+		"name":        "Camel User",
+		"email":       "camel@example.com",
+		"preferences": map[string]any{"emailNotifications": true},
+	})
+	testutil.AssertStatus(t, w, http.StatusCreated)
+	lockedUntil := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, db.Model(&models.User{}).Where("email = ?", "camel@example.com").Update("locked_until", lockedUntil).Error)
+
+	w = testutil.GET(router, "/api/v1/users")
+	testutil.AssertStatus(t, w, http.StatusOK)
+
+	users := testutil.Decode[[]map[string]any](t, w)
+	if assert.Len(t, users, 1) {
+		for _, key := range []string{"createdAt", "updatedAt", "isActive", "lockedUntil"} {
+			assert.Contains(t, users[0], key)
+		}
+		assert.NotContains(t, users[0], "created_at")
+		assert.NotContains(t, users[0], "is_active")
+		assert.Equal(t, map[string]any{"emailNotifications": true}, users[0]["preferences"])
+	}
+
+	// Free-form map keys are not struct fields and keep their case
+	assert.NoError(t, db.Create(&models.FeatureFlag{Name: "new_dashboard", Enabled: true}).Error)
+	w = testutil.GET(router, fmt.Sprintf("/api/v1/users/%v/feature-flags", users[0]["id"]))
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Equal(t, map[string]bool{"new_dashboard": true}, testutil.Decode[map[string]bool](t, w))
+}
+
+func TestJSONKeyCaseStreamsOtherContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(middleware.JSONKeyCase(middleware.CamelCase, routes.KnownJSONFields()))
+	release := make(chan struct{})
+	router.GET("/export.csv", func(c *gin.Context) {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.String(http.StatusOK, "created_at\n")
+		c.Writer.Flush()
+		<-release
+		c.String(http.StatusOK, "2024-01-01\n")
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// The first row arrives while the handler is still running
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get(server.URL + "/export.csv")
+	if !assert.NoError(t, err) {
+		close(release)
+		return
+	}
+	defer resp.Body.Close()
+	header := make([]byte, len("created_at\n"))
+	_, err = io.ReadFull(resp.Body, header)
+	close(release)
+	assert.NoError(t, err)
+	assert.Equal(t, "created_at\n", string(header))
+
+	rest, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-01-01\n", string(rest))
+}
+
+func TestKeyCaseConversion(t *testing.T) {
+	assert.Equal(t, "userId", middleware.ToCamelCase("user_id"))
+	assert.Equal(t, "emailChangeExpiresAt", middleware.ToCamelCase("email_change_expires_at"))
+	assert.Equal(t, "name", middleware.ToCamelCase("name"))
+
+	assert.Equal(t, "user_id", middleware.ToSnakeCase("userId"))
+	assert.Equal(t, "user_id_list", middleware.ToSnakeCase("userIDList"))
+	assert.Equal(t, "name", middleware.ToSnakeCase("name"))
+}
+
+func TestNewRouterAppliesDefaultMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	router := routes.NewRouter(routes.WithLogger(logger), routes.WithMiddleware("body-hash", middleware.PriorityBodyHash, middleware.BodyHash()))
+	assert.Len(t, router.Handlers, 6) // recovery, json-errors, request-id, request-start, logging, body-hash
+
+	router.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	w := testutil.GET(router, "/panic")
+	testutil.AssertStatus(t, w, http.StatusInternalServerError)
+	assert.NotEmpty(t, w.Header().Get(middleware.RequestIDHeader))
+	assert.Contains(t, buf.String(), "panic recovered")
+
+	// An incoming request ID is kept
+	req := testutil.NewRequest("GET", "/panic", nil)
+	req.Header.Set(middleware.RequestIDHeader, "req-123")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "req-123", w.Header().Get(middleware.RequestIDHeader))
+}
+
+func TestDeprecationNotice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	userController := setupTestController(setupTestDB())
+	deadline := time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC)
+
+	router := routes.SetupRoutes(gin.New(),
+		routes.WithGroupMiddleware(middleware.DeprecationNotice(deadline, "https://api.example.com/api/v2")),
+		routes.WithUserRoutes(userController),
+	)
+
+	w := testutil.GET(router, "/api/v1/users")
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Equal(t, "2020-06-30T00:00:00Z", w.Header().Get("Deprecation"))
+	assert.Equal(t, "2020-06-30T00:00:00Z", w.Header().Get("Sunset"))
+	assert.Equal(t, `<https://api.example.com/api/v2>; rel="successor-version"`, w.Header().Get("Link"))
+}
+
+func TestResponseBodyLogger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	router := gin.New()
+	router.Use(middleware.ResponseBodyLogger(logger, 16))
+	router.GET("/short", func(c *gin.Context) { c.String(http.StatusOK, "hello") })
+	router.GET("/long", func(c *gin.Context) { c.String(http.StatusOK, strings.Repeat("x", 100)) })
+
+	logged := func(path string) map[string]any {
+		buf.Reset()
+		w := testutil.GET(router, path)
+		testutil.AssertStatus(t, w, http.StatusOK)
+
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+		return record
+	}
+
+	record := logged("/short")
+	assert.Equal(t, "Response body", record["msg"])
+	assert.Equal(t, "hello", record["body"])
+	assert.Equal(t, float64(http.StatusOK), record["status"])
+
+	// The client still gets the full body, only the log is truncated
+	w := testutil.GET(router, "/long")
+	assert.Len(t, w.Body.String(), 100)
+	assert.Equal(t, strings.Repeat("x", 16)+"[truncated]", logged("/long")["body"])
+}
+
+// slowReader returns its data one byte at a time with a delay before each read
+type slowReader struct {
+	data  []byte
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(p[:1], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestResponseTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(middleware.ResponseTimeout(50 * time.Millisecond))
+
+	handlerDone := make(chan struct{})
+	router.GET("/slow", func(c *gin.Context) {
+		defer close(handlerDone)
+		select {
+		case <-c.Request.Context().Done():
+		case <-time.After(time.Second):
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "late"})
+	})
+	router.POST("/upload", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+	router.GET("/stream", func(c *gin.Context) {
+		c.Header("Content-Type", "application/x-ndjson")
+		for i := range 3 {
+			fmt.Fprintf(c.Writer, "{\"chunk\":%d}\n", i)
+			c.Writer.Flush()
+			time.Sleep(40 * time.Millisecond)
+		}
+	})
+
+	w := testutil.GET(router, "/slow")
+	testutil.AssertStatus(t, w, http.StatusServiceUnavailable)
+	assert.JSONEq(t, `{"error":"Response timeout"}`, w.Body.String())
+	<-handlerDone // the handler saw the cancelled context and finished before the middleware returned
+
+	// Uploading takes longer than the timeout, but the clock only starts once the body is in
+	req := httptest.NewRequest("POST", "/upload", &slowReader{data: []byte("0123456789"), delay: 10 * time.Millisecond})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Equal(t, "0123456789", w.Body.String())
+
+	// Streamed responses are sent as they are written and can outlast the timeout once started
+	w = testutil.GET(router, "/stream")
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"chunk\":0}\n{\"chunk\":1}\n{\"chunk\":2}\n", w.Body.String())
+
+	// Handlers ignoring the cancelled context don't hold back the 503
+	release := make(chan struct{})
+	router.GET("/stuck", func(c *gin.Context) {
+		<-release
+		c.JSON(http.StatusOK, gin.H{"status": "late"})
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+	defer close(release)
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get(server.URL + "/stuck")
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		body := make([]byte, len(`{"error":"Response timeout"}`))
+		_, err = io.ReadFull(resp.Body, body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"error":"Response timeout"}`, string(body))
+	}
+}
+
+func TestJSONRecovery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := routes.NewRouter(routes.WithLogger(setupTestLogger()))
+	router.GET("/plain-error", func(c *gin.Context) {
+		c.String(http.StatusBadRequest, "something went wrong\n")
+	})
+	router.GET("/ok", func(c *gin.Context) {
+		c.String(http.StatusOK, "fine")
+	})
+
+	for path, expected := range map[string]struct {
+		method string
+		code   int
+		body   string
+	}{
+		"/missing":     {"GET", http.StatusNotFound, `{"error":"not found"}`},
+		"/ok":          {"POST", http.StatusMethodNotAllowed, `{"error":"method not allowed"}`},
+		"/plain-error": {"GET", http.StatusBadRequest, `{"error":"something went wrong"}`},
+	} {
+		w := testutil.Do(router, expected.method, path, nil)
+		assert.Equal(t, expected.code, w.Code, path)
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"), path)
+		assert.JSONEq(t, expected.body, w.Body.String(), path)
+	}
+
+	// Successful responses pass through untouched
+	w := testutil.GET(router, "/ok")
+	assert.Equal(t, "fine", w.Body.String())
+}
+
+func TestSlowRequestLogger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	router := gin.New()
+	router.Use(middleware.SlowRequestLogger(500*time.Millisecond, logger))
+	router.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(config.ContextWithUserID(c.Request.Context(), 42))
+		c.Next()
+	})
+	router.GET("/fast", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/slow", func(c *gin.Context) {
+		time.Sleep(600 * time.Millisecond)
+		c.Status(http.StatusAccepted)
+	})
+
+	testutil.AssertStatus(t, testutil.GET(router, "/fast"), http.StatusOK)
+	assert.Empty(t, buf.String())
+
+	testutil.AssertStatus(t, testutil.GET(router, "/slow"), http.StatusAccepted)
+	var record map[string]any
+	if assert.NoError(t, json.Unmarshal(buf.Bytes(), &record)) {
+		assert.Equal(t, "WARN", record["level"])
+		assert.Equal(t, "Slow request", record["msg"])
+		assert.Equal(t, "/slow", record["path"])
+		assert.Equal(t, "GET", record["method"])
+		assert.Equal(t, float64(http.StatusAccepted), record["status"])
+		assert.Equal(t, float64(42), record["user_id"])
+		assert.GreaterOrEqual(t, record["duration_ms"], float64(600))
+	}
+}
+
+func TestRequestStartTime(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	router := routes.NewRouter(routes.WithLogger(logger))
+	var queueTime time.Duration
+	router.GET("/ping", func(c *gin.Context) {
+		queueTime = middleware.QueueTime(c)
+		c.Status(http.StatusOK)
+	})
+
+	loggedQueueTime := func(header string) float64 {
+		buf.Reset()
+		req := testutil.NewRequest("GET", "/ping", nil)
+		if header != "" {
+			req.Header.Set(middleware.RequestStartHeader, header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		testutil.AssertStatus(t, w, http.StatusOK)
+
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+		return record["queue_time_ms"].(float64)
+	}
+
+	sent := time.Now().Add(-200 * time.Millisecond)
+	assert.InDelta(t, 200, loggedQueueTime(fmt.Sprintf("t=%d", sent.UnixMilli())), 50)
+	assert.InDelta(t, 200*time.Millisecond, queueTime, float64(50*time.Millisecond))
+
+	// Nginx sends seconds with a millisecond fraction
+	sent = time.Now().Add(-200 * time.Millisecond)
+	assert.InDelta(t, 200, loggedQueueTime(fmt.Sprintf("t=%d.%03d", sent.Unix(), sent.Nanosecond()/1e6)), 50)
+
+	assert.Zero(t, loggedQueueTime(""))
+	assert.Zero(t, queueTime)
+	assert.Zero(t, loggedQueueTime("t=soon"))
+}
+
+func TestStartupHealthCheck(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	health := routes.NewHealth()
+	handler := routes.NewSwitchHandler(routes.NewStartupRouter(health))
+
+	w := testutil.GET(handler, "/healthz")
+	testutil.AssertStatus(t, w, http.StatusServiceUnavailable)
+	assert.Equal(t, "starting", testutil.Decode[map[string]string](t, w)["status"])
+	testutil.AssertStatus(t, testutil.GET(handler, "/api/v1/users"), http.StatusServiceUnavailable)
+
+	// Once the database is up the API takes over
+	router := setupTestRouter()
+	router.GET("/healthz", health.Handle)
+	handler.Set(router)
+	health.SetReady()
+
+	w = testutil.GET(handler, "/healthz")
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Equal(t, "ok", testutil.Decode[map[string]string](t, w)["status"])
+	testutil.AssertStatus(t, testutil.GET(handler, "/api/v1/users"), http.StatusOK)
+}
+
+func TestHealthReportsBrokenDatabase(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, err := config.TryInitDB(config.DBConfig{Path: "file::memory:"}, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+	health := routes.NewHealth()
+	health.Register(config.NewDBHealthChecker(db))
+	health.SetReady()
+	router := gin.New()
+	router.GET("/healthz", health.Handle)
+
+	testutil.AssertStatus(t, testutil.GET(router, "/healthz"), http.StatusOK)
+
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+	w := testutil.GET(router, "/healthz")
+	testutil.AssertStatus(t, w, http.StatusServiceUnavailable)
+	assert.Equal(t, "unhealthy", testutil.Decode[map[string]string](t, w)["status"])
+}
+
+func TestSQLiteRateLimiterStoreSurvivesRestart(t *testing.T) {
+	cfg := config.DBConfig{Path: config.SQLiteDSN{Path: filepath.Join(t.TempDir(), "limits.db"), BusyTimeout: 5000}.Build()}
+	db, err := config.TryInitDB(cfg, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, db.AutoMigrate(&models.RateLimit{}))
+
+	store := middleware.NewSQLiteRateLimiterStore(db, setupTestLogger())
+	for range 2 {
+		allowed, _ := store.Allow("203.0.113.7", 0.01, 2)
+		assert.True(t, allowed)
+	}
+	allowed, retryAfter := store.Allow("203.0.113.7", 0.01, 2)
+	assert.False(t, allowed)
+	assert.Greater(t, retryAfter, time.Duration(0))
+
+	// A restart starts from the persisted bucket instead of a full one
+	assert.NoError(t, config.CloseDB(db, cfg))
+	db, err = config.TryInitDB(cfg, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+	restarted := middleware.NewSQLiteRateLimiterStore(db, setupTestLogger())
+	allowed, retryAfter = restarted.Allow("203.0.113.7", 0.01, 2)
+	assert.False(t, allowed)
+	assert.Greater(t, retryAfter, time.Duration(0))
+
+	allowed, _ = restarted.Allow("198.51.100.1", 0.01, 2)
+	assert.True(t, allowed, "other keys have their own bucket")
+
+	// Concurrent checks never hand out more tokens than the burst
+	var granted atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := restarted.Allow("192.0.2.50", 0.01, 5); ok {
+				granted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(5), granted.Load())
+}
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(middleware.RateLimit(middleware.NewMemoryRateLimiterStore(), 0.5, 1))
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	testutil.AssertStatus(t, testutil.GET(router, "/ping"), http.StatusOK)
+
+	w := testutil.GET(router, "/ping")
+	testutil.AssertStatus(t, w, http.StatusTooManyRequests)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+}
+
+func TestQueryParamLogger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	userController := setupTestController(setupTestDB())
+	router := routes.SetupRoutes(routes.NewRouter(
+		routes.WithLogger(setupTestLogger()),
+		routes.WithMiddleware("query-params", middleware.PriorityLogging, middleware.QueryParamLogger(routes.KnownQueryParams(), logger)),
+	), routes.WithUserRoutes(userController))
+	router.GET("/undocumented", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	testutil.AssertStatus(t, testutil.GET(router, "/api/v1/users?page=1&page_size=10"), http.StatusOK)
+	testutil.AssertStatus(t, testutil.GET(router, "/api/v1/users/search?q=jane"), http.StatusOK)
+	testutil.AssertStatus(t, testutil.GET(router, "/api/v1/users?meta[plan]=enterprise"), http.StatusOK)
+	testutil.AssertStatus(t, testutil.GET(router, "/undocumented?anything=1"), http.StatusOK)
+	assert.Empty(t, buf.String())
+
+	testutil.AssertStatus(t, testutil.GET(router, "/api/v1/users?unknown_param=x&page=1"), http.StatusOK)
+	var record map[string]any
+	if assert.NoError(t, json.Unmarshal(buf.Bytes(), &record)) {
+		assert.Equal(t, "WARN", record["level"])
+		assert.Equal(t, "unexpected_query_param", record["msg"])
+		assert.Equal(t, "unknown_param", record["param"])
+		assert.Equal(t, "/api/v1/users", record["path"])
+	}
+
+	// Parameters are known per route, q is only accepted by search
+	buf.Reset()
+	testutil.AssertStatus(t, testutil.GET(router, "/api/v1/users/1?q=jane"), http.StatusNotFound)
+	assert.Contains(t, buf.String(), `"path":"/api/v1/users/:id"`)
+}
+
+func TestPriorityQueueServesHighPriorityFirst(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var mu sync.Mutex
+	var order []string
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if c.GetHeader("X-Test-Admin") != "" {
+			c.Request = c.Request.WithContext(config.ContextWithRole(c.Request.Context(), models.RoleAdmin))
+		}
+	})
+	router.Use(middleware.PriorityQueue(middleware.PriorityPools(ctx, 2, 1, 3)))
+	router.GET("/work/:name", func(c *gin.Context) {
+		mu.Lock()
+		order = append(order, c.Param("name"))
+		mu.Unlock()
+		if c.Param("name") == "blocker" {
+			close(started)
+			<-release
+		}
+		c.Status(http.StatusOK)
+	})
+
+	request := func(name, priority string) *httptest.ResponseRecorder {
+		req := testutil.NewRequest(http.MethodGet, "/work/"+name, nil)
+		if priority != "" {
+			req.Header.Set(middleware.PriorityHeader, priority)
+			req.Header.Set("X-Test-Admin", "true")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	run := func(name string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testutil.AssertStatus(t, request(name, ""), http.StatusOK)
+		}()
+	}
+
+	// Saturate the low priority worker and fill its queue
+	run("blocker")
+	<-started
+	for i := range 3 {
+		run(fmt.Sprintf("low%d", i))
+	}
+	time.Sleep(50 * time.Millisecond)
+	testutil.AssertStatus(t, request("rejected", ""), http.StatusServiceUnavailable)
+
+	begin := time.Now()
+	testutil.AssertStatus(t, request("high", "high"), http.StatusOK)
+	assert.Less(t, time.Since(begin), time.Second)
+
+	close(release)
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"blocker", "high"}, order[:2])
+	assert.ElementsMatch(t, []string{"low0", "low1", "low2"}, order[2:])
+}
+
+func TestPriorityQueueRoutesByCaller(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tag := func(name string) gin.HandlerFunc {
+		return func(c *gin.Context) { c.Header("X-Pool", name) }
+	}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		ctx := c.Request.Context()
+		switch c.GetHeader("X-Test-Caller") {
+		case "admin":
+			ctx = config.ContextWithRole(config.ContextWithUserID(ctx, 1), models.RoleAdmin)
+		case "api-key":
+			ctx = config.ContextWithRole(config.ContextWithAPIKeyID(config.ContextWithUserID(ctx, 2), 1), models.RoleUser)
+		case "user":
+			ctx = config.ContextWithRole(config.ContextWithUserID(ctx, 3), models.RoleUser)
+		}
+		c.Request = c.Request.WithContext(ctx)
+	})
+	router.Use(middleware.PriorityQueue(tag("high"), tag("low")))
+	router.GET("/work", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, tc := range []struct{ caller, priority, pool string }{
+		{"admin", "high", "high"},
+		{"api-key", "high", "high"},
+		{"admin", "", "low"},
+		{"admin", "HIGH", "low"},
+		{"api-key", "low", "low"},
+		{"user", "high", "low"},
+		{"", "high", "low"},
+	} {
+		req := testutil.NewRequest(http.MethodGet, "/work", nil)
+		req.Header.Set("X-Test-Caller", tc.caller)
+		req.Header.Set(middleware.PriorityHeader, tc.priority)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tc.pool, w.Header().Get("X-Pool"), "%q caller, X-Priority: %q", tc.caller, tc.priority)
+	}
+}
+
+func TestPriorityPoolsStopWithContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	router := gin.New()
+	router.Use(middleware.PriorityQueue(middleware.PriorityPools(ctx, 1, 0, 1)))
+	router.GET("/work/:name", func(c *gin.Context) {
+		if c.Param("name") == "blocker" {
+			close(started)
+			<-release
+		}
+		c.Status(http.StatusOK)
+	})
+
+	results := make(chan *httptest.ResponseRecorder, 2)
+	request := func(name string) {
+		go func() {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, testutil.NewRequest(http.MethodGet, "/work/"+name, nil))
+			results <- w
+		}()
+	}
+	request("blocker")
+	<-started
+	request("waiting")
+	time.Sleep(50 * time.Millisecond)
+
+	// Waiting requests are turned away once the dispatcher stops
+	cancel()
+	select {
+	case w := <-results:
+		testutil.AssertStatus(t, w, http.StatusServiceUnavailable)
+		assert.Equal(t, "Server shutting down", testutil.Decode[map[string]string](t, w)["error"])
+	case <-time.After(time.Second):
+		t.Fatal("waiting request was not released on shutdown")
+	}
+
+	// Running requests finish without a dispatcher to hand their slot to
+	close(release)
+	select {
+	case w := <-results:
+		testutil.AssertStatus(t, w, http.StatusOK)
+	case <-time.After(time.Second):
+		t.Fatal("running request blocked after shutdown")
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	info := routes.VersionInfo{Version: "1.0.0", BuildTime: "2026-01-02T15:04:05Z", GitSHA: "3ed2563"}
+	router := routes.SetupRoutes(routes.NewRouter(
+		routes.WithLogger(setupTestLogger()),
+		routes.WithMiddleware("api-version", middleware.PriorityRequestID, middleware.APIVersion(info.Version, info.BuildTime, info.GitSHA)),
+	), routes.WithVersionRoutes(info))
+
+	for _, path := range []string{"/api/v1/version", "/missing"} {
+		w := testutil.GET(router, path)
+		assert.Equal(t, "1.0.0", w.Header().Get(middleware.APIVersionHeader), path)
+		assert.Equal(t, "2026-01-02T15:04:05Z", w.Header().Get(middleware.BuildTimeHeader), path)
+		assert.Equal(t, "3ed2563", w.Header().Get(middleware.GitSHAHeader), path)
+	}
+
+	w := testutil.GET(router, "/api/v1/version")
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Equal(t, info, testutil.Decode[routes.VersionInfo](t, w))
+}
+
+func TestBodyHash(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(middleware.BodyHash())
+	echo := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	}
+	router.POST("/upload", echo)
+	router.GET("/upload", echo)
+
+	send := func(method, body, hash string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/upload", strings.NewReader(body))
+		req.Header.Set(middleware.ContentSHA256Header, hash)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	body := `{"name":"Test User"}`
+	sum := sha256.Sum256([]byte(body))
+	hash := hex.EncodeToString(sum[:])
+
+	w := send(http.MethodPost, body, hash)
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Equal(t, body, w.Body.String(), "handlers still get the body")
+	testutil.AssertStatus(t, send(http.MethodPost, body, strings.ToUpper(hash)), http.StatusOK)
+
+	testutil.AssertStatus(t, send(http.MethodPost, `{"name":"Tampered"}`, hash), http.StatusBadRequest)
+	testutil.AssertStatus(t, send(http.MethodPost, body, "not-hex"), http.StatusBadRequest)
+	testutil.AssertStatus(t, send(http.MethodPost, body, hash[:32]), http.StatusBadRequest)
+
+	// Requests without the header, and methods without bodies, are not checked
+	testutil.AssertStatus(t, testutil.POST(router, "/upload", body), http.StatusOK)
+	testutil.AssertStatus(t, send(http.MethodGet, "", hash), http.StatusOK)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const threshold = 3
+	status := http.StatusInternalServerError
+	calls := 0
+	router := gin.New()
+	router.Use(middleware.CircuitBreaker(threshold, 100*time.Millisecond))
+	router.GET("/users", func(c *gin.Context) {
+		calls++
+		c.JSON(status, gin.H{})
+	})
+
+	// Client errors don't count towards the threshold
+	status = http.StatusNotFound
+	testutil.AssertStatus(t, testutil.GET(router, "/users"), http.StatusNotFound)
+
+	status = http.StatusInternalServerError
+	for range threshold {
+		testutil.AssertStatus(t, testutil.GET(router, "/users"), http.StatusInternalServerError)
+	}
+	w := testutil.GET(router, "/users")
+	testutil.AssertStatus(t, w, http.StatusServiceUnavailable)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, threshold+1, calls, "an open breaker doesn't call the handler")
+
+	// A failing probe opens the breaker again
+	time.Sleep(150 * time.Millisecond)
+	testutil.AssertStatus(t, testutil.GET(router, "/users"), http.StatusInternalServerError)
+	testutil.AssertStatus(t, testutil.GET(router, "/users"), http.StatusServiceUnavailable)
+
+	// A successful probe closes it
+	time.Sleep(150 * time.Millisecond)
+	status = http.StatusOK
+	testutil.AssertStatus(t, testutil.GET(router, "/users"), http.StatusOK)
+	status = http.StatusInternalServerError
+	for range threshold {
+		testutil.AssertStatus(t, testutil.GET(router, "/users"), http.StatusInternalServerError)
+	}
+	testutil.AssertStatus(t, testutil.GET(router, "/users"), http.StatusServiceUnavailable)
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"fmt"
+	"go-api/controllers"
+	"go-api/routes"
+	"go-api/testutil"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Run with: go test ./tests -run '^$' -bench Pagination -benchtime 200x
+
+// seedUsers bulk inserts n users directly, skipping the model hooks
+func seedUsers(b *testing.B, n int) (http.Handler, *controllers.UserController) {
+	b.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB()
+	err := db.Exec(`WITH RECURSIVE seq(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM seq WHERE i < ?)
+		INSERT INTO users (name, role, email, created_at, updated_at)
+		SELECT 'Bench User', 'user', 'bench' || i || '@example.com', datetime('now'), datetime('now') FROM seq`, n).Error
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	userController := setupTestController(db)
+	router := gin.New()
+	routes.SetupRoutes(router, routes.WithUserRoutes(userController))
+	return router, userController
+}
+
+func BenchmarkPagination(b *testing.B) {
+	const pageSize = 20
+
+	for _, rows := range []int{100_000, 500_000, 1_000_000} {
+		router, userController := seedUsers(b, rows)
+		// Read the page in the middle of the table, past half of the rows
+		middle := rows / 2
+
+		b.Run(fmt.Sprintf("offset/%d", rows), func(b *testing.B) {
+			path := fmt.Sprintf("/api/v1/users?page=%d&page_size=%d", middle/pageSize+1, pageSize)
+			for b.Loop() {
+				// Bypass the response cache, every request hits the database
+				userController.Cache.InvalidatePattern("users:*")
+				w := testutil.GET(router, path)
+				if w.Code != http.StatusOK {
+					b.Fatal(w.Code)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("keyset/%d", rows), func(b *testing.B) {
+			path := fmt.Sprintf("/api/v1/users?after_id=%d&limit=%d", middle, pageSize)
+			for b.Loop() {
+				w := testutil.GET(router, path)
+				if w.Code != http.StatusOK {
+					b.Fatal(w.Code)
+				}
+			}
+		})
+	}
+}
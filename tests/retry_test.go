@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"go-api/config"
+	"go-api/models"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestWithRetrySucceedsAfterTransientErrors(t *testing.T) {
+	db := setupTestDB()
+
+	// Fail the first two inserts with a lock error, then let the real callback run
+	attempts := 0
+	create := db.Callback().Create().Get("gorm:create")
+	db.Callback().Create().Replace("gorm:create", func(tx *gorm.DB) {
+		attempts++
+		if attempts <= 2 {
+			tx.AddError(errors.New("database is locked (5) (SQLITE_BUSY)"))
+			return
+		}
+		create(tx)
+	})
+
+	db = config.WithRetry(db, 3, time.Millisecond)
+
+	user := models.User{Name: "Retry User", Email: "retry@example.com"}
+	err := db.Create(&user).Error
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.NotZero(t, user.ID)
+}
+
+func TestWithRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	db := setupTestDB()
+
+	attempts := 0
+	db.Callback().Create().Replace("gorm:create", func(tx *gorm.DB) {
+		attempts++
+		tx.AddError(errors.New("database is locked"))
+	})
+
+	db = config.WithRetry(db, 3, time.Millisecond)
+
+	err := db.Create(&models.User{Name: "Retry User", Email: "retry@example.com"}).Error
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestWithRetrySkipsPermanentErrors(t *testing.T) {
+	db := setupTestDB()
+
+	attempts := 0
+	db.Callback().Create().Replace("gorm:create", func(tx *gorm.DB) {
+		attempts++
+		tx.AddError(errors.New("UNIQUE constraint failed: users.email"))
+	})
+
+	db = config.WithRetry(db, 3, time.Millisecond)
+
+	err := db.Create(&models.User{Name: "Retry User", Email: "retry@example.com"}).Error
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestParseIsolationLevel(t *testing.T) {
+	for name, want := range map[string]sql.IsolationLevel{
+		"default":         sql.LevelDefault,
+		"SERIALIZABLE":    sql.LevelSerializable,
+		"read_committed":  sql.LevelReadCommitted,
+		"Repeatable Read": sql.LevelRepeatableRead,
+	} {
+		level, err := config.ParseIsolationLevel(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, want, level, name)
+	}
+
+	_, err := config.ParseIsolationLevel("snapshot")
+	assert.ErrorContains(t, err, `unknown isolation level "snapshot"`)
+}
+
+func TestTxConfigRetriesSerializationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tx.db")
+	dsn := config.SQLiteDSN{Path: path, JournalMode: "WAL"}.Build()
+	db, err := config.TryInitDB(config.DBConfig{Path: dsn, DefaultIsolation: sql.LevelSerializable}, setupTestLogger())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, db.Exec("CREATE TABLE counters (id INTEGER PRIMARY KEY, value INTEGER NOT NULL)").Error)
+	assert.NoError(t, db.Exec("INSERT INTO counters (id, value) VALUES (1, 0)").Error)
+
+	txConfig := config.TxConfig{Isolation: sql.LevelSerializable, MaxAttempts: 5, Backoff: 10 * time.Millisecond}
+
+	// Both transactions read the counter before either writes it, so the
+	// second writer works on a stale snapshot and has to start over
+	var read sync.WaitGroup
+	read.Add(2)
+	var attempts atomic.Int32
+	increment := func() error {
+		first := true
+		return txConfig.Run(db, func(tx *gorm.DB) error {
+			attempts.Add(1)
+			var value int
+			if err := tx.Raw("SELECT value FROM counters WHERE id = 1").Scan(&value).Error; err != nil {
+				return err
+			}
+			if first {
+				first = false
+				read.Done()
+				read.Wait()
+			}
+			return tx.Exec("UPDATE counters SET value = ? WHERE id = 1", value+1).Error
+		})
+	}
+
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() { errs <- increment() }()
+	}
+	assert.NoError(t, <-errs)
+	assert.NoError(t, <-errs)
+
+	var value int
+	db.Raw("SELECT value FROM counters WHERE id = 1").Scan(&value)
+	assert.Equal(t, 2, value, "no increment is lost")
+	assert.Greater(t, int(attempts.Load()), 2, "the losing transaction is retried")
+}
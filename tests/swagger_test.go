@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"encoding/json"
+	"go-api/middleware"
+	"go-api/routes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwaggerSecurityDefinitions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	routes.SetupSwagger(router, middleware.SnakeCase)
+
+	// RequestURI is only populated by httptest.NewRequest, gin-swagger matches on it
+	req := httptest.NewRequest("GET", "/swagger/doc.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var spec struct {
+		SecurityDefinitions map[string]map[string]string         `json:"securityDefinitions"`
+		Paths               map[string]map[string]map[string]any `json:"paths"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &spec)
+	assert.NoError(t, err)
+
+	for name, header := range map[string]string{"ApiKeyAuth": middleware.APIKeyHeader, "BearerAuth": "Authorization"} {
+		if assert.Contains(t, spec.SecurityDefinitions, name) {
+			assert.Equal(t, "apiKey", spec.SecurityDefinitions[name]["type"])
+			assert.Equal(t, header, spec.SecurityDefinitions[name]["name"])
+			assert.Equal(t, "header", spec.SecurityDefinitions[name]["in"])
+		}
+	}
+
+	// Only routes that check the caller are documented as requiring credentials
+	securedPaths := map[string]bool{"/users/{id}/api-keys": true, "/users/{id}/change-email": true, "/users/{id}/api-keys/{key_id}/rotate": true, "/users/{id}/data-export": true, "/users/{id}/password": true}
+
+	for path, operations := range spec.Paths {
+		secured := securedPaths[path] || strings.HasPrefix(path, "/admin/")
+		for method, operation := range operations {
+			if secured {
+				assert.Equal(t, []any{map[string]any{"ApiKeyAuth": []any{}}, map[string]any{"BearerAuth": []any{}}}, operation["security"], "%s %s", method, path)
+			} else {
+				assert.NotContains(t, operation, "security", "%s %s is public but documents a security requirement", method, path)
+			}
+		}
+	}
+}
+
+func TestSwaggerCamelCaseDefinitions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	routes.SetupSwagger(router, middleware.CamelCase)
+
+	req := httptest.NewRequest("GET", "/swagger/doc.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var spec struct {
+		Definitions map[string]struct {
+			Required   []string       `json:"required"`
+			Properties map[string]any `json:"properties"`
+		} `json:"definitions"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &spec))
+
+	assert.Contains(t, spec.Definitions["models.User"].Properties, "createdAt")
+	assert.NotContains(t, spec.Definitions["models.User"].Properties, "created_at")
+	assert.Contains(t, spec.Definitions["controllers.ChangeEmailRequest"].Required, "newEmail")
+}
@@ -0,0 +1,297 @@
+package tests
+
+import (
+	"fmt"
+	"go-api/config"
+	"go-api/controllers"
+	"go-api/middleware"
+	"go-api/models"
+	"go-api/routes"
+	"go-api/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// authenticateTestUser authenticates requests as the user whose ID is in the
+// X-Test-User header, if any
+func authenticateTestUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var caller models.User
+		if id := c.GetHeader("X-Test-User"); id != "" && db.First(&caller, id).Error == nil {
+			ctx := config.ContextWithUserID(c.Request.Context(), caller.ID)
+			c.Request = c.Request.WithContext(config.ContextWithRole(ctx, caller.Role))
+		}
+		c.Next()
+	}
+}
+
+// createPlatformAdmin creates an admin outside any tenant, who picks the
+// tenant with X-Tenant-ID
+func createPlatformAdmin(t *testing.T, db *gorm.DB) models.User {
+	admin := models.User{Name: "Platform Admin", Email: "admin@platform.example", Role: models.RoleAdmin}
+	assert.NoError(t, db.Create(&admin).Error)
+	return admin
+}
+
+func TestTenantScoping(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB()
+	userController := setupTestController(db)
+	router := gin.New()
+	router.Use(authenticateTestUser(db), middleware.TenantContext(db))
+	routes.SetupRoutes(router, routes.WithUserRoutes(userController))
+
+	acme := models.Tenant{Name: "Acme", Slug: "acme"}
+	globex := models.Tenant{Name: "Globex", Slug: "globex"}
+	db.Create(&acme)
+	db.Create(&globex)
+	admin := createPlatformAdmin(t, db)
+
+	as := func(tenant models.Tenant, method, path string, body any) *httptest.ResponseRecorder {
+		req := testutil.NewRequest(method, path, body)
+		req.Header.Set("X-Test-User", strconv.Itoa(int(admin.ID)))
+		req.Header.Set(middleware.TenantIDHeader, strconv.Itoa(int(tenant.ID)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := as(acme, http.MethodPost, "/api/v1/users", models.User{Name: "Alice", Email: "alice@acme.example"})
+	testutil.AssertStatus(t, w, http.StatusCreated)
+	alice := testutil.Decode[models.User](t, w)
+	if assert.NotNil(t, alice.TenantID) {
+		assert.Equal(t, acme.ID, *alice.TenantID)
+	}
+	path := fmt.Sprintf("/api/v1/users/%d", alice.ID)
+
+	w = as(acme, http.MethodGet, "/api/v1/users", nil)
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Len(t, testutil.Decode[[]models.User](t, w), 1)
+	testutil.AssertStatus(t, as(acme, http.MethodGet, path, nil), http.StatusOK)
+
+	// Other tenants can neither see nor change the user
+	w = as(globex, http.MethodGet, "/api/v1/users", nil)
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Empty(t, testutil.Decode[[]models.User](t, w))
+	w = as(globex, http.MethodGet, "/api/v1/users/search?q=alice", nil)
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Empty(t, testutil.Decode[[]models.User](t, w))
+	testutil.AssertStatus(t, as(globex, http.MethodGet, path, nil), http.StatusNotFound)
+	testutil.AssertStatus(t, as(globex, http.MethodPut, path, models.User{Name: "Mallory"}), http.StatusNotFound)
+	testutil.AssertStatus(t, as(globex, http.MethodDelete, path, nil), http.StatusNotFound)
+
+	// Updates can't move the user to another tenant
+	w = as(acme, http.MethodPut, path, models.User{Name: "Alicia", TenantID: &globex.ID})
+	testutil.AssertStatus(t, w, http.StatusOK)
+	var stored models.User
+	db.First(&stored, alice.ID)
+	assert.Equal(t, "Alicia", stored.Name)
+	assert.Equal(t, acme.ID, *stored.TenantID)
+
+	testutil.AssertStatus(t, as(models.Tenant{ID: 999}, http.MethodGet, "/api/v1/users", nil), http.StatusNotFound)
+	req := testutil.NewRequest(http.MethodGet, "/api/v1/users", nil)
+	req.Header.Set("X-Test-User", strconv.Itoa(int(admin.ID)))
+	req.Header.Set(middleware.TenantIDHeader, "acme")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	testutil.AssertStatus(t, w, http.StatusBadRequest)
+}
+
+func TestTenantScopingOfExportsAndSimilarUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB()
+	userController := setupTestController(db)
+	router := gin.New()
+	router.Use(authenticateTestUser(db), middleware.TenantContext(db))
+	routes.SetupRoutes(router, routes.WithUserRoutes(userController), routes.WithAdminRoutes(userController))
+
+	acme := models.Tenant{Name: "Acme", Slug: "acme"}
+	globex := models.Tenant{Name: "Globex", Slug: "globex"}
+	db.Create(&acme)
+	db.Create(&globex)
+	admin := createPlatformAdmin(t, db)
+
+	as := func(tenant models.Tenant, method, path string) *httptest.ResponseRecorder {
+		req := testutil.NewRequest(method, path, nil)
+		req.Header.Set("X-Test-User", strconv.Itoa(int(admin.ID)))
+		req.Header.Set(middleware.TenantIDHeader, strconv.Itoa(int(tenant.ID)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Users with the same tags in both tenants
+	var alice models.User
+	for i, tenant := range []models.Tenant{acme, acme, globex} {
+		user := models.User{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@%s.example", i, tenant.Slug), TenantID: &tenant.ID}
+		assert.NoError(t, db.Create(&user).Error)
+		assert.NoError(t, db.Create(&models.UserTag{UserID: user.ID, Tag: "go"}).Error)
+		if i == 0 {
+			alice = user
+		}
+	}
+
+	w := as(acme, http.MethodGet, fmt.Sprintf("/api/v1/users/%d/similar", alice.ID))
+	testutil.AssertStatus(t, w, http.StatusOK)
+	similar := testutil.Decode[[]controllers.SimilarUser](t, w)
+	if assert.Len(t, similar, 1) {
+		assert.Equal(t, "user1@acme.example", similar[0].User.Email)
+	}
+
+	w = as(globex, http.MethodPost, "/api/v1/admin/exports")
+	testutil.AssertStatus(t, w, http.StatusAccepted)
+	job := testutil.Decode[controllers.ExportJob](t, w)
+	assert.Eventually(t, func() bool {
+		job = testutil.Decode[controllers.ExportJob](t, as(globex, http.MethodGet, "/api/v1/admin/exports/"+job.ID))
+		return job.Status == controllers.ExportStatusDone
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, job.Rows)
+
+	w = as(globex, http.MethodGet, job.DownloadURL)
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Contains(t, w.Body.String(), "user2@globex.example")
+	assert.NotContains(t, w.Body.String(), "acme.example")
+}
+
+func TestTenantScopingOfHistoryAndAnalytics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB()
+	userController := setupTestController(db)
+	router := gin.New()
+	router.Use(authenticateTestUser(db), middleware.TenantContext(db))
+	routes.SetupRoutes(router, routes.WithUserRoutes(userController), routes.WithAnalyticsRoutes(userController))
+
+	acme := models.Tenant{Name: "Acme", Slug: "acme"}
+	globex := models.Tenant{Name: "Globex", Slug: "globex"}
+	db.Create(&acme)
+	db.Create(&globex)
+	admin := createPlatformAdmin(t, db)
+
+	as := func(tenant models.Tenant, method, path string, body any) *httptest.ResponseRecorder {
+		req := testutil.NewRequest(method, path, body)
+		req.Header.Set("X-Test-User", strconv.Itoa(int(admin.ID)))
+		req.Header.Set(middleware.TenantIDHeader, strconv.Itoa(int(tenant.ID)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := as(acme, http.MethodPost, "/api/v1/users", models.User{Name: "Alice", Email: "alice@acme.example"})
+	testutil.AssertStatus(t, w, http.StatusCreated)
+	alice := testutil.Decode[models.User](t, w)
+	testutil.AssertStatus(t, as(acme, http.MethodPut, fmt.Sprintf("/api/v1/users/%d", alice.ID), models.User{Name: "Alicia"}), http.StatusOK)
+	w = as(globex, http.MethodPost, "/api/v1/users", models.User{Name: "Bob", Email: "bob@globex.example"})
+	testutil.AssertStatus(t, w, http.StatusCreated)
+	bob := testutil.Decode[models.User](t, w)
+
+	diffPath := fmt.Sprintf("/api/v1/users/%d/diff?from_version=1&to_version=2", alice.ID)
+	testutil.AssertStatus(t, as(acme, http.MethodGet, diffPath, nil), http.StatusOK)
+	w = as(globex, http.MethodGet, diffPath, nil)
+	testutil.AssertStatus(t, w, http.StatusNotFound)
+	assert.NotContains(t, w.Body.String(), "Alicia")
+
+	db.Model(&models.UserActivity{}).Where("user_id = ?", alice.ID).Update("ip_country", "CZ")
+	db.Model(&models.UserActivity{}).Where("user_id = ?", bob.ID).Update("ip_country", "DE")
+
+	w = as(globex, http.MethodGet, "/api/v1/analytics/users-by-country", nil)
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Equal(t, []controllers.CountryCount{{Country: "DE", Count: 1}}, testutil.Decode[[]controllers.CountryCount](t, w))
+}
+
+func TestTenantFromCaller(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB()
+	mailer := &mockMailer{}
+	userController := setupTestController(db, mailer)
+	router := gin.New()
+	router.Use(authenticateTestUser(db), middleware.DeviceTracker(db, mailer, setupTestLogger()), middleware.TenantContext(db))
+	routes.SetupRoutes(router, routes.WithUserRoutes(userController), routes.WithAdminRoutes(userController))
+
+	acme := models.Tenant{Name: "Acme", Slug: "acme"}
+	globex := models.Tenant{Name: "Globex", Slug: "globex"}
+	db.Create(&acme)
+	db.Create(&globex)
+	admin := createPlatformAdmin(t, db)
+	alice := models.User{Name: "Alice", Email: "alice@acme.example", TenantID: &acme.ID}
+	bob := models.User{Name: "Bob", Email: "bob@globex.example", TenantID: &globex.ID}
+	loner := models.User{Name: "Loner", Email: "loner@example.com"}
+	assert.NoError(t, db.Create(&[]*models.User{&alice, &bob, &loner}).Error)
+
+	as := func(caller models.User, tenant string, method, path string, body any) *httptest.ResponseRecorder {
+		req := testutil.NewRequest(method, path, body)
+		if caller.ID != 0 {
+			req.Header.Set("X-Test-User", strconv.Itoa(int(caller.ID)))
+		}
+		if tenant != "" {
+			req.Header.Set(middleware.TenantIDHeader, tenant)
+		}
+		req.Header.Set(middleware.DeviceIDHeader, "device-"+caller.Email)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	acmeID, globexID := strconv.Itoa(int(acme.ID)), strconv.Itoa(int(globex.ID))
+
+	// Members act on their own tenant without naming it, and only on it
+	w := as(alice, "", http.MethodGet, "/api/v1/users", nil)
+	testutil.AssertStatus(t, w, http.StatusOK)
+	users := testutil.Decode[[]models.User](t, w)
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, alice.ID, users[0].ID)
+	}
+	testutil.AssertStatus(t, as(alice, acmeID, http.MethodGet, "/api/v1/users", nil), http.StatusOK)
+	testutil.AssertStatus(t, as(alice, globexID, http.MethodGet, "/api/v1/users", nil), http.StatusForbidden)
+	testutil.AssertStatus(t, as(alice, "", http.MethodGet, fmt.Sprintf("/api/v1/users/%d", bob.ID), nil), http.StatusNotFound)
+
+	// Only admins outside any tenant may pick one
+	testutil.AssertStatus(t, as(loner, acmeID, http.MethodGet, "/api/v1/users", nil), http.StatusForbidden)
+	testutil.AssertStatus(t, as(models.User{}, acmeID, http.MethodGet, "/api/v1/users", nil), http.StatusUnauthorized)
+	w = as(admin, globexID, http.MethodGet, "/api/v1/users", nil)
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Len(t, testutil.Decode[[]models.User](t, w), 1)
+
+	// Keys, devices and audit rows belong to the caller's tenant, admins' devices to none
+	testutil.AssertStatus(t, as(alice, "", http.MethodPost, fmt.Sprintf("/api/v1/users/%d/api-keys", alice.ID), controllers.CreateAPIKeyRequest{Name: "ci"}), http.StatusCreated)
+	testutil.AssertStatus(t, as(alice, "", http.MethodPut, fmt.Sprintf("/api/v1/users/%d", alice.ID), models.User{Name: "Alicia"}), http.StatusOK)
+	var key models.UserAPIKey
+	var device models.UserDevice
+	var entry models.AuditLog
+	assert.NoError(t, db.Where("user_id = ?", alice.ID).First(&key).Error)
+	assert.NoError(t, db.Where("user_id = ?", alice.ID).First(&device).Error)
+	assert.NoError(t, db.Where("entity_id = ?", alice.ID).Last(&entry).Error)
+	for name, tenantID := range map[string]*uint{"api key": key.TenantID, "device": device.TenantID, "audit entry": entry.TenantID} {
+		if assert.NotNil(t, tenantID, name) {
+			assert.Equal(t, acme.ID, *tenantID, name)
+		}
+	}
+	var adminDevice models.UserDevice
+	assert.NoError(t, db.Where("user_id = ?", admin.ID).First(&adminDevice).Error)
+	assert.Nil(t, adminDevice.TenantID)
+
+	// Invited users join the tenant of the invite
+	w = as(admin, acmeID, http.MethodPost, "/api/v1/admin/users/invite", map[string]any{"email": "carol@acme.example"})
+	testutil.AssertStatus(t, w, http.StatusCreated)
+	invite := testutil.Decode[controllers.InviteResponse](t, w)
+	w = testutil.POST(router, "/api/v1/invites/"+invite.Token+"/accept", map[string]any{"name": "Carol", "password": "correct horse battery"})
+	testutil.AssertStatus(t, w, http.StatusCreated)
+	carol := testutil.Decode[models.User](t, w)
+	if assert.NotNil(t, carol.TenantID) {
+		assert.Equal(t, acme.ID, *carol.TenantID)
+	}
+	var registered models.AuditLog
+	assert.NoError(t, db.Where("entity_id = ?", carol.ID).Last(&registered).Error)
+	if assert.NotNil(t, registered.TenantID) {
+		assert.Equal(t, acme.ID, *registered.TenantID)
+	}
+}
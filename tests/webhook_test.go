@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-api/config"
+	"go-api/controllers"
+	"go-api/models"
+	"go-api/routes"
+	"go-api/testutil"
+	"go-api/webhook"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupAdminRouter(userController *controllers.UserController) *gin.Engine {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(config.ContextWithRole(c.Request.Context(), models.RoleAdmin))
+		c.Next()
+	})
+	return routes.SetupRoutes(router, routes.WithAdminRoutes(userController))
+}
+
+func TestSendTestWebhook(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB()
+	userController := setupTestController(db)
+	userController.Webhooks = &webhook.Sender{Client: http.DefaultClient, Attempts: 3, Backoff: time.Millisecond}
+	router := setupAdminRouter(userController)
+
+	var body []byte
+	var signature string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(webhook.SignatureHeader)
+	}))
+	defer receiver.Close()
+
+	hook := models.Webhook{URL: receiver.URL, Secret: "hook-secret"}
+	db.Create(&hook)
+
+	w := testutil.POST(router, fmt.Sprintf("/api/v1/admin/webhooks/%d/test", hook.ID), nil)
+	testutil.AssertStatus(t, w, http.StatusOK)
+	assert.Equal(t, controllers.WebhookTestResponse{Delivered: true, HTTPStatus: http.StatusOK}, testutil.Decode[controllers.WebhookTestResponse](t, w))
+
+	assert.Equal(t, webhook.Sign("hook-secret", body), signature)
+	var payload map[string]string
+	assert.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, "test", payload["event"])
+	_, err := time.Parse(time.RFC3339, payload["timestamp"])
+	assert.NoError(t, err)
+
+	testutil.AssertStatus(t, testutil.POST(router, "/api/v1/admin/webhooks/999/test", nil), http.StatusNotFound)
+}
+
+func TestSendTestWebhookRetriesFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB()
+	userController := setupTestController(db)
+	userController.Webhooks = &webhook.Sender{Client: http.DefaultClient, Attempts: 3, Backoff: time.Millisecond}
+	router := setupAdminRouter(userController)
+
+	var attempts atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+
+	hook := models.Webhook{URL: receiver.URL, Secret: "hook-secret"}
+	db.Create(&hook)
+
+	w := testutil.POST(router, fmt.Sprintf("/api/v1/admin/webhooks/%d/test", hook.ID), nil)
+	testutil.AssertStatus(t, w, http.StatusOK)
+
+	response := testutil.Decode[controllers.WebhookTestResponse](t, w)
+	assert.False(t, response.Delivered)
+	assert.Equal(t, http.StatusBadGateway, response.HTTPStatus)
+	assert.Contains(t, response.Error, "502")
+	assert.Equal(t, int32(3), attempts.Load())
+}
@@ -0,0 +1,91 @@
+// Package testutil provides helpers for exercising the API in tests
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-api/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// NewRequest builds a test request. Non-nil bodies are encoded as JSON unless
+// they already are a string or []byte.
+func NewRequest(method, path string, body any) *http.Request {
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	case []byte:
+		reader = bytes.NewBuffer(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			panic(fmt.Sprintf("testutil: marshal request body: %v", err))
+		}
+		reader = bytes.NewBuffer(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+// Do sends a request built by NewRequest to handler and records the response
+func Do(handler http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, NewRequest(method, path, body))
+	return w
+}
+
+func GET(handler http.Handler, path string) *httptest.ResponseRecorder {
+	return Do(handler, http.MethodGet, path, nil)
+}
+
+func POST(handler http.Handler, path string, body any) *httptest.ResponseRecorder {
+	return Do(handler, http.MethodPost, path, body)
+}
+
+func PUT(handler http.Handler, path string, body any) *httptest.ResponseRecorder {
+	return Do(handler, http.MethodPut, path, body)
+}
+
+func DELETE(handler http.Handler, path string) *httptest.ResponseRecorder {
+	return Do(handler, http.MethodDelete, path, nil)
+}
+
+// AssertStatus reports an error including the response body when the status code differs
+func AssertStatus(t testing.TB, w *httptest.ResponseRecorder, code int) bool {
+	t.Helper()
+	if w.Code != code {
+		t.Errorf("expected status %d, got %d: %s", code, w.Code, w.Body.String())
+		return false
+	}
+	return true
+}
+
+// Decode unmarshals the JSON response body into T, failing the test on error
+func Decode[T any](t testing.TB, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var result T
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode response body %q: %v", w.Body.String(), err)
+	}
+	return result
+}
+
+// MustCreateUser creates a user through the API and fails the test if it is not created
+func MustCreateUser(t testing.TB, handler http.Handler, name, email string) models.User {
+	t.Helper()
+	w := POST(handler, "/api/v1/users", models.User{Name: name, Email: email})
+	if !AssertStatus(t, w, http.StatusCreated) {
+		t.FailNow()
+	}
+	return Decode[models.User](t, w)
+}
@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-api/models"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the request body, prefixed with "sha256="
+const SignatureHeader = "X-Webhook-Signature"
+
+// Sender delivers signed webhook payloads, retrying failed attempts
+type Sender struct {
+	Client *http.Client
+	// Attempts is how many times a delivery is tried before giving up
+	Attempts int
+	// Backoff is the wait before the second attempt, doubling after each failure
+	Backoff time.Duration
+}
+
+func NewSender() *Sender {
+	return &Sender{
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		Attempts: 3,
+		Backoff:  500 * time.Millisecond,
+	}
+}
+
+// Sign returns the SignatureHeader value for body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver POSTs payload as JSON to the webhook. Network errors and 5xx
+// responses are retried. It returns the status of the last response, or 0
+// when the endpoint could not be reached.
+func (s *Sender) Deliver(ctx context.Context, hook models.Webhook, payload any) (int, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("encode webhook payload: %w", err)
+	}
+	signature := Sign(hook.Secret, body)
+
+	var status int
+	backoff := s.Backoff
+	for attempt := 1; ; attempt++ {
+		status, err = s.post(ctx, hook.URL, body, signature)
+		if err == nil && status < http.StatusInternalServerError {
+			break
+		}
+		if attempt >= s.Attempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	if err != nil {
+		return status, err
+	}
+	if status < 200 || status >= 300 {
+		return status, fmt.Errorf("webhook responded with status %d", status)
+	}
+	return status, nil
+}
+
+func (s *Sender) post(ctx context.Context, url string, body []byte, signature string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, signature)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}